@@ -0,0 +1,352 @@
+// converge clusters and sites to a manifest
+// the manifest declares the nodes of clusters and every site. apply adds,
+// changes and removes nodes, creates, updates, migrates, enables and
+// disables sites in one transaction. sites missing from the manifest are
+// only removed with prune. export writes the current state as a manifest.
+package apply
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	"nickneal.dev/go-proxymanager/proxy"
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+type Changes struct {
+	Tx *transaction.Transaction
+	// what the transaction changes, one line each
+	Actions []string
+	// sites on the server but not in the manifest, kept without prune
+	Unmanaged []string
+	// enabled sites whose certificate is installed once applied
+	Ssl []ManifestSite
+}
+
+func (c *Changes) add(format string, args ...any) {
+	c.Actions = append(c.Actions, fmt.Sprintf(format, args...))
+}
+
+// stage everything needed to get from the current state to manifest,
+// nothing is written
+func Plan(manifest Manifest, prune bool) (*Changes, error) {
+	changes := &Changes{Tx: transaction.New()}
+	tx := changes.Tx
+
+	hostsFile, err := loadbalancer.ReadHostsFile()
+	if err != nil {
+		return nil, fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	// remove nodes first, a host may move between clusters
+	changedClusters := make(map[string]bool)
+	for _, cluster := range manifest.Clusters {
+		block := hostsFile.GetCluster(cluster.Name)
+		if block == nil {
+			continue
+		}
+
+		desired := make(map[string]bool)
+		for _, node := range cluster.Nodes {
+			desired[node.Host] = true
+		}
+
+		// disabled nodes aren't part of manifests, they are left alone
+		for _, host := range block.Hosts.List() {
+			if host.Enabled && !desired[host.Name] {
+				_ = block.Hosts.DelHost(host.Name)
+				changedClusters[cluster.Name] = true
+				tx.AddHost(host.Name)
+				changes.add("remove host '%v' from cluster '%v'", host.Name, cluster.Name)
+			}
+		}
+	}
+
+	for _, cluster := range manifest.Clusters {
+		block := hostsFile.GetCluster(cluster.Name)
+		if block == nil {
+			block, _ = hostsFile.AddCluster(cluster.Name)
+			changedClusters[cluster.Name] = true
+			changes.add("create cluster '%v'", cluster.Name)
+		}
+
+		// sites of the cluster are created in its config dir
+		if !proxy.ClusterExists(cluster.Name) {
+			loadbalancer.CreateClusterConfigDir(tx, cluster.Name)
+		}
+
+		for _, node := range cluster.Nodes {
+			existing, found := block.Hosts.Get(node.Host)
+			switch {
+			case !found:
+				if other := hostCluster(hostsFile, node.Host); other != "" {
+					return nil, errs.New(errs.ErrHostExists, "Host '%v' exists in cluster '%v'.", node.Host, other)
+				}
+				_ = block.Hosts.AddHost(node.Host, node.IpAddress)
+				changes.add("add host '%v' to cluster '%v'", node.Host, cluster.Name)
+			case existing.IpAddress != node.IpAddress:
+				_ = block.Hosts.SetIpAddress(node.Host, node.IpAddress)
+				changes.add("change ip address of host '%v' in cluster '%v' to '%v'", node.Host, cluster.Name, node.IpAddress)
+			default:
+				continue
+			}
+
+			changedClusters[cluster.Name] = true
+			tx.AddHost(node.Host)
+		}
+	}
+
+	if len(changedClusters) > 0 {
+		tx.WriteFile(loadbalancer.GetHostsFilePath(), []byte(hostsFile.String()), 0644)
+	}
+
+	managed := make(map[string]bool)
+	sslSnippet := false
+	for _, site := range manifest.Sites {
+		managed[site.Hostname] = true
+
+		written, err := planSite(changes, hostsFile, site)
+		if err != nil {
+			return nil, err
+		}
+
+		if site.Ssl && written {
+			sslSnippet = true
+		}
+		if site.Ssl && site.IsEnabled() {
+			changes.Ssl = append(changes.Ssl, site)
+		}
+	}
+
+	// serve acme challenges, certificates are requested once enabled
+	if sslSnippet {
+		tx.Mkdir(filepath.Dir(ssl.GetSnippetPath()), 0755)
+		tx.WriteLines(ssl.GetSnippetPath(), ssl.GetAcmeSnippet(), 0644)
+	}
+
+	pruned, err := planUnmanaged(changes, managed, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	// point upstreams of the remaining sites at the new nodes
+	var clusters []string
+	for cluster := range changedClusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	for _, cluster := range clusters {
+		tx.AddCluster(cluster)
+
+		// managed sites were rendered for the new nodes above
+		remaining := false
+		for _, hostname := range loadbalancer.GetClusterSites(cluster) {
+			remaining = remaining || (!managed[hostname] && !pruned[hostname])
+		}
+		if !remaining {
+			continue
+		}
+
+		block := hostsFile.GetCluster(cluster)
+		configs, renderErr := sitespec.RenderCluster(cluster, block.Hosts.Names())
+		if renderErr != nil {
+			return nil, fmt.Errorf("There was an issue updating site upstreams: %w", renderErr)
+		}
+
+		var configPaths []string
+		for configPath := range configs {
+			hostname := strings.TrimSuffix(filepath.Base(configPath), ".conf")
+			if !managed[hostname] && !pruned[hostname] {
+				configPaths = append(configPaths, configPath)
+			}
+		}
+		sort.Strings(configPaths)
+
+		for _, configPath := range configPaths {
+			tx.WriteLines(configPath, configs[configPath], 0644)
+		}
+	}
+
+	return changes, nil
+}
+
+// cluster host is a node of, "" if it's in none
+func hostCluster(hostsFile *loadbalancer.HostsFile, host string) string {
+	for _, cluster := range hostsFile.Clusters() {
+		if hostsFile.GetCluster(cluster).Hosts.HostExists(host) {
+			return cluster
+		}
+	}
+
+	return ""
+}
+
+// stage the config, spec and symlink of site. returns true when its config
+// is written.
+func planSite(changes *Changes, hostsFile *loadbalancer.HostsFile, site ManifestSite) (bool, error) {
+	tx := changes.Tx
+
+	var nodes []string
+	if site.Cluster != "" {
+		block := hostsFile.GetCluster(site.Cluster)
+		if block == nil {
+			return false, errs.New(errs.ErrClusterNotFound, "Cluster '%v' of site '%v' does not exist.", site.Cluster, site.Hostname)
+		}
+
+		if block.Hosts.Len() == 0 {
+			return false, errs.New(errs.ErrConflict, "Cluster '%v' of site '%v' has no assigned nodes.", site.Cluster, site.Hostname)
+		}
+		nodes = block.Hosts.Names()
+	}
+
+	spec := site.Spec()
+	configLines, err := sitespec.Render(spec, nodes)
+	if err != nil {
+		return false, fmt.Errorf("There was an issue rendering '%v': %w", site.Hostname, err)
+	}
+
+	configPath := sitespec.GetConfigPath(site.Cluster, site.Hostname)
+	enabledPath := proxy.GetEnabledConfigDir() + "/" + site.Hostname + ".conf"
+	currentCluster, found := proxy.GetSiteCluster(site.Hostname)
+	enabled := found && proxy.SiteEnabled(site.Hostname)
+	migrated := found && currentCluster != site.Cluster
+
+	written := true
+	switch {
+	case !found:
+		changes.add("create site '%v'", site.Hostname)
+	case migrated:
+		if site.Cluster != "" {
+			changes.add("migrate site '%v' to cluster '%v'", site.Hostname, site.Cluster)
+		} else {
+			changes.add("migrate site '%v' to '%v'", site.Hostname, site.IpAddress)
+		}
+	default:
+		currentSpec, specErr := sitespec.Load(site.Hostname)
+		currentLines, readErr := ssl.ReadConfigLines(configPath)
+		if readErr != nil {
+			return false, fmt.Errorf("There was an issue reading the config of '%v': %w", site.Hostname, readErr)
+		}
+
+		written = specErr != nil || currentSpec != spec || strings.Join(currentLines, "\n") != strings.Join(configLines, "\n")
+		if written {
+			changes.add("update site '%v'", site.Hostname)
+		}
+	}
+
+	if written {
+		tx.AddCluster(currentCluster, site.Cluster)
+		tx.AddHost(site.Hostname)
+		tx.WriteLines(configPath, configLines, 0644)
+		if err := sitespec.Stage(tx, spec); err != nil {
+			return false, fmt.Errorf("There was an issue saving the spec of '%v': %w", site.Hostname, err)
+		}
+	}
+
+	switch {
+	case site.IsEnabled() && (!enabled || migrated):
+		tx.Symlink(configPath, enabledPath)
+		if !enabled {
+			changes.add("enable site '%v'", site.Hostname)
+		}
+	case !site.IsEnabled() && enabled:
+		tx.Remove(enabledPath)
+		changes.add("disable site '%v'", site.Hostname)
+	}
+
+	// the old config goes once the new one and its symlink are in place
+	if migrated {
+		tx.Remove(sitespec.GetConfigPath(currentCluster, site.Hostname))
+	}
+
+	return written, nil
+}
+
+// sites that exist but aren't in the manifest are removed with prune and
+// reported without. returns the removed sites.
+func planUnmanaged(changes *Changes, managed map[string]bool, prune bool) (map[string]bool, error) {
+	tx := changes.Tx
+	pruned := make(map[string]bool)
+
+	clusters, err := proxy.GetClusters()
+	if err != nil {
+		return nil, fmt.Errorf("There was an issue reading sites: %w", err)
+	}
+
+	for _, cluster := range append([]string{""}, clusters...) {
+		availableSites, _ := proxy.GetAvailableSites(cluster)
+		for _, hostname := range availableSites {
+			if managed[hostname] {
+				continue
+			}
+
+			if !prune {
+				changes.Unmanaged = append(changes.Unmanaged, hostname)
+				continue
+			}
+
+			tx.AddCluster(cluster)
+			tx.AddHost(hostname)
+			if proxy.SiteEnabled(hostname) {
+				tx.Remove(proxy.GetEnabledConfigDir() + "/" + hostname + ".conf")
+			}
+			tx.Remove(sitespec.GetConfigPath(cluster, hostname))
+			tx.Remove(sitespec.GetSpecPath(hostname))
+
+			pruned[hostname] = true
+			changes.add("remove site '%v'", hostname)
+		}
+	}
+
+	return pruned, nil
+}
+
+// converge to the manifest at filePath, see Plan
+func Apply(filePath string, prune bool) error {
+	manifest, err := LoadManifest(filePath)
+	if err != nil {
+		return errs.Wrap(errs.ErrInvalidArgument, err, "There was an issue reading manifest '%v'", filePath)
+	}
+
+	if err := manifest.Validate(); err != nil {
+		return errs.Wrap(errs.ErrInvalidArgument, err, "Manifest '%v' is invalid", filePath)
+	}
+
+	changes, err := Plan(manifest, prune)
+	if err != nil {
+		return err
+	}
+
+	for _, hostname := range changes.Unmanaged {
+		fmt.Printf("Site '%v' is not in the manifest, use '--prune' to remove it.\n", hostname)
+	}
+
+	if len(changes.Actions) == 0 {
+		fmt.Println("Nothing to change.")
+		return nil
+	}
+
+	for _, action := range changes.Actions {
+		fmt.Println(action)
+	}
+
+	// one reload for everything, all changes are rolled back on error
+	if err := loadbalancer.Commit(changes.Tx, transaction.NginxReload); err != nil {
+		return err
+	}
+
+	fmt.Printf("%v change(s) applied.\n", len(changes.Actions))
+
+	for _, site := range changes.Ssl {
+		proxy.InstallPendingSsl(site.Cluster, site.Hostname)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package apply
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+func Getwd() string {
+	cwd, _ := os.Getwd()
+	return cwd
+}
+
+func GetConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return Getwd() + "\\..\\test_configs\\proxymanager.yml"
+	} else {
+		return Getwd() + "/../test_configs/proxymanager.yml"
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	data := `
+clusters:
+  - name: Prod
+    nodes:
+      - host: Node01.local
+        ip: 10.0.0.1
+sites:
+  - hostname: App.local
+    cluster: PROD
+    port: 8080
+  - hostname: legacy.local
+    ip: 10.0.1.5
+    enabled: false
+`
+	manifest, err := ParseManifest([]byte(data))
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'", err)
+	}
+
+	if manifest.Clusters[0].Name != "prod" || manifest.Clusters[0].Nodes[0].Host != "node01.local" {
+		t.Errorf("Expected lowercase cluster and host, received '%v'", manifest.Clusters[0])
+	}
+
+	site := manifest.Sites[0]
+	if site.Hostname != "app.local" || site.Cluster != "prod" || site.Port != "8080" || !site.IsEnabled() {
+		t.Errorf("Unexpected site '%v'", site)
+	}
+
+	if manifest.Sites[1].IsEnabled() {
+		t.Errorf("Expected site '%v' to be disabled", manifest.Sites[1].Hostname)
+	}
+
+	if _, err := ParseManifest([]byte("sites:\n  - hostname: a.local\n    backend: 10.0.0.1\n")); err == nil {
+		t.Errorf("Expected error for unknown field")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Manifest string
+		Expected string
+	}{
+		{"clusters:\n  - name: prod\n    nodes:\n      - {host: node01.local, ip: 10.0.0.1}\nsites:\n  - {hostname: a.local, cluster: prod, port: 8080}\n", ""},
+		{"clusters:\n  - name: prod\n  - name: prod\n", "cluster 'prod' is declared twice"},
+		{"clusters:\n  - name: prod\n    nodes:\n      - {host: node01.local, ip: 10.0.0.300}\n", "cluster 'prod': invalid ip address '10.0.0.300' for host 'node01.local'"},
+		{"clusters:\n  - name: a\n    nodes:\n      - {host: node01.local, ip: 10.0.0.1}\n  - name: b\n    nodes:\n      - {host: node01.local, ip: 10.0.0.2}\n", "host 'node01.local' is declared in clusters 'a' and 'b'"},
+		{"clusters:\n  - name: prod\n    nodes:\n      - {host: node01.local, ip: 10.0.0.1}\n      - {host: node02.local, ip: 10.0.0.1}\n", ""},
+		{"sites:\n  - {hostname: a.local}\n", "site 'a.local': specify either 'cluster' or 'ip'"},
+		{"sites:\n  - {hostname: a.local, cluster: prod, ip: 10.0.0.1}\n", "site 'a.local': specify either 'cluster' or 'ip'"},
+		{"sites:\n  - {hostname: a.local, cluster: prod}\n", "site 'a.local': 'port' is required with 'cluster'"},
+		{"sites:\n  - {hostname: a.local, ip: 10.0.0.1, port: 70000}\n", "site 'a.local': port '70000' is invalid, use a port in the range 1024-49151"},
+		{"sites:\n  - {hostname: a.local, ip: 10.0.0.1}\n  - {hostname: a.local, ip: 10.0.0.2}\n", "site 'a.local' is declared twice"},
+		{"sites:\n  - {hostname: a.local, ip: 10.0.0.1, template: grpc}\n  - {hostname: b.local, ip: 10.0.0.1, template: Default}\n", ""},
+		{"sites:\n  - {hostname: a.local, ip: 10.0.0.1, template: static}\n", "site 'a.local': template 'static' does not exist"},
+		{"sites:\n  - {hostname: a.local, cluster: prod, port: 8080, template: grpc}\n", "site 'a.local': template 'grpc' has no config for k8s sites"},
+	}
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	for _, test := range tests {
+		manifest, err := ParseManifest([]byte(test.Manifest))
+		if err != nil {
+			t.Errorf("Expected no parse error, received '%v'", err)
+			continue
+		}
+
+		var output string
+		if err := manifest.Validate(); err != nil {
+			output = err.Error()
+		}
+
+		if output != test.Expected {
+			t.Errorf("Expected '%v', received '%v'", test.Expected, output)
+		}
+	}
+
+	os.Clearenv()
+}
+
+func TestPlan(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	data := `
+clusters:
+  - name: test1
+    nodes:
+      - {host: test01.local, ip: 10.0.0.1}
+      - {host: test02.local, ip: 10.0.0.2}
+  - name: prod
+    nodes:
+      - {host: prod01.local, ip: 10.0.5.1}
+sites:
+  - {hostname: test.local, cluster: test1, port: 8080}
+  - {hostname: new.local, ip: 10.0.9.9, enabled: false}
+`
+	manifest, _ := ParseManifest([]byte(data))
+
+	tests := []struct {
+		Prune     bool
+		Expected  []string
+		Unmanaged []string
+	}{
+		{false, []string{
+			"add host 'test02.local' to cluster 'test1'",
+			"create cluster 'prod'",
+			"add host 'prod01.local' to cluster 'prod'",
+			"update site 'test.local'",
+			"create site 'new.local'",
+		}, []string{"single.local"}},
+		{true, []string{
+			"add host 'test02.local' to cluster 'test1'",
+			"create cluster 'prod'",
+			"add host 'prod01.local' to cluster 'prod'",
+			"update site 'test.local'",
+			"create site 'new.local'",
+			"remove site 'single.local'",
+		}, nil},
+	}
+
+	for _, test := range tests {
+		changes, err := Plan(manifest, test.Prune)
+		if err != nil {
+			t.Errorf("Expected no error, received '%v'", err)
+			continue
+		}
+
+		if strings.Join(changes.Actions, "\n") != strings.Join(test.Expected, "\n") {
+			t.Errorf("Prune %v: Expected '%v', received '%v'", test.Prune, test.Expected, changes.Actions)
+		}
+
+		if strings.Join(changes.Unmanaged, ",") != strings.Join(test.Unmanaged, ",") {
+			t.Errorf("Prune %v: Expected unmanaged '%v', received '%v'", test.Prune, test.Unmanaged, changes.Unmanaged)
+		}
+	}
+
+	os.Clearenv()
+}
+
+func TestPlanErrors(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	tests := []struct {
+		Manifest string
+		Error    error
+	}{
+		{"sites:\n  - {hostname: a.local, cluster: missing, port: 8080}\n", errs.ErrClusterNotFound},
+		{"sites:\n  - {hostname: a.local, cluster: empty, port: 8080}\n", errs.ErrConflict},
+		{"clusters:\n  - name: test1\n    nodes:\n      - {host: app01.local, ip: 10.0.0.9}\n", errs.ErrHostExists},
+	}
+
+	for _, test := range tests {
+		manifest, _ := ParseManifest([]byte(test.Manifest))
+		_, err := Plan(manifest, false)
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Expected '%v', received '%v'", test.Error, err)
+		}
+	}
+
+	os.Clearenv()
+}
+
+// an exported manifest applies without changes
+func TestCurrent(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	manifest, warnings, err := Current("")
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'", err)
+	}
+
+	// fixture configs weren't written by proxymanager
+	if len(warnings) != 2 {
+		t.Errorf("Expected 2 warnings, received '%v'", warnings)
+	}
+
+	var clusters []string
+	for _, cluster := range manifest.Clusters {
+		clusters = append(clusters, cluster.Name)
+	}
+	if strings.Join(clusters, ",") != "test1,test2,empty" {
+		t.Errorf("Expected clusters 'test1,test2,empty', received '%v'", clusters)
+	}
+
+	// app02.local and app03.local of the fixture share an ip address
+	data, _ := yaml.Marshal(manifest)
+	parsed, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("Expected no parse error, received '%v'", err)
+	}
+	if err := parsed.Validate(); err != nil {
+		t.Errorf("Expected valid manifest, received '%v'", err)
+	}
+
+	changes, err := Plan(parsed, false)
+	if err != nil || len(changes.Actions) > 0 {
+		t.Errorf("Expected no changes, received '%v' '%v'", changes.Actions, err)
+	}
+
+	manifest, _, _ = Current("test2")
+	if len(manifest.Clusters) != 1 || len(manifest.Clusters[0].Nodes) != 3 {
+		t.Errorf("Expected cluster 'test2' with 3 nodes, received '%v'", manifest.Clusters)
+	}
+
+	if _, _, err := Current("test3"); !errors.Is(err, errs.ErrClusterNotFound) {
+		t.Errorf("Expected '%v', received '%v'", errs.ErrClusterNotFound, err)
+	}
+
+	os.Clearenv()
+}
+
+// disabled nodes and sites of clusters missing from the hosts file aren't
+// exported, apply leaves the disabled nodes alone
+func TestCurrentSkips(t *testing.T) {
+	dir := t.TempDir()
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	config := settings.LoadConfig()
+	config.LoadBalancer.HostsFile = dir + "/hosts"
+	config.Proxy.NginxDir = dir + "/nginx"
+	config.Proxy.SpecDir = dir + "/specs"
+
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(dir+"/proxymanager.yml", configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+
+	hosts := "# DO NOT EDIT, USE proxymanager\n### LB_K8S(prod)\n10.0.0.1 node01.local node02.local\n#10.0.0.2 node02.local\n### LB_K8S_END\n"
+	os.WriteFile(dir+"/hosts", []byte(hosts), 0644)
+	os.MkdirAll(dir+"/nginx/sites-available/k8s_old", 0755)
+	os.MkdirAll(dir+"/nginx/sites-enabled", 0755)
+	os.WriteFile(dir+"/nginx/sites-available/k8s_old/old.local.conf", []byte("server {}\n"), 0644)
+
+	manifest, warnings, err := Current("")
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'", err)
+	}
+
+	expected := "Site 'old.local' skipped, cluster 'old' is not in the hosts file"
+	if strings.Join(warnings, "\n") != expected {
+		t.Errorf("Expected '%v', received '%v'", expected, warnings)
+	}
+
+	if len(manifest.Clusters) != 1 || len(manifest.Clusters[0].Nodes) != 1 || manifest.Clusters[0].Nodes[0].Host != "node01.local" {
+		t.Errorf("Expected cluster 'prod' with node01.local, received '%v'", manifest.Clusters)
+	}
+
+	if len(manifest.Sites) != 0 {
+		t.Errorf("Expected no sites, received '%v'", manifest.Sites)
+	}
+
+	changes, err := Plan(manifest, false)
+	if err != nil || len(changes.Actions) > 0 {
+		t.Errorf("Expected no changes, received '%v' '%v'", changes.Actions, err)
+	}
+
+	os.Clearenv()
+}
@@ -0,0 +1,137 @@
+package apply
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	"nickneal.dev/go-proxymanager/proxy"
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+)
+
+// manifest of the clusters in the hosts file and every site, cluster ""
+// for the whole server. sites without a spec are imported from their
+// config, sites that can't be and sites of clusters missing from the hosts
+// file are returned as warnings. disabled nodes and moved traffic are not
+// part of manifests.
+func Current(cluster string) (Manifest, []string, error) {
+	var manifest Manifest
+	var warnings []string
+
+	// make cluster name lowercase
+	cluster = strings.ToLower(cluster)
+
+	hostsFile, err := loadbalancer.ReadHostsFile()
+	if err != nil {
+		return manifest, nil, fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	clusters := hostsFile.Clusters()
+	if cluster != "" {
+		if hostsFile.GetCluster(cluster) == nil {
+			return manifest, nil, errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
+		}
+		clusters = []string{cluster}
+	}
+
+	for _, name := range clusters {
+		manifestCluster := ManifestCluster{Name: name, Nodes: []ManifestNode{}}
+		block := hostsFile.GetCluster(name)
+		for _, host := range block.Hosts.List() {
+			if !host.Enabled {
+				continue
+			}
+			manifestCluster.Nodes = append(manifestCluster.Nodes, ManifestNode{Host: host.Name, IpAddress: host.IpAddress})
+		}
+		manifest.Clusters = append(manifest.Clusters, manifestCluster)
+	}
+
+	enabledSites, err := proxy.GetEnabledSites()
+	if err != nil {
+		return manifest, nil, fmt.Errorf("There was an issue reading enabled sites: %w", err)
+	}
+
+	// sites outside of clusters belong to the whole server only
+	siteClusters := clusters
+	if cluster == "" {
+		configClusters, clusterErr := proxy.GetClusters()
+		if clusterErr != nil {
+			return manifest, nil, fmt.Errorf("There was an issue reading sites: %w", clusterErr)
+		}
+		siteClusters = append([]string{""}, configClusters...)
+	}
+
+	for _, siteCluster := range siteClusters {
+		availableSites, _ := proxy.GetAvailableSites(siteCluster)
+
+		// apply can't create them without their cluster
+		if siteCluster != "" && hostsFile.GetCluster(siteCluster) == nil {
+			for _, hostname := range availableSites {
+				warnings = append(warnings, fmt.Sprintf("Site '%v' skipped, cluster '%v' is not in the hosts file", hostname, siteCluster))
+			}
+			continue
+		}
+
+		for _, hostname := range availableSites {
+			spec, specErr := sitespec.Load(hostname)
+			if specErr != nil {
+				fileLines, readErr := ssl.ReadConfigLines(sitespec.GetConfigPath(siteCluster, hostname))
+				if readErr != nil {
+					warnings = append(warnings, fmt.Sprintf("Site '%v' skipped, its config could not be read: %v", hostname, readErr))
+					continue
+				}
+
+				var importErr error
+				spec, importErr = sitespec.Import(siteCluster, hostname, fileLines)
+				if importErr != nil {
+					warnings = append(warnings, fmt.Sprintf("Site '%v' skipped, it has no spec and could not be imported: %v", hostname, importErr))
+					continue
+				}
+			}
+
+			enabled := slices.Contains(enabledSites, hostname)
+			manifest.Sites = append(manifest.Sites, ManifestSite{
+				Hostname:          spec.Hostname,
+				Cluster:           spec.Cluster,
+				IpAddress:         spec.IpAddress,
+				Port:              spec.Port,
+				Uri:               spec.Uri,
+				Ssl:               spec.Ssl,
+				SslBypassFirewall: spec.SslBypassFirewall,
+				ProxySsl:          spec.ProxySsl,
+				ProxySslVerifyOff: spec.ProxySslVerifyOff,
+				Template:          spec.Template,
+				Enabled:           &enabled,
+			})
+		}
+	}
+
+	return manifest, warnings, nil
+}
+
+// print the current state as a manifest for apply. warnings go to stderr
+// so the output can be redirected to a file.
+func Export(cluster string) error {
+	manifest, warnings, err := Current(cluster)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("There was an issue writing the manifest: %w", err)
+	}
+
+	fmt.Print(string(data))
+
+	return nil
+}
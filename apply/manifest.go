@@ -0,0 +1,191 @@
+package apply
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+	"nickneal.dev/go-proxymanager/utils/validate"
+)
+
+// desired state of clusters and sites, e.g.
+//
+//	clusters:
+//	  - name: prod
+//	    nodes:
+//	      - host: node01.local
+//	        ip: 10.0.0.1
+//	sites:
+//	  - hostname: app.example.com
+//	    cluster: prod
+//	    port: 8080
+//	    ssl: true
+//	  - hostname: legacy.example.com
+//	    ip: 10.0.1.5
+//	    enabled: false
+type Manifest struct {
+	Clusters []ManifestCluster `yaml:"clusters,omitempty"`
+	Sites    []ManifestSite    `yaml:"sites,omitempty"`
+}
+
+type ManifestCluster struct {
+	Name  string         `yaml:"name"`
+	Nodes []ManifestNode `yaml:"nodes"`
+}
+
+type ManifestNode struct {
+	Host      string `yaml:"host"`
+	IpAddress string `yaml:"ip"`
+}
+
+type ManifestSite struct {
+	Hostname string `yaml:"hostname"`
+	// either cluster or ip
+	Cluster           string `yaml:"cluster,omitempty"`
+	IpAddress         string `yaml:"ip,omitempty"`
+	Port              string `yaml:"port,omitempty"`
+	Uri               string `yaml:"uri,omitempty"`
+	Ssl               bool   `yaml:"ssl,omitempty"`
+	SslBypassFirewall bool   `yaml:"sslBypassFirewall,omitempty"`
+	ProxySsl          bool   `yaml:"proxySsl,omitempty"`
+	ProxySslVerifyOff bool   `yaml:"proxySslVerifyOff,omitempty"`
+	// named template from proxy.templates, the default if empty
+	Template string `yaml:"template,omitempty"`
+	// sites are enabled unless set to false
+	Enabled *bool `yaml:"enabled"`
+}
+
+func (s ManifestSite) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// spec the site is rendered from
+func (s ManifestSite) Spec() sitespec.Spec {
+	return sitespec.Spec{
+		Hostname:          s.Hostname,
+		Cluster:           s.Cluster,
+		IpAddress:         s.IpAddress,
+		Port:              s.Port,
+		Uri:               s.Uri,
+		Ssl:               s.Ssl,
+		SslBypassFirewall: s.SslBypassFirewall,
+		ProxySsl:          s.ProxySsl,
+		ProxySslVerifyOff: s.ProxySslVerifyOff,
+		Template:          s.Template,
+	}
+}
+
+// names are made lowercase, unknown fields are errors
+func ParseManifest(data []byte) (Manifest, error) {
+	var manifest Manifest
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil && !errors.Is(err, io.EOF) {
+		return manifest, err
+	}
+
+	for i := range manifest.Clusters {
+		cluster := &manifest.Clusters[i]
+		cluster.Name = strings.ToLower(cluster.Name)
+		for j := range cluster.Nodes {
+			cluster.Nodes[j].Host = strings.ToLower(cluster.Nodes[j].Host)
+		}
+	}
+
+	for i := range manifest.Sites {
+		site := &manifest.Sites[i]
+		site.Hostname = strings.ToLower(site.Hostname)
+		site.Cluster = strings.ToLower(site.Cluster)
+
+		// specs store the default template as ""
+		site.Template = strings.ToLower(site.Template)
+		if site.Template == sitespec.DefaultTemplate {
+			site.Template = ""
+		}
+	}
+
+	return manifest, nil
+}
+
+func LoadManifest(filePath string) (Manifest, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return ParseManifest(data)
+}
+
+// check names, addresses and ports, and that nothing is declared twice.
+// clusters of sites are checked against the hosts file in Plan.
+func (m Manifest) Validate() error {
+	clusters := make(map[string]bool)
+	nodes := make(map[string]string)
+	for _, cluster := range m.Clusters {
+		if !validate.ValidateClusterName(cluster.Name) {
+			return fmt.Errorf("invalid cluster name '%v'", cluster.Name)
+		}
+		if clusters[cluster.Name] {
+			return fmt.Errorf("cluster '%v' is declared twice", cluster.Name)
+		}
+		clusters[cluster.Name] = true
+
+		for _, node := range cluster.Nodes {
+			if !validate.ValidateHostName(node.Host) {
+				return fmt.Errorf("cluster '%v': invalid host name '%v'", cluster.Name, node.Host)
+			}
+			if !validate.ValidateIPAddress(node.IpAddress) {
+				return fmt.Errorf("cluster '%v': invalid ip address '%v' for host '%v'", cluster.Name, node.IpAddress, node.Host)
+			}
+			if other, found := nodes[node.Host]; found {
+				return fmt.Errorf("host '%v' is declared in clusters '%v' and '%v'", node.Host, other, cluster.Name)
+			}
+			nodes[node.Host] = cluster.Name
+		}
+	}
+
+	sites := make(map[string]bool)
+	for _, site := range m.Sites {
+		if !validate.ValidateHostName(site.Hostname) {
+			return fmt.Errorf("invalid site hostname '%v'", site.Hostname)
+		}
+		if sites[site.Hostname] {
+			return fmt.Errorf("site '%v' is declared twice", site.Hostname)
+		}
+		sites[site.Hostname] = true
+
+		if (site.Cluster == "") == (site.IpAddress == "") {
+			return fmt.Errorf("site '%v': specify either 'cluster' or 'ip'", site.Hostname)
+		}
+		if site.IpAddress != "" && !validate.ValidateIPAddress(site.IpAddress) {
+			return fmt.Errorf("site '%v': invalid ip address '%v'", site.Hostname, site.IpAddress)
+		}
+		if site.Cluster != "" && site.Port == "" {
+			return fmt.Errorf("site '%v': 'port' is required with 'cluster'", site.Hostname)
+		}
+		if site.Port != "" && !validate.ValidatePort(site.Port) {
+			return fmt.Errorf("site '%v': port '%v' is invalid, use a port in the range 1024-49151", site.Hostname, site.Port)
+		}
+		if site.Uri != "" && !validate.ValidateUri(site.Uri) {
+			return fmt.Errorf("site '%v': uri '%v' is invalid", site.Hostname, site.Uri)
+		}
+		if _, err := sitespec.GetTemplate(site.Template, site.Cluster != ""); err != nil {
+			if errors.Is(err, errs.ErrTemplateNotFound) {
+				return fmt.Errorf("site '%v': template '%v' does not exist", site.Hostname, site.Template)
+			}
+			if site.Cluster != "" {
+				return fmt.Errorf("site '%v': template '%v' has no config for k8s sites", site.Hostname, site.Template)
+			}
+			return fmt.Errorf("site '%v': template '%v' has no config for normal sites", site.Hostname, site.Template)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// query the audit log of lb and proxy changes
+package audit
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	auditlog "nickneal.dev/go-proxymanager/utils/audit"
+	"nickneal.dev/go-proxymanager/utils/output"
+)
+
+// print entries matching filter, oldest first. showDiff prints what each
+// change planned below it. unreadable lines are skipped and reported as an
+// error once the rest is printed.
+func Query(filter auditlog.Filter, showDiff bool) error {
+	entries, skipped, err := auditlog.Read(filter)
+	if err != nil {
+		return fmt.Errorf("There was an issue reading the audit log: %w", err)
+	}
+
+	printEntries(entries, showDiff)
+
+	if skipped > 0 {
+		return fmt.Errorf("Skipped %v unreadable line(s) in '%v'.", skipped, auditlog.GetPath())
+	}
+
+	return nil
+}
+
+func printEntries(entries []auditlog.Entry, showDiff bool) {
+	rows := make([]auditlog.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if !showDiff {
+			entry.Diff = ""
+		}
+		rows = append(rows, entry)
+	}
+
+	output.Print(rows, func() {
+		if len(rows) == 0 {
+			fmt.Println("No changes found.")
+			return
+		}
+
+		// diffs don't fit a table, each entry gets a header line instead
+		if showDiff {
+			for _, entry := range rows {
+				fmt.Printf("%v %v %v: %v\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.User, entry.Outcome, entry.Command)
+				if entry.Error != "" {
+					fmt.Println("error:", entry.Error)
+				}
+				fmt.Print(entry.Diff)
+				fmt.Println()
+			}
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintln(w, "Time\tUser\tOutcome\tCommand")
+		for _, entry := range rows {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.User, entry.Outcome, entry.Command)
+		}
+		w.Flush()
+	})
+}
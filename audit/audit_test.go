@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	auditlog "nickneal.dev/go-proxymanager/utils/audit"
+	"nickneal.dev/go-proxymanager/utils/output"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+func TestQueryOutput(t *testing.T) {
+	dir := t.TempDir()
+	config := settings.DefaultConfig()
+	config.AuditLog = dir + "/audit.log"
+
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(dir+"/proxymanager.yml", configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+	defer output.SetFormat("table")
+
+	entry := auditlog.Entry{Time: time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC), User: "alice", Command: "proxy enable a.local",
+		Hosts: []string{"a.local"}, Outcome: auditlog.OutcomeApplied, Diff: "--- a\n+++ b\n"}
+	if err := auditlog.Append(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Format   string
+		Host     string
+		ShowDiff bool
+		Expected string
+	}{
+		{"json", "a.local", false, "[{\"time\":\"2024-05-01T03:00:00Z\",\"user\":\"alice\",\"command\":\"proxyenablea.local\",\"hosts\":[\"a.local\"],\"outcome\":\"applied\"}]"},
+		{"json", "a.local", true, "[{\"time\":\"2024-05-01T03:00:00Z\",\"user\":\"alice\",\"command\":\"proxyenablea.local\",\"hosts\":[\"a.local\"],\"outcome\":\"applied\",\"diff\":\"---a\\n+++b\\n\"}]"},
+		{"json", "b.local", false, "[]"},
+		{"table", "a.local", false, "TimeUserOutcomeCommand" + strings.ReplaceAll(entry.Time.Local().Format("2006-01-02 15:04:05"), " ", "") + "aliceappliedproxyenablea.local"},
+		{"yaml", "a.local", false, "-time:2024-05-01T03:00:00Zuser:alicecommand:proxyenablea.localhosts:-a.localoutcome:applied"},
+	}
+
+	for _, test := range tests {
+		output.SetFormat(test.Format)
+
+		// redirect STDOUT to buffer
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		err := Query(auditlog.Filter{Host: test.Host}, test.ShowDiff)
+
+		w.Close()
+		os.Stdout = oldStdout
+
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(r)
+		received := strings.Join(strings.Fields(buf.String()), "")
+
+		if err != nil || received != test.Expected {
+			t.Errorf("Format '%v': Expected '%v', received '%v' '%v'", test.Format, test.Expected, received, err)
+		}
+	}
+
+	os.Clearenv()
+}
@@ -0,0 +1,105 @@
+// snapshots of the hosts file and nginx sites
+// lb and proxy take one before every change, these commands list them, take
+// one by hand and restore one.
+package backup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/output"
+	"nickneal.dev/go-proxymanager/utils/snapshot"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+// a backup as listed, Created is missing if its info can't be read
+type Backup struct {
+	Id      string     `json:"id" yaml:"id"`
+	Created *time.Time `json:"created" yaml:"created"`
+	User    string     `json:"user" yaml:"user"`
+	Command string     `json:"command" yaml:"command"`
+}
+
+// backups, newest first
+func List() error {
+	ids, err := snapshot.List()
+	if err != nil {
+		return fmt.Errorf("There was an issue reading backups: %w", err)
+	}
+
+	backups := make([]Backup, 0, len(ids))
+	for index := len(ids) - 1; index >= 0; index-- {
+		info, loadErr := snapshot.Load(ids[index])
+		if loadErr != nil {
+			backups = append(backups, Backup{Id: ids[index]})
+			continue
+		}
+
+		backups = append(backups, Backup{Id: info.Id, Created: &info.Created, User: info.User, Command: info.Command})
+	}
+
+	output.Print(backups, func() {
+		if len(backups) == 0 {
+			fmt.Println("No backups exist.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCreated\tUser\tCommand")
+		for _, b := range backups {
+			if b.Created == nil {
+				fmt.Fprintf(w, "%v\t?\t?\t?\n", b.Id)
+				continue
+			}
+
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", b.Id, b.Created.Format("2006-01-02 15:04:05"), b.User, b.Command)
+		}
+		w.Flush()
+	})
+
+	return nil
+}
+
+func Create() error {
+	id, err := snapshot.Create(strings.Join(os.Args[1:], " "))
+	if err != nil {
+		return fmt.Errorf("There was an issue creating the backup: %w", err)
+	}
+
+	fmt.Printf("Backup '%v' created.\n", id)
+
+	return nil
+}
+
+// put the hosts file, nginx sites and specs back as they were in backup id
+// and reload nginx. the current state is backed up first.
+func Restore(id string) error {
+	if !snapshot.Exists(id) {
+		return errs.New(errs.ErrBackupNotFound, "Backup '%v' does not exist.", id)
+	}
+
+	tx := transaction.New()
+	err := snapshot.Stage(tx, id)
+	if err != nil {
+		return fmt.Errorf("There was an issue reading the backup: %w", err)
+	}
+
+	if tx.Empty() {
+		fmt.Printf("Nothing changed since backup '%v'.\n", id)
+		return nil
+	}
+
+	// validated and reloaded once, rolled back if nginx rejects it
+	if err := loadbalancer.Commit(tx, transaction.NginxReload); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup '%v' restored.\n", id)
+
+	return nil
+}
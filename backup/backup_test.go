@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/output"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/snapshot"
+)
+
+// redirect STDOUT to buffer while List runs
+func captureList(t *testing.T) string {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	if err := List(); err != nil {
+		t.Errorf("Expected no error, received '%v'", err)
+	}
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(r)
+	return buf.String()
+}
+
+func TestListOutput(t *testing.T) {
+	dir := t.TempDir()
+	config := settings.DefaultConfig()
+	config.LoadBalancer.HostsFile = dir + "/hosts"
+	config.Proxy.NginxDir = dir + "/nginx"
+	config.Proxy.SpecDir = dir + "/specs"
+	config.Backup.Dir = dir + "/snapshots"
+
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(dir+"/proxymanager.yml", configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+	defer output.SetFormat("table")
+
+	output.SetFormat("json")
+	if received := strings.TrimSpace(captureList(t)); received != "[]" {
+		t.Errorf("Expected '[]', received '%v'", received)
+	}
+
+	// an older backup without its info file
+	os.MkdirAll(dir+"/snapshots/20200101-000000", 0700)
+	id, err := snapshot.Create("lb add test1 node01.local 10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var backups []Backup
+	received := captureList(t)
+	if err := json.Unmarshal([]byte(received), &backups); err != nil {
+		t.Fatalf("Expected json, received '%v'", received)
+	}
+	if len(backups) != 2 || backups[0].Id != id || backups[0].Created == nil || backups[0].Command != "lb add test1 node01.local 10.0.0.1" ||
+		backups[1].Id != "20200101-000000" || backups[1].Created != nil {
+		t.Errorf("Expected backups '%v' and '20200101-000000', received '%v'", id, received)
+	}
+
+	output.SetFormat("yaml")
+	backups = nil
+	received = captureList(t)
+	if err := yaml.Unmarshal([]byte(received), &backups); err != nil || len(backups) != 2 || backups[0].Id != id {
+		t.Errorf("Expected yaml with backup '%v', received '%v'", id, received)
+	}
+
+	output.SetFormat("table")
+	received = captureList(t)
+	if !strings.HasPrefix(received, "ID               Created              User") || !strings.Contains(received, "20200101-000000  ?") {
+		t.Errorf("Expected table, received %q", received)
+	}
+
+	os.Clearenv()
+}
@@ -0,0 +1,366 @@
+// find drift between proxymanager's state and the files on disk
+// hand edits and leftovers of manual changes are reported. the safe cases,
+// where nothing but proxymanager's own state is lost, are fixed with --fix.
+package check
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	"nickneal.dev/go-proxymanager/proxy"
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/output"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+const (
+	// marker lines of cluster blocks in the hosts file
+	KindBlock = "block"
+	// hosts file lines proxymanager can't read
+	KindHosts = "hosts"
+	// host in more than one cluster, site in more than one config dir
+	KindDuplicate = "duplicate"
+	// sites-enabled entries that aren't links to a site config
+	KindSymlink = "symlink"
+	// k8s sites whose upstream doesn't match the nodes of their cluster
+	KindUpstream = "upstream"
+	// configs that differ from their rendered spec
+	KindTemplate = "template"
+	// specs and cluster config dirs without a site or cluster
+	KindOrphan = "orphan"
+)
+
+type Problem struct {
+	Kind    string `json:"kind" yaml:"kind"`
+	Subject string `json:"subject" yaml:"subject"`
+	Message string `json:"message" yaml:"message"`
+	Fixable bool   `json:"fixable" yaml:"fixable"`
+
+	// stages the fix, nil when it has to be fixed by hand
+	fix func(tx *transaction.Transaction)
+}
+
+type finder struct {
+	problems []Problem
+}
+
+func (f *finder) add(kind string, subject string, fix func(tx *transaction.Transaction), format string, args ...any) {
+	f.problems = append(f.problems, Problem{
+		Kind:    kind,
+		Subject: subject,
+		Message: fmt.Sprintf(format, args...),
+		Fixable: fix != nil,
+		fix:     fix,
+	})
+}
+
+// every problem in the hosts file, sites-available, sites-enabled and the
+// spec store
+func Find() ([]Problem, error) {
+	f := &finder{}
+
+	data, err := os.ReadFile(loadbalancer.GetHostsFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	// nodes of clusters can't be trusted with broken blocks, upstreams and
+	// templates of k8s sites are skipped
+	var hostsFile *loadbalancer.HostsFile
+	blockProblems := loadbalancer.CheckHostsFile(string(data))
+	for _, message := range blockProblems {
+		f.add(KindBlock, loadbalancer.GetHostsFilePath(), nil, "%v", message)
+	}
+
+	if len(blockProblems) == 0 {
+		hostsFile, err = loadbalancer.ParseHostsFile(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("There was an issue parsing the hosts file: %w", err)
+		}
+		f.checkHosts(hostsFile)
+	}
+
+	if err := f.checkSites(hostsFile); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkSymlinks(); err != nil {
+		return nil, err
+	}
+
+	if err := f.checkSpecs(); err != nil {
+		return nil, err
+	}
+
+	return f.problems, nil
+}
+
+// unreadable lines in cluster blocks and hosts in several clusters
+func (f *finder) checkHosts(hostsFile *loadbalancer.HostsFile) {
+	hostClusters := make(map[string][]string)
+	var hosts []string
+
+	for _, lineError := range hostsFile.Errors() {
+		f.add(KindHosts, lineError.Cluster, nil, "%v", lineError)
+	}
+
+	for _, cluster := range hostsFile.Clusters() {
+		block := hostsFile.GetCluster(cluster)
+		for _, host := range block.Hosts.List() {
+			if hostClusters[host.Name] == nil {
+				hosts = append(hosts, host.Name)
+			}
+			hostClusters[host.Name] = append(hostClusters[host.Name], cluster)
+		}
+	}
+
+	for _, host := range hosts {
+		if len(hostClusters[host]) > 1 {
+			f.add(KindDuplicate, host, nil, "host is in clusters '%v'", strings.Join(hostClusters[host], ","))
+		}
+	}
+}
+
+// configs of every site against their spec and cluster. hostsFile is nil
+// when it could not be parsed.
+func (f *finder) checkSites(hostsFile *loadbalancer.HostsFile) error {
+	clusters, err := proxy.GetClusters()
+	if err != nil {
+		return fmt.Errorf("There was an issue reading sites: %w", err)
+	}
+
+	siteClusters := make(map[string][]string)
+	var sites []string
+
+	for _, cluster := range append([]string{""}, clusters...) {
+		if cluster != "" && hostsFile != nil && hostsFile.GetCluster(cluster) == nil {
+			f.add(KindOrphan, cluster, nil, "config dir '%v' has no cluster in the hosts file", sitespec.GetConfigDir(cluster))
+		}
+
+		availableSites, _ := proxy.GetAvailableSites(cluster)
+		for _, hostname := range availableSites {
+			if siteClusters[hostname] == nil {
+				sites = append(sites, hostname)
+			}
+			siteClusters[hostname] = append(siteClusters[hostname], cluster)
+
+			f.checkSite(hostsFile, cluster, hostname)
+		}
+	}
+
+	for _, hostname := range sites {
+		if len(siteClusters[hostname]) > 1 {
+			var dirs []string
+			for _, cluster := range siteClusters[hostname] {
+				dirs = append(dirs, sitespec.GetConfigDir(cluster))
+			}
+			f.add(KindDuplicate, hostname, nil, "site is configured in '%v'", strings.Join(dirs, "', '"))
+		}
+	}
+
+	return nil
+}
+
+func (f *finder) checkSite(hostsFile *loadbalancer.HostsFile, cluster string, hostname string) {
+	configPath := sitespec.GetConfigPath(cluster, hostname)
+	fileLines, err := ssl.ReadConfigLines(configPath)
+	if err != nil {
+		f.add(KindTemplate, hostname, nil, "config could not be read: %v", err)
+		return
+	}
+
+	spec, specErr := sitespec.Load(hostname)
+	hasSpec := specErr == nil
+	if hasSpec && spec.Cluster != cluster {
+		f.add(KindTemplate, hostname, nil, "spec is for cluster '%v', config is in '%v'", spec.Cluster, sitespec.GetConfigDir(cluster))
+		return
+	}
+
+	// e.g. its template was removed from proxymanager.yml
+	if hasSpec {
+		if _, templateErr := sitespec.GetTemplate(spec.Template, cluster != ""); templateErr != nil {
+			f.add(KindTemplate, hostname, nil, "spec can't be rendered: %v", templateErr)
+			return
+		}
+	}
+
+	var nodes []string
+	if cluster != "" {
+		if hostsFile == nil || hostsFile.GetCluster(cluster) == nil {
+			return
+		}
+		nodes = hostsFile.GetCluster(cluster).Hosts.Names()
+
+		// configs without spec and upstream weren't written by proxymanager
+		upstreamNodes := sitespec.GetUpstreamNodes(fileLines)
+		if (hasSpec || len(upstreamNodes) > 0) && strings.Join(upstreamNodes, ",") != strings.Join(nodes, ",") {
+			// re-rendering is safe if the upstream is the only change
+			var fix func(tx *transaction.Transaction)
+			if hasSpec && len(nodes) > 0 && rendersTo(spec, upstreamNodes, fileLines) {
+				if configLines, renderErr := sitespec.Render(spec, nodes); renderErr == nil {
+					fix = func(tx *transaction.Transaction) {
+						tx.AddCluster(cluster)
+						tx.AddHost(hostname)
+						tx.WriteLines(configPath, configLines, 0644)
+					}
+				}
+			}
+
+			f.add(KindUpstream, hostname, fix, "upstream has nodes '%v', cluster '%v' has '%v'", strings.Join(upstreamNodes, ","), cluster, strings.Join(nodes, ","))
+			return
+		}
+	}
+
+	if hasSpec && !rendersTo(spec, nodes, fileLines) {
+		f.add(KindTemplate, hostname, nil, "config '%v' differs from its rendered spec", configPath)
+	}
+}
+
+// spec rendered for nodes is the config as it is on disk
+func rendersTo(spec sitespec.Spec, nodes []string, fileLines []string) bool {
+	configLines, err := sitespec.Render(spec, nodes)
+	if err != nil {
+		return false
+	}
+
+	return strings.Join(configLines, "\n") == strings.Join(fileLines, "\n")
+}
+
+// sites-enabled entries are links to a config under sites-available
+func (f *finder) checkSymlinks() error {
+	enabledDir := proxy.GetEnabledConfigDir()
+	entries, err := os.ReadDir(enabledDir)
+	if err != nil {
+		return fmt.Errorf("There was an issue reading enabled sites: %w", err)
+	}
+
+	for _, e := range entries {
+		linkPath := enabledDir + "/" + e.Name()
+		if e.Type()&os.ModeSymlink == 0 {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".conf") {
+				f.add(KindSymlink, linkPath, nil, "not a symlink to a site config")
+			}
+			continue
+		}
+
+		target, _ := os.Readlink(linkPath)
+
+		// nginx fails on links to missing files, whatever their name
+		linked, statErr := os.Stat(linkPath)
+		if errors.Is(statErr, os.ErrNotExist) {
+			f.add(KindSymlink, linkPath, func(tx *transaction.Transaction) {
+				tx.AddHost(strings.TrimSuffix(e.Name(), ".conf"))
+				tx.Remove(linkPath)
+			}, "points at missing '%v'", target)
+			continue
+		}
+
+		// other links, e.g. nginx's default site, aren't proxymanager's
+		if !strings.HasSuffix(e.Name(), ".conf") {
+			continue
+		}
+
+		hostname := strings.TrimSuffix(e.Name(), ".conf")
+		cluster, found := proxy.GetSiteCluster(hostname)
+		config, configErr := os.Stat(sitespec.GetConfigPath(cluster, hostname))
+		if !found || configErr != nil || statErr != nil || !os.SameFile(linked, config) {
+			f.add(KindSymlink, linkPath, nil, "points at '%v', not the config of site '%v'", target, hostname)
+		}
+	}
+
+	return nil
+}
+
+// specs of removed sites
+func (f *finder) checkSpecs() error {
+	entries, err := os.ReadDir(sitespec.GetSpecDir())
+	if err != nil {
+		// nothing was stored yet
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("There was an issue reading specs: %w", err)
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".yml") {
+			continue
+		}
+
+		hostname := strings.TrimSuffix(e.Name(), ".yml")
+		if _, found := proxy.GetSiteCluster(hostname); found {
+			continue
+		}
+
+		specPath := sitespec.GetSpecPath(hostname)
+		f.add(KindOrphan, hostname, func(tx *transaction.Transaction) {
+			tx.AddHost(hostname)
+			tx.Remove(specPath)
+		}, "spec '%v' has no site", specPath)
+	}
+
+	return nil
+}
+
+// report problems, with fix the fixable ones are fixed in one transaction.
+// returns an error while problems remain.
+func Check(fix bool) error {
+	problems, err := Find()
+	if err != nil {
+		return err
+	}
+
+	output.Print(problems, func() {
+		if len(problems) == 0 {
+			fmt.Println("No problems found.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintln(w, "Kind\tSubject\tFixable\tProblem")
+		for _, problem := range problems {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", problem.Kind, problem.Subject, problem.Fixable, problem.Message)
+		}
+		w.Flush()
+	})
+
+	tx := transaction.New()
+	fixable := 0
+	for _, problem := range problems {
+		if problem.fix != nil {
+			problem.fix(tx)
+			fixable++
+		}
+	}
+
+	remaining := len(problems)
+	if fix && fixable > 0 {
+		if err := loadbalancer.Commit(tx, transaction.NginxReload); err != nil {
+			return err
+		}
+
+		// json and yaml output stays parseable
+		if output.GetFormat() == output.FormatTable {
+			fmt.Printf("Fixed %v problem(s).\n", fixable)
+		} else {
+			fmt.Fprintf(os.Stderr, "Fixed %v problem(s).\n", fixable)
+		}
+		remaining -= fixable
+	}
+
+	switch {
+	case remaining == 0:
+		return nil
+	case fix:
+		return fmt.Errorf("%v problem(s) have to be fixed by hand.", remaining)
+	case fixable > 0:
+		return fmt.Errorf("%v problem(s) found, %v can be fixed with '--fix'.", remaining, fixable)
+	}
+
+	return fmt.Errorf("%v problem(s) found.", remaining)
+}
@@ -0,0 +1,197 @@
+package check
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+)
+
+const testHostsFile = `127.0.0.1 localhost
+
+# DO NOT EDIT, USE proxymanager
+### LB_K8S(prod)
+10.0.0.1 node01.local
+10.0.0.2 node02.local
+10.0.0.x broken.local
+### LB_K8S_END
+
+# DO NOT EDIT, USE proxymanager
+### LB_K8S(test)
+10.0.1.1 node01.local
+### LB_K8S_END
+`
+
+// nginx dir, hosts file and specs in a temp dir with a problem of each kind
+func SetTestConfig(t *testing.T) string {
+	dir := t.TempDir()
+
+	config := fmt.Sprintf(`lockFile: %[1]v/proxymanager.lock
+auditLog: %[1]v/audit.log
+loadBalancer:
+  hostsFile: %[1]v/hosts
+  backupDir: %[1]v/backups
+backup:
+  dir: %[1]v/snapshots
+ssl:
+  certDir: %[1]v/ssl
+  webRoot: %[1]v/acme
+proxy:
+  specDir: %[1]v/specs
+  nginxDir: %[1]v/nginx
+  proxyConfig: |
+    server {
+        server_name %%HOSTNAME%%;
+        location / {
+            proxy_pass %%BACKEND%%;
+        }
+    }
+  k8sProxyConfig: |
+    upstream %%UPSTREAM_NAME%% {
+    %%UPSTREAM_NODES%%}
+    server {
+        server_name %%HOSTNAME%%;
+        location / {
+            proxy_pass %%BACKEND%%;
+        }
+    }
+`, dir)
+	os.WriteFile(dir+"/proxymanager.yml", []byte(config), 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent reload
+
+	os.WriteFile(dir+"/hosts", []byte(testHostsFile), 0644)
+	for _, configDir := range []string{"k8s_prod", "k8s_test", "k8s_old"} {
+		os.MkdirAll(dir+"/nginx/sites-available/"+configDir, 0755)
+	}
+	os.MkdirAll(dir+"/nginx/sites-enabled", 0755)
+
+	// upstream misses node02.local
+	writeSite(t, sitespec.Spec{Hostname: "app.local", Cluster: "prod", Port: "8080"}, []string{"node01.local"}, "")
+	// upstream misses node02.local and the config was edited
+	writeSite(t, sitespec.Spec{Hostname: "edited.local", Cluster: "prod", Port: "8080"}, []string{"node01.local"}, "# edited")
+	writeSite(t, sitespec.Spec{Hostname: "plain.local", IpAddress: "10.0.2.1", Port: "8080"}, nil, "# edited")
+	sitespec.Save(sitespec.Spec{Hostname: "gone.local", IpAddress: "10.0.2.2"})
+	// template isn't in proxymanager.yml
+	ssl.WriteConfigLines(sitespec.GetConfigPath("", "grpc.local"), []string{"server {}"})
+	sitespec.Save(sitespec.Spec{Hostname: "grpc.local", IpAddress: "10.0.2.3", Template: "grpc"})
+
+	os.Symlink(sitespec.GetConfigPath("prod", "app.local"), dir+"/nginx/sites-enabled/app.local.conf")
+	os.Symlink(sitespec.GetConfigPath("", "missing.local"), dir+"/nginx/sites-enabled/missing.local.conf")
+	os.WriteFile(dir+"/nginx/sites-enabled/other.local.conf", []byte("server {}\n"), 0644)
+
+	return dir
+}
+
+// save spec and its config rendered for nodes, with extra appended
+func writeSite(t *testing.T, spec sitespec.Spec, nodes []string, extra string) {
+	configLines, err := sitespec.Render(spec, nodes)
+	if err != nil {
+		t.Fatalf("Expected no render error, received '%v'", err)
+	}
+	if extra != "" {
+		configLines = append(configLines, extra)
+	}
+
+	ssl.WriteConfigLines(sitespec.GetConfigPath(spec.Cluster, spec.Hostname), configLines)
+	sitespec.Save(spec)
+}
+
+func summary(problems []Problem) []string {
+	var lines []string
+	for _, problem := range problems {
+		subject := problem.Subject[strings.LastIndex(problem.Subject, "/")+1:]
+		lines = append(lines, fmt.Sprintf("%v %v %v", problem.Kind, subject, problem.Fixable))
+	}
+
+	return lines
+}
+
+func TestFind(t *testing.T) {
+	SetTestConfig(t)
+
+	problems, err := Find()
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'", err)
+	}
+
+	expected := []string{
+		"hosts prod false",
+		"duplicate node01.local false",
+		"template grpc.local false",
+		"template plain.local false",
+		"orphan old false",
+		"upstream app.local true",
+		"upstream edited.local false",
+		"symlink missing.local.conf true",
+		"symlink other.local.conf false",
+		"orphan gone.local true",
+	}
+	if output := summary(problems); strings.Join(output, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+
+	os.Clearenv()
+}
+
+func TestFindBrokenBlock(t *testing.T) {
+	dir := SetTestConfig(t)
+	os.WriteFile(dir+"/hosts", []byte(strings.Replace(testHostsFile, "### LB_K8S_END\n\n", "\n", 1)), 0644)
+
+	problems, err := Find()
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'", err)
+	}
+
+	// nodes are unknown, upstreams aren't checked
+	expected := []string{
+		"block hosts false",
+		"template grpc.local false",
+		"template plain.local false",
+		"symlink missing.local.conf true",
+		"symlink other.local.conf false",
+		"orphan gone.local true",
+	}
+	if output := summary(problems); strings.Join(output, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+
+	os.Clearenv()
+}
+
+func TestCheckFix(t *testing.T) {
+	dir := SetTestConfig(t)
+
+	if err := Check(false); err == nil || err.Error() != "10 problem(s) found, 3 can be fixed with '--fix'." {
+		t.Errorf("Expected problems found, received '%v'", err)
+	}
+
+	if err := Check(true); err == nil || err.Error() != "7 problem(s) have to be fixed by hand." {
+		t.Errorf("Expected problems left, received '%v'", err)
+	}
+
+	problems, _ := Find()
+	for _, problem := range problems {
+		if problem.Fixable {
+			t.Errorf("Expected '%v' to be fixed", problem.Subject)
+		}
+	}
+
+	if _, err := os.Lstat(dir + "/nginx/sites-enabled/missing.local.conf"); !os.IsNotExist(err) {
+		t.Errorf("Expected dangling symlink to be removed, received '%v'", err)
+	}
+
+	if sitespec.Exists("gone.local") {
+		t.Errorf("Expected orphaned spec to be removed")
+	}
+
+	configData, _ := os.ReadFile(sitespec.GetConfigPath("prod", "app.local"))
+	if !strings.Contains(string(configData), "server node02.local:8080;") {
+		t.Errorf("Expected upstream with node02.local, received %q", configData)
+	}
+
+	os.Clearenv()
+}
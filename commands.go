@@ -0,0 +1,521 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"nickneal.dev/go-proxymanager/apply"
+	"nickneal.dev/go-proxymanager/audit"
+	"nickneal.dev/go-proxymanager/backup"
+	"nickneal.dev/go-proxymanager/check"
+	"nickneal.dev/go-proxymanager/firewall"
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	"nickneal.dev/go-proxymanager/proxy"
+	auditlog "nickneal.dev/go-proxymanager/utils/audit"
+	"nickneal.dev/go-proxymanager/utils/errs"
+)
+
+func newLbCommand() *cobra.Command {
+	lb := &cobra.Command{
+		Use:   "lb",
+		Short: "manages loadbalancing for clusters defined in /etc/hosts",
+		Long: "manages loadbalancing for clusters defined in /etc/hosts.\n\n" +
+			"commands on a cluster also take the cluster first, e.g. 'lb <cluster> status'.",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list clusters",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return loadbalancer.List()
+		},
+	}
+
+	var backupSites bool
+	remove := &cobra.Command{
+		Use:   "remove <cluster>",
+		Short: "remove a cluster without nodes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return loadbalancer.Remove(args[0], backupSites)
+		},
+	}
+	remove.Flags().BoolVar(&backupSites, "backup", false, "archive the sites of the cluster before removing them")
+	addMutatingFlags(remove, true)
+
+	status := &cobra.Command{
+		Use:     "status <cluster>",
+		Short:   "show the nodes of a cluster",
+		Example: "  proxymanager lb prod status",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return loadbalancer.Status(args[0])
+		},
+	}
+
+	lb.AddCommand(
+		list,
+		mutatingCommand(&cobra.Command{
+			Use:   "new <cluster>",
+			Short: "create a cluster",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return loadbalancer.New(args[0])
+			},
+		}),
+		remove,
+		status,
+		mutatingCommand(&cobra.Command{
+			Use:     "add <cluster> <host> <ip>",
+			Short:   "add a node to a cluster",
+			Example: "  proxymanager lb prod add node1 10.0.0.1",
+			Args:    cobra.ExactArgs(3),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return loadbalancer.Add(args[0], args[2], args[1])
+			},
+		}),
+		mutatingCommand(&cobra.Command{
+			Use:     "del <cluster> <host>",
+			Short:   "remove a node from a cluster",
+			Example: "  proxymanager lb prod del node1",
+			Args:    cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return loadbalancer.Del(args[0], args[1])
+			},
+		}),
+		mutatingCommand(&cobra.Command{
+			Use:     "move <cluster> <host1> <host2>",
+			Short:   "move the traffic of host1 to host2",
+			Example: "  proxymanager lb prod move node1 node2",
+			Args:    cobra.ExactArgs(3),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return loadbalancer.Move(args[0], args[1], args[2])
+			},
+		}),
+		mutatingCommand(&cobra.Command{
+			Use:     "restore <cluster> <host>",
+			Short:   "move traffic back to a host",
+			Example: "  proxymanager lb prod restore node1",
+			Args:    cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return loadbalancer.Restore(args[0], args[1])
+			},
+		}),
+	)
+
+	return lb
+}
+
+// commands staged in a transaction, they take --wait and --dry-run
+func mutatingCommand(cmd *cobra.Command) *cobra.Command {
+	addMutatingFlags(cmd, true)
+	return cmd
+}
+
+func newProxyCommand() *cobra.Command {
+	proxyCmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "manages proxy configs used by nginx",
+	}
+
+	var cluster string
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list sites",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return proxy.List(cluster)
+		},
+	}
+	list.Flags().StringVar(&cluster, "k8s", "", "list the sites of a cluster")
+
+	proxyCmd.AddCommand(
+		list,
+		newProxyNewCommand(),
+		newProxyUpdateCommand(),
+		newProxyMigrateCommand(),
+		siteCommand("enable", "enable a site", proxy.Enable),
+		siteCommand("disable", "disable a site", proxy.Disable),
+		siteCommand("remove", "remove a disabled site", proxy.Remove),
+	)
+
+	importCmd := &cobra.Command{
+		Use:   "import [<hostname>]",
+		Short: "store specs of sites created before specs existed, all of them without a hostname",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var hostname string
+			if len(args) > 0 {
+				hostname = args[0]
+			}
+			return proxy.Import(hostname)
+		},
+	}
+	addMutatingFlags(importCmd, false)
+	proxyCmd.AddCommand(importCmd)
+
+	return proxyCmd
+}
+
+// '<name> <hostname> [--k8s <cluster>]'
+func siteCommand(name string, short string, run func(cluster string, hostname string) error) *cobra.Command {
+	var cluster string
+	cmd := &cobra.Command{
+		Use:   name + " <hostname>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cluster, args[0])
+		},
+	}
+	cmd.Flags().StringVar(&cluster, "k8s", "", "cluster of the site")
+	addMutatingFlags(cmd, true)
+
+	return cmd
+}
+
+func newProxyNewCommand() *cobra.Command {
+	var cluster, ipAddress, port, uri, template string
+	var ssl, sslBypassFirewall, proxySsl, proxySslVerifyOff bool
+
+	cmd := &cobra.Command{
+		Use:   "new <hostname>",
+		Short: "create a site proxying to an ip address or a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// require port if k8s
+			if cluster != "" && port == "" {
+				return errs.New(errs.ErrInvalidArgument, "parser: must specify '--port' if '--k8s' is specified")
+			}
+
+			return proxy.New(cluster, args[0], ipAddress, port, uri, ssl, sslBypassFirewall, proxySsl, proxySslVerifyOff, template)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&ipAddress, "ip", "", "ip address of the backend")
+	flags.StringVar(&cluster, "k8s", "", "cluster of the backend")
+	flags.StringVar(&port, "port", "", "port of the backend, required with --k8s")
+	flags.StringVar(&uri, "proxy-uri", "", "uri of the backend, e.g. /app")
+	flags.BoolVar(&ssl, "ssl", false, "install an ssl certificate once the site is enabled")
+	flags.BoolVar(&sslBypassFirewall, "ssl-bypass-firewall", false, "open port 80 while the certificate is requested")
+	flags.BoolVar(&proxySsl, "proxy-ssl", false, "connect to the backend over https")
+	flags.BoolVar(&proxySslVerifyOff, "proxy-ssl-verify-off", false, "don't verify the certificate of the backend")
+	flags.StringVar(&template, "template", "", "render the site from a named template, see 'template list'")
+	cmd.MarkFlagsOneRequired("ip", "k8s")
+	cmd.MarkFlagsMutuallyExclusive("ip", "k8s")
+	addMutatingFlags(cmd, true)
+
+	return cmd
+}
+
+func newProxyUpdateCommand() *cobra.Command {
+	var cluster string
+	var changes proxy.SiteChanges
+	var proxySsl, noProxySsl, proxySslVerifyOff, noProxySslVerifyOff bool
+
+	cmd := &cobra.Command{
+		Use:   "update <hostname>",
+		Short: "change a site in place",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// unset flags keep the current value
+			flags := cmd.Flags()
+			if flags.Changed("proxy-ssl") || flags.Changed("no-proxy-ssl") {
+				value := proxySsl && !noProxySsl
+				changes.ProxySsl = &value
+			}
+			if flags.Changed("proxy-ssl-verify-off") || flags.Changed("no-proxy-ssl-verify-off") {
+				value := proxySslVerifyOff && !noProxySslVerifyOff
+				changes.ProxySslVerifyOff = &value
+			}
+
+			return proxy.Update(cluster, args[0], changes)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&changes.IpAddress, "ip", "", "ip address of the backend")
+	flags.StringVar(&changes.Port, "port", "", "port of the backend")
+	flags.StringVar(&cluster, "k8s", "", "cluster of the site")
+	flags.StringVar(&changes.Uri, "proxy-uri", "", "uri of the backend, e.g. /app")
+	flags.BoolVar(&proxySsl, "proxy-ssl", false, "connect to the backend over https")
+	flags.BoolVar(&noProxySsl, "no-proxy-ssl", false, "connect to the backend over http")
+	flags.BoolVar(&proxySslVerifyOff, "proxy-ssl-verify-off", false, "don't verify the certificate of the backend")
+	flags.BoolVar(&noProxySslVerifyOff, "no-proxy-ssl-verify-off", false, "verify the certificate of the backend")
+	flags.StringVar(&changes.Template, "template", "", "render the site from a named template, 'default' for the default")
+	cmd.MarkFlagsMutuallyExclusive("proxy-ssl", "no-proxy-ssl")
+	cmd.MarkFlagsMutuallyExclusive("proxy-ssl-verify-off", "no-proxy-ssl-verify-off")
+	addMutatingFlags(cmd, true)
+
+	return cmd
+}
+
+func newProxyMigrateCommand() *cobra.Command {
+	var toCluster, toIpAddress, port string
+
+	cmd := &cobra.Command{
+		Use:   "migrate <hostname>",
+		Short: "move a site to another cluster or ip address",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// require port if k8s
+			if toCluster != "" && port == "" {
+				return errs.New(errs.ErrInvalidArgument, "parser: must specify '--port' if '--to-k8s' is specified")
+			}
+
+			return proxy.Migrate(args[0], toCluster, toIpAddress, port)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&toIpAddress, "to-ip", "", "ip address of the new backend")
+	flags.StringVar(&toCluster, "to-k8s", "", "cluster of the new backend")
+	flags.StringVar(&port, "port", "", "port of the new backend, required with --to-k8s")
+	cmd.MarkFlagsOneRequired("to-ip", "to-k8s")
+	cmd.MarkFlagsMutuallyExclusive("to-ip", "to-k8s")
+	addMutatingFlags(cmd, true)
+
+	return cmd
+}
+
+func newSslCommand() *cobra.Command {
+	sslCmd := &cobra.Command{
+		Use:   "ssl",
+		Short: "manages ssl certificates for proxy sites",
+	}
+
+	var bypassFirewall bool
+	install := &cobra.Command{
+		Use:   "install <hostname>",
+		Short: "request a certificate for a site",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return proxy.InstallSsl(args[0], bypassFirewall)
+		},
+	}
+	install.Flags().BoolVar(&bypassFirewall, "bypass-firewall", false, "open port 80 while the certificate is requested")
+	addMutatingFlags(install, false)
+
+	var all bool
+	renew := &cobra.Command{
+		Use:   "renew { <hostname> | --all }",
+		Short: "renew the certificate of a site, or every certificate due for renewal",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// non-zero exit so timers/cron can alert on failures
+			if all {
+				return proxy.RenewAllSsl(bypassFirewall)
+			}
+			return proxy.RenewSsl(args[0], bypassFirewall)
+		},
+	}
+	renew.Flags().BoolVar(&all, "all", false, "renew every certificate expiring within the configured window")
+	renew.Flags().BoolVar(&bypassFirewall, "bypass-firewall", false, "open port 80 while certificates are requested")
+	addMutatingFlags(renew, false)
+
+	sslCmd.AddCommand(install, renew)
+
+	return sslCmd
+}
+
+func newFwCommand() *cobra.Command {
+	fw := &cobra.Command{
+		Use:   "fw",
+		Short: "manages ip addresses blocked or allowed by the firewall",
+	}
+
+	block := &cobra.Command{
+		Use:   "block <ip>",
+		Short: "block an ip address",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return firewall.Block(args[0])
+		},
+	}
+	addMutatingFlags(block, false)
+
+	allow := &cobra.Command{
+		Use:   "allow <ip>",
+		Short: "allow an ip address",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return firewall.Allow(args[0])
+		},
+	}
+	addMutatingFlags(allow, false)
+
+	fw.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "list firewall rules",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return firewall.List()
+			},
+		},
+		block,
+		allow,
+	)
+
+	return fw
+}
+
+func newBackupCommand() *cobra.Command {
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "lists, creates and restores backups of the hosts file and nginx sites",
+	}
+
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "back up the current state",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return backup.Create()
+		},
+	}
+	addMutatingFlags(create, false)
+
+	backupCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "list backups",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return backup.List()
+			},
+		},
+		create,
+		mutatingCommand(&cobra.Command{
+			Use:   "restore <id>",
+			Short: "put a backup back and reload nginx",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return backup.Restore(args[0])
+			},
+		}),
+	)
+
+	return backupCmd
+}
+
+func newAuditCommand() *cobra.Command {
+	var filter auditlog.Filter
+	var showDiff bool
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "shows who changed clusters and sites, and when",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return audit.Query(filter, showDiff)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.Var(&sinceValue{since: &filter.Since}, "since", "changes since a duration, days or date, e.g. 24h, 7d, 2024-05-01 or \"2024-05-01 03:00\"")
+	flags.StringVar(&filter.Cluster, "cluster", "", "changes of a cluster")
+	flags.StringVar(&filter.Host, "host", "", "changes of a host")
+	flags.BoolVar(&showDiff, "diff", false, "show what each change planned")
+
+	return cmd
+}
+
+func newApplyCommand() *cobra.Command {
+	var filePath string
+	var prune bool
+
+	cmd := &cobra.Command{
+		Use:   "apply -f <manifest>",
+		Short: "converges clusters and sites to a yaml manifest",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return apply.Apply(filePath, prune)
+		},
+	}
+
+	cmd.Flags().StringVarP(&filePath, "file", "f", "", "manifest declaring clusters and sites")
+	cmd.Flags().BoolVar(&prune, "prune", false, "remove sites that are not in the manifest")
+	cmd.MarkFlagRequired("file")
+	addMutatingFlags(cmd, true)
+
+	return cmd
+}
+
+func newExportCommand() *cobra.Command {
+	var cluster string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "prints clusters and sites as a manifest for apply",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return apply.Export(cluster)
+		},
+	}
+
+	cmd.Flags().StringVar(&cluster, "cluster", "", "only export a cluster and its sites")
+
+	return cmd
+}
+
+func newCheckCommand() *cobra.Command {
+	var fix bool
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "reports hand-edited or orphaned hosts file entries, sites and specs",
+		Long: "reports hand-edited or orphaned hosts file entries, sites and specs.\n\n" +
+			"--fix removes dangling sites-enabled links and specs without a site, and renders\n" +
+			"upstreams that only miss node changes again. the rest is left to be fixed by hand.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return check.Check(fix)
+		},
+	}
+
+	cmd.Flags().BoolVar(&fix, "fix", false, "fix the problems that are safe to fix")
+	addMutatingFlags(cmd, true)
+	// reports don't take the lock
+	cmd.Annotations[mutating] = "fix"
+
+	return cmd
+}
+
+func newTemplateCommand() *cobra.Command {
+	template := &cobra.Command{
+		Use:   "template",
+		Short: "lists and shows the site templates of proxymanager.yml",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list templates and the sites using them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return proxy.ListTemplates()
+		},
+	}
+
+	var k8s bool
+	show := &cobra.Command{
+		Use:   "show <template>",
+		Short: "print the config of a template",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return proxy.ShowTemplate(args[0], k8s)
+		},
+	}
+	show.Flags().BoolVar(&k8s, "k8s", false, "print the config for k8s sites")
+
+	template.AddCommand(list, show)
+
+	return template
+}
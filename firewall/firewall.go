@@ -0,0 +1,289 @@
+// proxymanager owned firewall rules
+// the rules file (settings firewall.rulesFile) holds the complete ruleset for
+// a dedicated chain and is rendered/parsed by the configured backend.
+package firewall
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"nickneal.dev/go-proxymanager/utils/atomicfile"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+	"nickneal.dev/go-proxymanager/utils/validate"
+)
+
+const (
+	ActionBlock = "block"
+	ActionAllow = "allow"
+)
+
+type Rule struct {
+	IpAddress string
+	Action    string
+	// set for rules that apply to a tcp port from any address
+	Port string
+}
+
+// firewall implementation that owns a single chain
+type Backend interface {
+	// render a complete ruleset file for chain
+	Render(chain string, rules []Rule) []string
+	// read rules back from a ruleset file created by Render
+	Parse(chain string, fileLines []string) []Rule
+	// load ruleset file into the kernel
+	Apply(chain string, rulesFile string) error
+	// remove chain from the kernel, nothing happens if it isn't loaded
+	Remove(chain string) error
+	// accept tcp traffic to port from any address ahead of every rule that
+	// could drop it, the returned function takes the bypass out again
+	Bypass(chain string, port string) (func() error, error)
+}
+
+func GetBackend() (Backend, error) {
+	switch settings.LoadConfig().Firewall.Backend {
+	case "nftables", "":
+		return NftablesBackend{}, nil
+	case "iptables":
+		return IptablesBackend{}, nil
+	}
+
+	return nil, errors.New("firewall(GetBackend): unknown backend '" + settings.LoadConfig().Firewall.Backend + "'")
+}
+
+func GetChain() string {
+	return settings.LoadConfig().Firewall.Chain
+}
+
+func ReadRulesFileLines() ([]string, error) {
+	// get location of rules file
+	rulesFilePath := settings.LoadConfig().Firewall.RulesFile
+
+	file, err := os.Open(rulesFilePath)
+	if err != nil {
+		// no rules file means no rules have been created yet
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return []string{}, err
+	}
+	defer file.Close()
+
+	// read file lines to string array
+	var fileLines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fileLines = append(fileLines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return []string{}, err
+	}
+
+	return fileLines, nil
+}
+
+func WriteRulesFileLines(fileLines []string) error {
+	// get location of rules file
+	rulesFilePath := settings.LoadConfig().Firewall.RulesFile
+
+	err := os.MkdirAll(filepath.Dir(rulesFilePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteLines(rulesFilePath, fileLines, 0644)
+}
+
+func GetRules() ([]Rule, error) {
+	backend, err := GetBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	fileLines, err := ReadRulesFileLines()
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.Parse(GetChain(), fileLines), nil
+}
+
+// replace the rule for ipAddress (if any) with action
+func SetRule(rules []Rule, ipAddress string, action string) []Rule {
+	var newRules []Rule
+	for _, r := range rules {
+		if r.IpAddress != ipAddress {
+			newRules = append(newRules, r)
+		}
+	}
+
+	return append(newRules, Rule{IpAddress: ipAddress, Action: action})
+}
+
+// apply the rules file, if that fails the previous rules file and ruleset
+// are put back. an empty backup means there was no ruleset, proxymanager's
+// chain is removed.
+func ApplyRules(fileLinesBackup []string) error {
+	if settings.CheckDevMode() {
+		return nil
+	}
+
+	backend, err := GetBackend()
+	if err != nil {
+		return err
+	}
+
+	rulesFile := settings.LoadConfig().Firewall.RulesFile
+	applyErr := backend.Apply(GetChain(), rulesFile)
+	if applyErr == nil {
+		return nil
+	}
+
+	message := "There was an issue applying firewall rules: " + applyErr.Error()
+
+	if len(fileLinesBackup) == 0 {
+		err = os.Remove(rulesFile)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			err = backend.Remove(GetChain())
+		}
+	} else {
+		err = WriteRulesFileLines(fileLinesBackup)
+		if err == nil {
+			// reload the previous ruleset so the kernel matches the file
+			err = backend.Apply(GetChain(), rulesFile)
+		}
+	}
+	if err != nil {
+		return &errs.Error{Kind: transaction.ErrRollbackFailed, Message: message + "\nThe previous rules could not be restored: " + err.Error(), Err: err}
+	}
+
+	return &errs.Error{Kind: errs.ErrRolledBack, Message: message + "\nChanges were rolled back.", Err: applyErr}
+}
+
+// write rules and apply them, restoring the previous ruleset on failure
+func SaveRules(rules []Rule) error {
+	backend, err := GetBackend()
+	if err != nil {
+		return err
+	}
+
+	fileLinesBackup, err := ReadRulesFileLines()
+	if err != nil {
+		return fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	err = WriteRulesFileLines(backend.Render(GetChain(), rules))
+	if err != nil {
+		return fmt.Errorf("There was an issue writing file: %w", err)
+	}
+
+	return ApplyRules(fileLinesBackup)
+}
+
+// load fileLines with apply from a temporary file, leaving the rules file
+// untouched
+func applyTempFile(fileLines []string, apply func(filePath string) error) error {
+	file, err := os.CreateTemp("", "proxymanager-fw-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	for _, str := range fileLines {
+		_, err = file.WriteString(str + "\n")
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return apply(file.Name())
+}
+
+// temporarily accept tcp traffic to port from any address, call the
+// returned function to go back to the saved ruleset.
+func Bypass(port string) (func() error, error) {
+	if settings.CheckDevMode() {
+		return func() error { return nil }, nil
+	}
+
+	backend, err := GetBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.Bypass(GetChain(), port)
+}
+
+func List() error {
+	rules, err := GetRules()
+	if err != nil {
+		return fmt.Errorf("There was an issue reading firewall rules: %w", err)
+	}
+
+	if len(rules) == 0 {
+		fmt.Println("No firewall rules defined.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "IP Address\tAction")
+	for _, r := range rules {
+		formattedString := fmt.Sprintf("%v\t%v", r.IpAddress, r.Action)
+		fmt.Fprintln(w, formattedString)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func Block(ipAddress string) error {
+	return setAction(ipAddress, ActionBlock)
+}
+
+func Allow(ipAddress string) error {
+	return setAction(ipAddress, ActionAllow)
+}
+
+func setAction(ipAddress string, action string) error {
+	// validate ip address
+	if !validate.ValidateIPAddress(ipAddress) {
+		return errs.New(errs.ErrInvalidArgument, "Invalid IP Address format. must be value between 0.0.0.0 - 255.255.255.255.")
+	}
+
+	rules, err := GetRules()
+	if err != nil {
+		return fmt.Errorf("There was an issue reading firewall rules: %w", err)
+	}
+
+	for _, r := range rules {
+		if r.IpAddress == ipAddress && r.Action == action {
+			fmt.Printf("IP Address '%v' is already set to %v.\n", ipAddress, action)
+			return nil
+		}
+	}
+
+	err = SaveRules(SetRule(rules, ipAddress, action))
+	if err != nil {
+		return err
+	}
+
+	if action == ActionBlock {
+		fmt.Printf("IP Address '%v' blocked.\n", ipAddress)
+	} else {
+		fmt.Printf("IP Address '%v' allowed.\n", ipAddress)
+	}
+
+	return nil
+}
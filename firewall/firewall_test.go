@@ -0,0 +1,241 @@
+package firewall
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+func Getwd() string {
+	cwd, _ := os.Getwd()
+	return cwd
+}
+
+func GetConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return Getwd() + "\\..\\test_configs\\proxymanager.yml"
+	} else {
+		return Getwd() + "/../test_configs/proxymanager.yml"
+	}
+}
+
+func TestRenderParse(t *testing.T) {
+	tests := []struct {
+		Backend Backend
+		Rules   []Rule
+	}{
+		{NftablesBackend{}, []Rule{{IpAddress: "10.0.0.1", Action: ActionAllow}, {IpAddress: "10.0.0.2", Action: ActionBlock}}},
+		{NftablesBackend{}, nil},
+		{IptablesBackend{}, []Rule{{IpAddress: "10.0.0.1", Action: ActionAllow}, {IpAddress: "10.0.0.2", Action: ActionBlock}}},
+		{IptablesBackend{}, nil},
+	}
+
+	for _, test := range tests {
+		output := test.Backend.Parse("proxymanager", test.Backend.Render("proxymanager", test.Rules))
+		if len(output) != len(test.Rules) {
+			t.Errorf("%T: Expected %d rules, received %d", test.Backend, len(test.Rules), len(output))
+			continue
+		}
+
+		for i := range output {
+			if output[i] != test.Rules[i] {
+				t.Errorf("%T: Expected '%v' at index %d but received '%v'", test.Backend, test.Rules[i], i, output[i])
+			}
+		}
+	}
+}
+
+func TestRenderOrder(t *testing.T) {
+	rules := []Rule{{IpAddress: "10.0.0.2", Action: ActionBlock}, {IpAddress: "10.0.0.1", Action: ActionAllow}}
+	output := NftablesBackend{}.Parse("proxymanager", NftablesBackend{}.Render("proxymanager", rules))
+
+	if len(output) != 2 || output[0].Action != ActionAllow {
+		t.Errorf("Expected allow rules to be rendered first, received '%v'", output)
+	}
+}
+
+func TestRenderPort(t *testing.T) {
+	tests := []struct {
+		Backend  Backend
+		Expected string
+	}{
+		{NftablesBackend{}, "\t\ttcp dport 80 accept"},
+		{IptablesBackend{}, "-A PROXYMANAGER -p tcp --dport 80 -j ACCEPT"},
+	}
+
+	for _, test := range tests {
+		rules := []Rule{{Action: ActionAllow, Port: "80"}, {IpAddress: "10.0.0.1", Action: ActionBlock}}
+		output := test.Backend.Render("proxymanager", rules)
+
+		found := false
+		for _, line := range output {
+			if line == test.Expected {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("%T: Expected '%v' in ruleset, received '%v'", test.Backend, test.Expected, output)
+		}
+
+		// port rules are never read back as ip rules
+		if parsed := test.Backend.Parse("proxymanager", output); len(parsed) != 1 {
+			t.Errorf("%T: Expected 1 rule, received '%v'", test.Backend, parsed)
+		}
+	}
+}
+
+func TestSetRule(t *testing.T) {
+	tests := []struct {
+		Rules     []Rule
+		IpAddress string
+		Action    string
+		Expected  []Rule
+	}{
+		{nil, "10.0.0.1", ActionBlock, []Rule{{IpAddress: "10.0.0.1", Action: ActionBlock}}},
+		{[]Rule{{IpAddress: "10.0.0.1", Action: ActionBlock}}, "10.0.0.1", ActionAllow, []Rule{{IpAddress: "10.0.0.1", Action: ActionAllow}}},
+		{[]Rule{{IpAddress: "10.0.0.1", Action: ActionBlock}}, "10.0.0.2", ActionBlock, []Rule{{IpAddress: "10.0.0.1", Action: ActionBlock}, {IpAddress: "10.0.0.2", Action: ActionBlock}}},
+	}
+
+	for _, test := range tests {
+		output := SetRule(test.Rules, test.IpAddress, test.Action)
+		if len(output) != len(test.Expected) {
+			t.Errorf("Expected %d rules, received %d", len(test.Expected), len(output))
+			continue
+		}
+
+		for i := range output {
+			if output[i] != test.Expected[i] {
+				t.Errorf("Expected '%v' at index %d but received '%v'", test.Expected[i], i, output[i])
+			}
+		}
+	}
+}
+
+func TestBlockAllow(t *testing.T) {
+	tests := []struct {
+		Function  string
+		IpAddress string
+		Expected  string
+	}{
+		{"list", "", "Nofirewallrulesdefined."},
+		{"block", "10.0.0.256", "error: Invalid IP Address format. must be value between 0.0.0.0 - 255.255.255.255."},
+		{"block", "10.0.0.1", "IP Address '10.0.0.1' blocked."},
+		{"block", "10.0.0.1", "IP Address '10.0.0.1' is already set to block."},
+		{"allow", "10.0.0.1", "IP Address '10.0.0.1' allowed."},
+		{"block", "10.0.0.2", "IP Address '10.0.0.2' blocked."},
+		{"list", "", "IPAddressAction10.0.0.1allow10.0.0.2block"},
+	}
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent applying rules
+
+	for _, test := range tests {
+		// redirect stdout
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		// run function
+		var err error
+		switch test.Function {
+		case "list":
+			err = List()
+		case "block":
+			err = Block(test.IpAddress)
+		case "allow":
+			err = Allow(test.IpAddress)
+		}
+
+		// revert stdout
+		w.Close()
+		os.Stdout = oldStdout
+
+		// collect output to string
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(r)
+		output := strings.ReplaceAll(buf.String(), "\n", "")
+		if err != nil {
+			output = "error: " + err.Error()
+		}
+
+		// strip spaces from tables
+		if test.Function == "list" {
+			output = strings.ReplaceAll(output, " ", "")
+		}
+
+		if output != test.Expected {
+			t.Errorf("Expected '%v', received '%v'", test.Expected, output)
+		}
+	}
+
+	// cleanup rules file
+	os.Remove(settings.LoadConfig().Firewall.RulesFile)
+
+	os.Clearenv()
+}
+
+func TestBlockInvalidArgument(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true")
+
+	if err := Block("10.0.0.256"); !errors.Is(err, errs.ErrInvalidArgument) {
+		t.Errorf("Expected invalid argument, received '%v'", err)
+	}
+
+	os.Clearenv()
+}
+
+func TestRenderNftBypass(t *testing.T) {
+	listing := []string{
+		"table inet filter { # handle 1",
+		"\tchain input { # handle 1",
+		"\t\ttype filter hook input priority filter; policy drop;",
+		"\t\tct state established,related accept # handle 4",
+		"\t}",
+		"\tchain forward { # handle 2",
+		"\t\ttype filter hook forward priority filter; policy drop;",
+		"\t}",
+		"}",
+		"table inet proxymanager { # handle 2",
+		"\tchain input { # handle 1",
+		"\t\ttype filter hook input priority filter; policy accept;",
+		"\t\tip saddr 10.0.0.2 drop # handle 3",
+		"\t}",
+		"}",
+	}
+
+	// the host's input chain is bypassed too, not only proxymanager's
+	expected := []string{
+		"#!/usr/sbin/nft -f",
+		"insert rule inet filter input tcp dport 80 accept comment \"proxymanager bypass\"",
+		"insert rule inet proxymanager input tcp dport 80 accept comment \"proxymanager bypass\"",
+	}
+	output := RenderNftBypass(GetNftInputChains(listing), "80")
+	if strings.Join(output, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+
+	// listing once the bypass is loaded
+	bypassed := append([]string{}, listing[:3]...)
+	bypassed = append(bypassed, "\t\ttcp dport 80 accept comment \"proxymanager bypass\" # handle 7")
+	bypassed = append(bypassed, listing[3:12]...)
+	bypassed = append(bypassed, "\t\ttcp dport 80 accept comment \"proxymanager bypass\" # handle 8")
+	bypassed = append(bypassed, listing[12:]...)
+
+	expected = []string{
+		"#!/usr/sbin/nft -f",
+		"delete rule inet filter input handle 7",
+		"delete rule inet proxymanager input handle 8",
+	}
+	output = RenderNftBypassRemoval(bypassed)
+	if strings.Join(output, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+}
@@ -0,0 +1,124 @@
+package firewall
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// iptables backend, rules file is in iptables-save format and only
+// contains the proxymanager chain in the filter table
+type IptablesBackend struct{}
+
+func (i IptablesBackend) Render(chain string, rules []Rule) []string {
+	chain = strings.ToUpper(chain)
+	fileLines := []string{
+		"# DO NOT EDIT, USE proxymanager",
+		"*filter",
+		":" + chain + " - [0:0]",
+	}
+
+	for _, r := range orderRules(rules) {
+		target := "DROP"
+		if r.Action == ActionAllow {
+			target = "ACCEPT"
+		}
+
+		if r.Port != "" {
+			fileLines = append(fileLines, "-A "+chain+" -p tcp --dport "+r.Port+" -j "+target)
+			continue
+		}
+		fileLines = append(fileLines, "-A "+chain+" -s "+r.IpAddress+"/32 -j "+target)
+	}
+
+	return append(fileLines, "COMMIT")
+}
+
+func (i IptablesBackend) Parse(chain string, fileLines []string) []Rule {
+	chain = strings.ToUpper(chain)
+	pattern := regexp.MustCompile("^-A " + regexp.QuoteMeta(chain) + ` -s ([0-9.]+)(/32)? -j (ACCEPT|DROP)\s*$`)
+
+	var rules []Rule
+	for _, line := range fileLines {
+		matches := pattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		action := ActionBlock
+		if matches[3] == "ACCEPT" {
+			action = ActionAllow
+		}
+		rules = append(rules, Rule{IpAddress: matches[1], Action: action})
+	}
+
+	return rules
+}
+
+func (i IptablesBackend) Apply(chain string, rulesFile string) error {
+	chain = strings.ToUpper(chain)
+
+	// check ruleset before loading it, --noflush leaves other chains alone
+	err := exec.Command("iptables-restore", "--test", "--noflush", rulesFile).Run()
+	if err != nil {
+		return err
+	}
+
+	err = exec.Command("iptables-restore", "--noflush", rulesFile).Run()
+	if err != nil {
+		return err
+	}
+
+	// make sure INPUT jumps to the proxymanager chain exactly once
+	if exec.Command("iptables", "-C", "INPUT", "-j", chain).Run() != nil {
+		return exec.Command("iptables", "-I", "INPUT", "-j", chain).Run()
+	}
+
+	return nil
+}
+
+func (i IptablesBackend) Remove(chain string) error {
+	chain = strings.ToUpper(chain)
+
+	// chain was never loaded
+	if exec.Command("iptables", "-n", "-L", chain).Run() != nil {
+		return nil
+	}
+
+	for exec.Command("iptables", "-C", "INPUT", "-j", chain).Run() == nil {
+		err := exec.Command("iptables", "-D", "INPUT", "-j", chain).Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := exec.Command("iptables", "-F", chain).Run()
+	if err != nil {
+		return err
+	}
+
+	return exec.Command("iptables", "-X", chain).Run()
+}
+
+// INPUT jumps to the proxymanager chain first, a port rule at its top
+// accepts before anything else in the filter table
+func (i IptablesBackend) Bypass(chain string, port string) (func() error, error) {
+	rules, err := GetRules()
+	if err != nil {
+		return nil, err
+	}
+
+	apply := func(rulesFile string) error {
+		return i.Apply(chain, rulesFile)
+	}
+
+	bypassRules := append([]Rule{{Action: ActionAllow, Port: port}}, rules...)
+	err = applyTempFile(i.Render(chain, bypassRules), apply)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return applyTempFile(i.Render(chain, rules), apply)
+	}, nil
+}
@@ -0,0 +1,207 @@
+package firewall
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// comment of the rules added by Bypass
+const bypassComment = "proxymanager bypass"
+
+var (
+	nftTable  = regexp.MustCompile(`^table (\S+) (\S+) \{`)
+	nftChain  = regexp.MustCompile(`^\s*chain (\S+) \{`)
+	nftHandle = regexp.MustCompile(`# handle (\d+)\s*$`)
+)
+
+// nftables backend, the chain name is used as a dedicated inet table.
+// an accept in this table only ends its own chain, tables of the host with
+// an input hook still see the packet and can drop it.
+type NftablesBackend struct{}
+
+func (n NftablesBackend) Render(chain string, rules []Rule) []string {
+	fileLines := []string{
+		"#!/usr/sbin/nft -f",
+		"# DO NOT EDIT, USE proxymanager",
+		// create then delete so the table is replaced on every load
+		"table inet " + chain,
+		"delete table inet " + chain,
+		"table inet " + chain + " {",
+		"\tchain input {",
+		"\t\ttype filter hook input priority filter; policy accept;",
+	}
+
+	// allow rules are evaluated before block rules
+	for _, r := range orderRules(rules) {
+		verdict := "drop"
+		if r.Action == ActionAllow {
+			verdict = "accept"
+		}
+
+		if r.Port != "" {
+			fileLines = append(fileLines, "\t\ttcp dport "+r.Port+" "+verdict)
+			continue
+		}
+		fileLines = append(fileLines, "\t\tip saddr "+r.IpAddress+" "+verdict)
+	}
+
+	return append(fileLines, "\t}", "}")
+}
+
+func (n NftablesBackend) Parse(chain string, fileLines []string) []Rule {
+	pattern := regexp.MustCompile(`^\s*ip saddr (\S+) (accept|drop)\s*$`)
+
+	var rules []Rule
+	for _, line := range fileLines {
+		matches := pattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		action := ActionBlock
+		if matches[2] == "accept" {
+			action = ActionAllow
+		}
+		rules = append(rules, Rule{IpAddress: matches[1], Action: action})
+	}
+
+	return rules
+}
+
+func (n NftablesBackend) Apply(chain string, rulesFile string) error {
+	return loadNftFile(rulesFile)
+}
+
+func (n NftablesBackend) Remove(chain string) error {
+	// table was never loaded
+	if exec.Command("nft", "list", "table", "inet", chain).Run() != nil {
+		return nil
+	}
+
+	return exec.Command("nft", "delete", "table", "inet", chain).Run()
+}
+
+// accepting in proxymanager's table doesn't override drops of other tables,
+// the accept is inserted at the top of every input chain instead
+func (n NftablesBackend) Bypass(chain string, port string) (func() error, error) {
+	listing, err := listNftRuleset()
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing filters input
+	chains := GetNftInputChains(listing)
+	if len(chains) == 0 {
+		return func() error { return nil }, nil
+	}
+
+	err = applyTempFile(RenderNftBypass(chains, port), loadNftFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		listing, err := listNftRuleset()
+		if err != nil {
+			return err
+		}
+
+		return applyTempFile(RenderNftBypassRemoval(listing), loadNftFile)
+	}, nil
+}
+
+func loadNftFile(rulesFile string) error {
+	// check ruleset before loading it
+	err := exec.Command("nft", "-c", "-f", rulesFile).Run()
+	if err != nil {
+		return err
+	}
+
+	return exec.Command("nft", "-f", rulesFile).Run()
+}
+
+// loaded ruleset with rule handles
+func listNftRuleset() ([]string, error) {
+	output, err := exec.Command("nft", "-a", "list", "ruleset").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(output), "\n"), nil
+}
+
+// call fn for every line inside a chain of 'nft -a list ruleset' output,
+// chain is "<family> <table> <chain>"
+func walkNftRuleset(listing []string, fn func(chain string, line string)) {
+	var table string
+	var chain string
+	for _, line := range listing {
+		if matches := nftTable.FindStringSubmatch(line); matches != nil {
+			table = matches[1] + " " + matches[2]
+			chain = ""
+			continue
+		}
+
+		if matches := nftChain.FindStringSubmatch(line); matches != nil && table != "" {
+			chain = table + " " + matches[1]
+			continue
+		}
+
+		if chain != "" {
+			fn(chain, line)
+		}
+	}
+}
+
+// base chains hooked to input, as "<family> <table> <chain>"
+func GetNftInputChains(listing []string) []string {
+	var chains []string
+	walkNftRuleset(listing, func(chain string, line string) {
+		if strings.Contains(line, " hook input ") {
+			chains = append(chains, chain)
+		}
+	})
+
+	return chains
+}
+
+// nft script accepting tcp traffic to port at the top of chains
+func RenderNftBypass(chains []string, port string) []string {
+	fileLines := []string{"#!/usr/sbin/nft -f"}
+	for _, chain := range chains {
+		fileLines = append(fileLines, "insert rule "+chain+" tcp dport "+port+" accept comment \""+bypassComment+"\"")
+	}
+
+	return fileLines
+}
+
+// nft script deleting the rules added by RenderNftBypass
+func RenderNftBypassRemoval(listing []string) []string {
+	fileLines := []string{"#!/usr/sbin/nft -f"}
+	walkNftRuleset(listing, func(chain string, line string) {
+		matches := nftHandle.FindStringSubmatch(line)
+		if matches != nil && strings.Contains(line, "comment \""+bypassComment+"\"") {
+			fileLines = append(fileLines, "delete rule "+chain+" handle "+matches[1])
+		}
+	})
+
+	return fileLines
+}
+
+// allow rules first, keeping relative order otherwise
+func orderRules(rules []Rule) []Rule {
+	var ordered []Rule
+	for _, r := range rules {
+		if r.Action == ActionAllow {
+			ordered = append(ordered, r)
+		}
+	}
+	for _, r := range rules {
+		if r.Action != ActionAllow {
+			ordered = append(ordered, r)
+		}
+	}
+
+	return ordered
+}
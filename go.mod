@@ -4,5 +4,12 @@ go 1.21.13
 
 require (
 	github.com/mitchellh/hashstructure/v2 v2.0.2
+	github.com/spf13/cobra v1.8.1
+	golang.org/x/crypto v0.31.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+)
@@ -2,75 +2,177 @@ package loadbalancer
 
 import (
 	"errors"
-	"fmt"
-	"os"
-	"regexp"
+	"sort"
 	"strings"
-	"text/tabwriter"
+
+	validate "nickneal.dev/go-proxymanager/utils/validate"
 )
 
 type Host struct {
+	Name            string
 	IpAddress       string
 	Enabled         bool
 	AdditionalHosts []string
+	// inline comment after the host names, without '#'
+	Comment string
+
+	// original line, written back as long as the host is unchanged
+	raw string
 }
 
-type Hosts map[string]Host
+// lines of a cluster block in file order. comment and blank lines inside
+// the block are kept as entries without a Name.
+type Hosts struct {
+	entries []Host
+}
+
+// parse a single line of a cluster block. fields are separated by any
+// whitespace and may be followed by a '#' comment. blank lines and comments
+// are entries without a Name, other lines that aren't a host are returned
+// as such with an error.
+func ParseHost(line string) (Host, error) {
+	host := Host{raw: line}
+
+	str := strings.TrimSpace(strings.TrimRight(line, "\r"))
+
+	// check if host is disabled and remove '#' if so
+	enabled := true
+	if strings.HasPrefix(str, "#") {
+		enabled = false
+		str = strings.TrimSpace(strings.TrimPrefix(str, "#"))
+	}
+
+	var comment string
+	if index := strings.Index(str, "#"); index >= 0 {
+		comment = strings.TrimSpace(str[index+1:])
+		str = str[:index]
+	}
+
+	items := strings.Fields(str)
+	if len(items) < 2 || !validate.ValidateIPAddress(items[0]) {
+		// commented out lines that aren't hosts are comments
+		switch {
+		case !enabled || len(items) == 0:
+			return host, nil
+		case !validate.ValidateIPAddress(items[0]):
+			return host, errors.New("invalid ip address '" + items[0] + "'")
+		}
+		return host, errors.New("no host name after ip address '" + items[0] + "'")
+	}
+
+	host.IpAddress = items[0]
+	host.Name = items[1]
+	host.Enabled = enabled
+	host.Comment = comment
+
+	// if additional items
+	if len(items) > 2 {
+		host.AdditionalHosts = append(host.AdditionalHosts, items[2:]...)
+	}
+
+	return host, nil
+}
+
+// parse a single line of a cluster block, lines that aren't a host are kept
+// as comments
+func NewHost(line string) Host {
+	host, _ := ParseHost(line)
+	return host
+}
 
 func NewHostConfig(hostInfo []string) Hosts {
 	var hosts Hosts
-	hosts = make(Hosts)
 
 	for _, str := range hostInfo {
-		var host Host
-
-		// check if host is disabled and remove '#' if so
-		disabled_pattern := "^#.*"
-		if regexp.MustCompile(disabled_pattern).MatchString(str) {
-			host.Enabled = false
-			str = strings.TrimSpace(strings.Replace(str, "#", "", 1))
-		} else {
-			host.Enabled = true
-		}
+		hosts.entries = append(hosts.entries, NewHost(str))
+	}
+
+	return hosts
+}
+
+func (host Host) String() string {
+	// comment lines and unchanged hosts are written as read
+	if host.Name == "" || host.raw != "" {
+		return host.raw
+	}
+
+	hostStr := strings.TrimSpace(host.IpAddress + " " + host.Name + " " + strings.Join(host.AdditionalHosts, " "))
+	if host.Comment != "" {
+		hostStr = hostStr + " # " + host.Comment
+	}
+	if !host.Enabled {
+		hostStr = "#" + hostStr
+	}
 
-		items := strings.Split(str, " ")
-		hostname := items[1]
-		host.IpAddress = items[0]
+	return hostStr
+}
 
-		// if additional items
-		if len(items) > 2 {
-			host.AdditionalHosts = append(host.AdditionalHosts, items[2:]...)
+// index of host in entries, -1 if it doesn't exist
+func (h *Hosts) index(host string) int {
+	for index, e := range h.entries {
+		if e.Name != "" && e.Name == host {
+			return index
 		}
+	}
+
+	return -1
+}
 
-		// append host
-		hosts[hostname] = host
+// hosts in file order
+func (h *Hosts) List() []Host {
+	var hosts []Host
+	for _, e := range h.entries {
+		if e.Name != "" {
+			hosts = append(hosts, e)
+		}
 	}
 
 	return hosts
 }
 
-func (h *Hosts) PrintHosts() {
-	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
-	fmt.Fprintln(w, "Host\tIP Address\tEnabled\tAdditional Hosts")
-	for k, v := range *h {
-		formattedString := fmt.Sprintf("%v\t%v\t%v\t%v", k, v.IpAddress, v.Enabled, strings.Join(v.AdditionalHosts, ","))
-		fmt.Fprintln(w, formattedString)
+func (h *Hosts) Len() int {
+	return len(h.List())
+}
+
+func (h *Hosts) Get(host string) (Host, bool) {
+	index := h.index(host)
+	if index < 0 {
+		return Host{}, false
 	}
-	w.Flush()
+
+	return h.entries[index], true
 }
 
-func (h *Hosts) HostExists(host string) bool {
-	for k, _ := range *h {
-		if k == host {
-			return true
-		}
+// replace host, dropping its original line
+func (h *Hosts) set(host Host) {
+	host.raw = ""
+
+	index := h.index(host.Name)
+	if index < 0 {
+		h.entries = append(h.entries, host)
+		return
 	}
 
-	return false
+	h.entries[index] = host
+}
+
+// sorted host names, used as upstream nodes
+func (h *Hosts) Names() []string {
+	var names []string
+	for _, v := range h.List() {
+		names = append(names, []string{v.Name}...)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (h *Hosts) HostExists(host string) bool {
+	return h.index(host) >= 0
 }
 
 func (h *Hosts) IPExists(ipAddress string) bool {
-	for _, v := range *h {
+	for _, v := range h.List() {
 		if v.IpAddress == ipAddress {
 			return true
 		}
@@ -81,15 +183,31 @@ func (h *Hosts) IPExists(ipAddress string) bool {
 
 func (h *Hosts) AddHost(host string, ipAddress string) error {
 	var newHost Host
+	newHost.Name = host
 	newHost.Enabled = true
 	newHost.IpAddress = ipAddress
 
-	(*h)[host] = newHost
+	h.set(newHost)
+	return nil
+}
+
+// change the ip address of host, moved traffic is kept
+func (h *Hosts) SetIpAddress(host string, ipAddress string) error {
+	existing, found := h.Get(host)
+	if !found {
+		return errors.New("loadbalancer(SetIpAddress): host '" + host + "' does not exist")
+	}
+
+	existing.IpAddress = ipAddress
+	h.set(existing)
 	return nil
 }
 
 func (h *Hosts) DelHost(host string) error {
-	delete((*h), host)
+	index := h.index(host)
+	if index >= 0 {
+		h.entries = append(h.entries[:index], h.entries[index+1:]...)
+	}
 	return nil
 }
 
@@ -99,89 +217,70 @@ func (h *Hosts) MoveTraffic(fromHost string, toHost string) error {
 		return errors.New("loadbalancer(MoveTraffic): can't move traffic to self")
 	}
 
+	from, _ := h.Get(fromHost)
+	to, _ := h.Get(toHost)
+
 	// check if fromHost already disabled.
-	if !(*h)[fromHost].Enabled {
+	if !from.Enabled {
 		return errors.New("loadbalancer(MoveTraffic): fromHost already disabled")
 	}
 
 	// check if from host is currently holding traffic
-	if len((*h)[fromHost].AdditionalHosts) > 0 {
+	if len(from.AdditionalHosts) > 0 {
 		return errors.New("loadbalancer(MoveTraffic): fromHost is already handling additional host's traffic")
 	}
 
 	// check if toHost is disabled.
-	if !(*h)[toHost].Enabled {
+	if !to.Enabled {
 		return errors.New("loadbalancer(MoveTraffic): toHost is disabled")
 	}
 
-	var from Host
-	from.IpAddress = (*h)[fromHost].IpAddress
 	from.Enabled = false
+	to.AdditionalHosts = append(append([]string{}, to.AdditionalHosts...), fromHost)
 
-	var to Host
-	to.IpAddress = (*h)[toHost].IpAddress
-	to.Enabled = true
-	to.AdditionalHosts = append((*h)[toHost].AdditionalHosts, []string{fromHost}...)
-
-	delete((*h), fromHost)
-	delete((*h), fromHost)
-
-	(*h)[fromHost] = from
-	(*h)[toHost] = to
+	h.set(from)
+	h.set(to)
 
 	return nil
 }
 
 func (h *Hosts) RestoreTraffic(host string) error {
-	if (*h)[host].Enabled {
+	restore, _ := h.Get(host)
+	if restore.Enabled {
 		return errors.New("loadbalancer(MoveTraffic): Host is already enabled")
 	}
 
-	var restore Host
-	restore.Enabled = true
-	restore.IpAddress = (*h)[host].IpAddress
-
-	var update Host
-	var updateHost string
 	// find where host traffic has been routed to
-outerLoop:
-	for k, v := range *h {
+	for _, v := range h.List() {
+		var additionalHosts []string
+		routed := false
 		for _, str := range v.AdditionalHosts {
+			// skip if restore host
 			if str == host {
-				updateHost = k
-				break outerLoop
+				routed = true
+				continue
 			}
-		}
-	}
 
-	update.Enabled = true
-	update.IpAddress = (*h)[updateHost].IpAddress
-	for _, str := range (*h)[updateHost].AdditionalHosts {
-		// skip if restore host
-		if str == host {
-			continue
+			additionalHosts = append(additionalHosts, []string{str}...)
 		}
 
-		update.AdditionalHosts = append(update.AdditionalHosts, []string{str}...)
+		if routed {
+			v.AdditionalHosts = additionalHosts
+			h.set(v)
+			break
+		}
 	}
 
-	delete((*h), host)
-	delete((*h), updateHost)
-
-	(*h)[host] = restore
-	(*h)[updateHost] = update
+	restore.Enabled = true
+	h.set(restore)
 
 	return nil
 }
 
 func (h *Hosts) ToArray() []string {
 	var hosts []string
-	for k, v := range *h {
-		hostStr := v.IpAddress + " " + k + " " + strings.Join(v.AdditionalHosts, " ")
-		if !v.Enabled {
-			hostStr = "#" + hostStr
-		}
-		hosts = append(hosts, []string{strings.TrimSpace(hostStr)}...)
+	for _, e := range h.entries {
+		hosts = append(hosts, []string{e.String()}...)
 	}
 
 	return hosts
@@ -0,0 +1,78 @@
+package loadbalancer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		Line     string
+		Expected Host
+		Error    string
+	}{
+		{"10.0.0.1 node01.local", Host{Name: "node01.local", IpAddress: "10.0.0.1", Enabled: true}, ""},
+		{"\t10.0.0.1\t\tnode01.local  alias.local\r", Host{Name: "node01.local", IpAddress: "10.0.0.1", Enabled: true, AdditionalHosts: []string{"alias.local"}}, ""},
+		{"10.0.0.1 node01.local# rack 2", Host{Name: "node01.local", IpAddress: "10.0.0.1", Enabled: true, Comment: "rack 2"}, ""},
+		{"#  10.0.0.1   node01.local", Host{Name: "node01.local", IpAddress: "10.0.0.1"}, ""},
+		{"", Host{}, ""},
+		{" \t ", Host{}, ""},
+		{"# workers", Host{}, ""},
+		{"#", Host{}, ""},
+		{"10.0.0.1", Host{}, "no host name after ip address '10.0.0.1'"},
+		{"10.0.0.1 # node01.local", Host{}, "no host name after ip address '10.0.0.1'"},
+		{"10.0.0.300 node01.local", Host{}, "invalid ip address '10.0.0.300'"},
+		{"node01.local", Host{}, "invalid ip address 'node01.local'"},
+	}
+
+	for _, test := range tests {
+		host, err := ParseHost(test.Line)
+
+		var output string
+		if err != nil {
+			output = err.Error()
+		}
+		if output != test.Error {
+			t.Errorf("Line %q: Expected error '%v', received '%v'", test.Line, test.Error, output)
+		}
+
+		host.raw = ""
+		if host.String() != test.Expected.String() || host.Comment != test.Expected.Comment {
+			t.Errorf("Line %q: Expected '%v', received '%v'", test.Line, test.Expected, host)
+		}
+	}
+}
+
+// any line parses without panicking. hosts keep their line and render to a
+// line that parses to the same host.
+func FuzzParseHost(f *testing.F) {
+	for _, line := range []string{"10.0.0.1 node01.local", "#10.0.0.1\tnode01.local a.local # c", "", "#", "10.0.0.1", " \r", "::1 localhost"} {
+		f.Add(line)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		host, err := ParseHost(line)
+		if err != nil || host.Name == "" {
+			if host.Name != "" || host.String() != line {
+				t.Errorf("Line %q: Expected to be kept as is, received '%v'", line, host)
+			}
+			return
+		}
+
+		if host.String() != line {
+			t.Errorf("Line %q: Expected to be written as read, received %q", line, host.String())
+		}
+
+		// newlines in a line can't come from a hosts file
+		if strings.ContainsAny(line, "\n") {
+			return
+		}
+
+		host.raw = ""
+		parsed, err := ParseHost(host.String())
+		if err != nil || parsed.Name != host.Name || parsed.IpAddress != host.IpAddress || parsed.Enabled != host.Enabled ||
+			strings.Join(parsed.AdditionalHosts, " ") != strings.Join(host.AdditionalHosts, " ") {
+			t.Errorf("Line %q: Expected '%v' after rendering, received '%v' '%v'", line, host, parsed, err)
+		}
+	})
+}
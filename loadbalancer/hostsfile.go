@@ -0,0 +1,281 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	settings "nickneal.dev/go-proxymanager/utils/settings"
+)
+
+const clusterComment = "# DO NOT EDIT, USE proxymanager"
+
+var (
+	blockBeginPattern     = regexp.MustCompile(`^### LB_K8S\(([^)]*)\)`)
+	blockEndPattern       = regexp.MustCompile(`^### LB_K8S_END`)
+	clusterCommentPattern = regexp.MustCompile(`^# DO NOT EDIT`)
+)
+
+// '### LB_K8S(<cluster>)' ... '### LB_K8S_END' section of the hosts file
+type Block struct {
+	Cluster string
+	Hosts   Hosts
+
+	// marker lines as read
+	begin string
+	end   string
+}
+
+func (b *Block) Lines() []string {
+	return append(append([]string{b.begin}, b.Hosts.ToArray()...), b.end)
+}
+
+// a line of the hosts file outside of cluster blocks, or a whole block
+type hostsFileLine struct {
+	text  string
+	block *Block
+}
+
+// line of a cluster block that isn't a host, comment or blank
+type LineError struct {
+	Line    int
+	Cluster string
+	Err     error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// whole hosts file in order. lines outside of cluster blocks are never
+// changed, so a file is written back byte for byte unless a block changed.
+type HostsFile struct {
+	lines []hostsFileLine
+	// lines of blocks that aren't hosts, kept as comments
+	errors []*LineError
+}
+
+func ParseHostsFile(data string) (*HostsFile, error) {
+	hostsFile := &HostsFile{}
+
+	var block *Block
+	var blockLines []string
+	// the text after the last newline is kept as its own line, "" when the
+	// file ends with a newline
+	for index, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimRight(line, "\r")
+
+		if matches := blockBeginPattern.FindStringSubmatch(trimmed); matches != nil {
+			if block != nil {
+				return nil, errors.New("loadbalancer(ParseHostsFile): cluster '" + block.Cluster + "' has no end")
+			}
+
+			block = &Block{Cluster: matches[1], begin: line}
+			blockLines = nil
+			continue
+		}
+
+		if block != nil {
+			if blockEndPattern.MatchString(trimmed) {
+				block.end = line
+				block.Hosts = NewHostConfig(blockLines)
+				hostsFile.lines = append(hostsFile.lines, hostsFileLine{block: block})
+				block = nil
+				continue
+			}
+
+			if _, err := ParseHost(line); err != nil {
+				hostsFile.errors = append(hostsFile.errors, &LineError{Line: index + 1, Cluster: block.Cluster, Err: err})
+			}
+			blockLines = append(blockLines, line)
+			continue
+		}
+
+		hostsFile.lines = append(hostsFile.lines, hostsFileLine{text: line})
+	}
+
+	if block != nil {
+		return nil, errors.New("loadbalancer(ParseHostsFile): cluster '" + block.Cluster + "' has no end")
+	}
+
+	return hostsFile, nil
+}
+
+// markers that ParseHostsFile rejects or silently keeps as plain lines,
+// one message each with its line number
+func CheckHostsFile(data string) []string {
+	var problems []string
+
+	begin := 0
+	cluster := ""
+	for index, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimRight(line, "\r")
+		number := index + 1
+
+		if matches := blockBeginPattern.FindStringSubmatch(trimmed); matches != nil {
+			if begin > 0 {
+				problems = append(problems, fmt.Sprintf("line %v: cluster '%v' has no end before cluster '%v'", begin, cluster, matches[1]))
+			}
+			begin = number
+			cluster = matches[1]
+			continue
+		}
+
+		if blockEndPattern.MatchString(trimmed) {
+			if begin == 0 {
+				problems = append(problems, fmt.Sprintf("line %v: end marker without a cluster", number))
+			}
+			begin = 0
+		}
+	}
+
+	if begin > 0 {
+		problems = append(problems, fmt.Sprintf("line %v: cluster '%v' has no end", begin, cluster))
+	}
+
+	return problems
+}
+
+// lines of cluster blocks that aren't hosts, in file order
+func (f *HostsFile) Errors() []*LineError {
+	return f.errors
+}
+
+func (f *HostsFile) Lines() []string {
+	var lines []string
+	for _, line := range f.lines {
+		if line.block != nil {
+			lines = append(lines, line.block.Lines()...)
+		} else {
+			lines = append(lines, line.text)
+		}
+	}
+
+	return lines
+}
+
+func (f *HostsFile) String() string {
+	return strings.Join(f.Lines(), "\n")
+}
+
+// cluster names in file order
+func (f *HostsFile) Clusters() []string {
+	var clusters []string
+	for _, line := range f.lines {
+		if line.block != nil {
+			clusters = append(clusters, line.block.Cluster)
+		}
+	}
+
+	return clusters
+}
+
+// host is a name or alias of any line, in or outside of cluster blocks and
+// disabled or not
+func (f *HostsFile) HostExists(host string) bool {
+	for _, line := range f.Lines() {
+		str := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+		if index := strings.Index(str, "#"); index >= 0 {
+			str = str[:index]
+		}
+
+		items := strings.Fields(str)
+		if len(items) > 1 && net.ParseIP(items[0]) != nil && slices.Contains(items[1:], host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cluster block, nil if the cluster doesn't exist
+func (f *HostsFile) GetCluster(cluster string) *Block {
+	for _, line := range f.lines {
+		if line.block != nil && line.block.Cluster == cluster {
+			return line.block
+		}
+	}
+
+	return nil
+}
+
+// add an empty cluster block after the last one, or at the end of the file
+func (f *HostsFile) AddCluster(cluster string) (*Block, error) {
+	if f.GetCluster(cluster) != nil {
+		return nil, errors.New("loadbalancer(AddCluster): cluster '" + cluster + "' already exists")
+	}
+
+	block := &Block{Cluster: cluster, begin: "### LB_K8S(" + cluster + ")", end: "### LB_K8S_END"}
+	newLines := []hostsFileLine{{text: ""}, {text: clusterComment}, {block: block}}
+
+	position := len(f.lines)
+	// keep the trailing newline at the end of the file
+	if position > 0 && f.lines[position-1].block == nil && f.lines[position-1].text == "" {
+		position--
+	}
+	for index, line := range f.lines {
+		if line.block != nil {
+			position = index + 1
+		}
+	}
+
+	f.lines = append(f.lines[:position], append(newLines, f.lines[position:]...)...)
+	return block, nil
+}
+
+// remove cluster block together with the comment and blank line above it
+func (f *HostsFile) RemoveCluster(cluster string) error {
+	for index, line := range f.lines {
+		if line.block == nil || line.block.Cluster != cluster {
+			continue
+		}
+
+		start := index
+		if start > 0 && f.lines[start-1].block == nil && clusterCommentPattern.MatchString(f.lines[start-1].text) {
+			start--
+			if start > 0 && f.lines[start-1].block == nil && strings.TrimSpace(f.lines[start-1].text) == "" {
+				start--
+			}
+		}
+
+		f.lines = append(f.lines[:start], f.lines[index+1:]...)
+		return nil
+	}
+
+	return errors.New("loadbalancer(RemoveCluster): cluster '" + cluster + "' does not exist")
+}
+
+func ReadHostsFile() (*HostsFile, error) {
+	data, err := os.ReadFile(GetHostsFilePath())
+	if err != nil {
+		return nil, err
+	}
+
+	hostsFile, err := ParseHostsFile(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// refuse to guess what a broken line meant, see 'proxymanager check'
+	if len(hostsFile.errors) > 0 {
+		var lineErrors []error
+		for _, lineError := range hostsFile.errors {
+			lineErrors = append(lineErrors, lineError)
+		}
+		return nil, fmt.Errorf("loadbalancer(ReadHostsFile): cluster blocks have lines that aren't hosts:\n%w", errors.Join(lineErrors...))
+	}
+
+	return hostsFile, nil
+}
+
+func GetHostsFilePath() string {
+	return settings.LoadConfig().LoadBalancer.HostsFile
+}
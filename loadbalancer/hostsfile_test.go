@@ -0,0 +1,235 @@
+package loadbalancer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const testHostsFile = `127.0.0.1	localhost
+::1     localhost ip6-localhost # ipv6
+
+# DO NOT EDIT, USE proxymanager
+### LB_K8S(prod)
+# workers
+10.0.0.3   node03.local    # rack 2
+10.0.0.1 node01.local
+#10.0.0.2 node02.local
+
+### LB_K8S_END
+10.1.0.1 foreign.local
+`
+
+func TestParseHostsFileRoundTrip(t *testing.T) {
+	tests := []string{
+		testHostsFile,
+		strings.TrimSuffix(testHostsFile, "\n"),
+		strings.ReplaceAll(testHostsFile, "\n", "\r\n"),
+		"",
+		"\n\n",
+	}
+
+	hostsData, err := os.ReadFile("../test_configs/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests = append(tests, string(hostsData))
+
+	for _, test := range tests {
+		hostsFile, err := ParseHostsFile(test)
+		if err != nil {
+			t.Errorf("Expected no error for %q, received %v", test, err)
+			continue
+		}
+
+		if output := hostsFile.String(); output != test {
+			t.Errorf("Expected %q, received %q", test, output)
+		}
+	}
+}
+
+func TestParseHostsFileErrors(t *testing.T) {
+	tests := []string{
+		"### LB_K8S(prod)\n10.0.0.1 node01.local\n",
+		"### LB_K8S(prod)\n### LB_K8S(test)\n### LB_K8S_END\n",
+	}
+
+	for _, test := range tests {
+		if _, err := ParseHostsFile(test); err == nil {
+			t.Errorf("Expected error for %q", test)
+		}
+	}
+}
+
+func TestHostsFileChanges(t *testing.T) {
+	hostsFile, _ := ParseHostsFile(testHostsFile)
+	block := hostsFile.GetCluster("prod")
+
+	if names := strings.Join(block.Hosts.Names(), ","); names != "node01.local,node02.local,node03.local" {
+		t.Errorf("Expected sorted names, received '%v'", names)
+	}
+
+	_ = block.Hosts.AddHost("node04.local", "10.0.0.4")
+	_ = block.Hosts.MoveTraffic("node01.local", "node03.local")
+	_ = block.Hosts.DelHost("node02.local")
+
+	expected := strings.Replace(testHostsFile, `10.0.0.3   node03.local    # rack 2
+10.0.0.1 node01.local
+#10.0.0.2 node02.local
+
+`, `10.0.0.3 node03.local node01.local # rack 2
+#10.0.0.1 node01.local
+
+10.0.0.4 node04.local
+`, 1)
+	if output := hostsFile.String(); output != expected {
+		t.Errorf("Expected %q, received %q", expected, output)
+	}
+
+	_ = block.Hosts.RestoreTraffic("node01.local")
+	expected = strings.Replace(expected, `10.0.0.3 node03.local node01.local # rack 2
+#10.0.0.1 node01.local
+`, `10.0.0.3 node03.local # rack 2
+10.0.0.1 node01.local
+`, 1)
+	if output := hostsFile.String(); output != expected {
+		t.Errorf("Expected %q, received %q", expected, output)
+	}
+}
+
+func TestHostsFileClusters(t *testing.T) {
+	hostsFile, _ := ParseHostsFile(testHostsFile)
+
+	if _, err := hostsFile.AddCluster("prod"); err == nil {
+		t.Errorf("Expected error adding existing cluster")
+	}
+
+	_, _ = hostsFile.AddCluster("test")
+	if clusters := strings.Join(hostsFile.Clusters(), ","); clusters != "prod,test" {
+		t.Errorf("Expected 'prod,test', received '%v'", clusters)
+	}
+
+	expected := strings.Replace(testHostsFile, "### LB_K8S_END\n", "### LB_K8S_END\n\n# DO NOT EDIT, USE proxymanager\n### LB_K8S(test)\n### LB_K8S_END\n", 1)
+	if output := hostsFile.String(); output != expected {
+		t.Errorf("Expected %q, received %q", expected, output)
+	}
+
+	// removing restores the original file
+	_ = hostsFile.RemoveCluster("test")
+	if output := hostsFile.String(); output != testHostsFile {
+		t.Errorf("Expected %q, received %q", testHostsFile, output)
+	}
+
+	// file without clusters gets the block at the end
+	hostsFile, _ = ParseHostsFile("127.0.0.1 localhost\n")
+	_, _ = hostsFile.AddCluster("test")
+	expected = "127.0.0.1 localhost\n\n# DO NOT EDIT, USE proxymanager\n### LB_K8S(test)\n### LB_K8S_END\n"
+	if output := hostsFile.String(); output != expected {
+		t.Errorf("Expected %q, received %q", expected, output)
+	}
+
+	if err := hostsFile.RemoveCluster("missing"); err == nil {
+		t.Errorf("Expected error removing missing cluster")
+	}
+}
+
+func TestCheckHostsFile(t *testing.T) {
+	tests := []struct {
+		Data     string
+		Expected []string
+	}{
+		{testHostsFile, nil},
+		{"### LB_K8S(prod)\n10.0.0.1 node01.local\n", []string{"line 1: cluster 'prod' has no end"}},
+		{"### LB_K8S(prod)\n### LB_K8S(test)\n### LB_K8S_END\n", []string{"line 1: cluster 'prod' has no end before cluster 'test'"}},
+		{"127.0.0.1 localhost\n### LB_K8S_END\n", []string{"line 2: end marker without a cluster"}},
+	}
+
+	for _, test := range tests {
+		if problems := CheckHostsFile(test.Data); strings.Join(problems, "\n") != strings.Join(test.Expected, "\n") {
+			t.Errorf("Expected '%v', received '%v'", test.Expected, problems)
+		}
+	}
+}
+
+func TestHostsFileErrors(t *testing.T) {
+	hostsFile, err := ParseHostsFile("127.0.0.1 localhost\n### LB_K8S(prod)\n\n# workers\n10.0.0.1\tnode01.local\n10.0.0.300 node03.local\nnode04.local\n10.0.0.5\n### LB_K8S_END\n")
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'", err)
+	}
+
+	var output []string
+	for _, lineError := range hostsFile.Errors() {
+		output = append(output, lineError.Cluster+" "+lineError.Error())
+	}
+
+	expected := []string{
+		"prod line 6: invalid ip address '10.0.0.300'",
+		"prod line 7: invalid ip address 'node04.local'",
+		"prod line 8: no host name after ip address '10.0.0.5'",
+	}
+	if strings.Join(output, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+
+	// broken lines are kept as they are
+	if names := strings.Join(hostsFile.GetCluster("prod").Hosts.Names(), ","); names != "node01.local" {
+		t.Errorf("Expected 'node01.local', received '%v'", names)
+	}
+}
+
+// any file parses without panicking, files without errors are written back
+// byte for byte
+func FuzzParseHostsFile(f *testing.F) {
+	f.Add(testHostsFile)
+	f.Add("### LB_K8S(prod)\r\n10.0.0.1\t\tnode01.local  # a\r\n\r\n#\n### LB_K8S_END")
+	f.Add("### LB_K8S(prod)\n10.0.0.1\n 10.0.0.x a\n### LB_K8S_END\n### LB_K8S_END\n")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		hostsFile, err := ParseHostsFile(data)
+		if err != nil {
+			return
+		}
+
+		if output := hostsFile.String(); output != data {
+			t.Errorf("Expected %q, received %q", data, output)
+		}
+
+		for _, cluster := range hostsFile.Clusters() {
+			hosts := hostsFile.GetCluster(cluster).Hosts
+			for _, host := range hosts.List() {
+				_ = hosts.MoveTraffic(host.Name, host.Name)
+				_ = hosts.RestoreTraffic(host.Name)
+			}
+		}
+		_ = hostsFile.String()
+	})
+}
+
+func TestHostsFileHostExists(t *testing.T) {
+	hostsFile, _ := ParseHostsFile(testHostsFile + "10.1.0.2\tbackup.local\talias.local\n")
+
+	tests := []struct {
+		Host     string
+		Expected bool
+	}{
+		{"localhost", true},
+		{"ip6-localhost", true},
+		{"node03.local", true},
+		{"node02.local", true},
+		{"foreign.local", true},
+		{"backup.local", true},
+		{"alias.local", true},
+		{"node0", false},
+		{"node01", false},
+		{"node01.loca", false},
+		{"rack", false},
+		{"proxymanager", false},
+		{"workers", false},
+	}
+
+	for _, test := range tests {
+		if exists := hostsFile.HostExists(test.Host); exists != test.Expected {
+			t.Errorf("'%v': Expected '%v', received '%v'", test.Host, test.Expected, exists)
+		}
+	}
+}
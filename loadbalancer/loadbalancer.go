@@ -1,626 +1,562 @@
 package loadbalancer
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"os"
-	"regexp"
 	"strings"
+	"text/tabwriter"
+	"time"
 
-	nginx "nickneal.dev/go-proxymanager/utils/nginx"
+	audit "nickneal.dev/go-proxymanager/utils/audit"
+	errs "nickneal.dev/go-proxymanager/utils/errs"
+	lock "nickneal.dev/go-proxymanager/utils/lock"
+	output "nickneal.dev/go-proxymanager/utils/output"
 	settings "nickneal.dev/go-proxymanager/utils/settings"
+	sitespec "nickneal.dev/go-proxymanager/utils/sitespec"
+	snapshot "nickneal.dev/go-proxymanager/utils/snapshot"
+	transaction "nickneal.dev/go-proxymanager/utils/transaction"
 	validate "nickneal.dev/go-proxymanager/utils/validate"
 )
 
-func ReadHostsFileLines() ([]string, error) {
-	// get location of hosts file
-	hostsFilePath := settings.LoadConfig().LoadBalancer.HostsFile
+func CreateClusterConfigDir(tx *transaction.Transaction, cluster string) {
+	tx.Mkdir(sitespec.GetConfigDir(cluster), 0755)
+}
+
+// sites configured in the cluster's config dir
+func GetClusterSites(cluster string) []string {
+	var sites []string
 
-	// open file
-	file, err := os.Open(hostsFilePath)
+	entries, err := os.ReadDir(sitespec.GetConfigDir(cluster))
 	if err != nil {
-		//fmt.Println("Error opening file:", err)
-		return []string{}, err
+		return sites
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".conf") {
+			sites = append(sites, strings.TrimSuffix(e.Name(), ".conf"))
+		}
+	}
+
+	return sites
+}
+
+func GetEnabledConfigPath(hostname string) string {
+	return settings.LoadConfig().Proxy.NginxDir + "/sites-enabled/" + hostname + ".conf"
+}
+
+// stage removal of the cluster's config dir. with backup, its sites are
+// disabled and moved with their specs into a timestamped dir under
+// loadBalancer.backupDir, which is returned. without backup only a dir
+// without sites is removed.
+func RemoveClusterConfigDir(tx *transaction.Transaction, cluster string, backup bool) (string, error) {
+	configDir := sitespec.GetConfigDir(cluster)
+	sites := GetClusterSites(cluster)
+
+	if !backup {
+		if len(sites) > 0 {
+			return "", errors.New("loadbalancer(RemoveClusterConfigDir): cluster '" + cluster + "' still has sites")
+		}
+
+		tx.Remove(configDir)
+		return "", nil
 	}
-	defer file.Close()
 
-	// read file lines to string array
-	var fileLines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		fileLines = append(fileLines, scanner.Text())
+	backupPath := settings.LoadConfig().LoadBalancer.BackupDir + "/k8s_" + cluster + "-" + time.Now().Format("20060102150405")
+	tx.Mkdir(backupPath+"/specs", 0755)
+
+	// remember enabled sites so the archive can be restored
+	var enabledSites []string
+	for _, hostname := range sites {
+		enabledPath := GetEnabledConfigPath(hostname)
+		if _, linkErr := os.Lstat(enabledPath); linkErr == nil {
+			enabledSites = append(enabledSites, hostname)
+			tx.Remove(enabledPath)
+		}
 	}
+	tx.WriteLines(backupPath+"/enabled", enabledSites, 0644)
 
-	if err := scanner.Err(); err != nil {
-		//fmt.Println("Error reading file:", err)
-		return []string{}, err
+	tx.Rename(configDir, backupPath+"/k8s_"+cluster)
+
+	for _, hostname := range sites {
+		if sitespec.Exists(hostname) {
+			tx.Rename(sitespec.GetSpecPath(hostname), backupPath+"/specs/"+hostname+".yml")
+		}
 	}
-	// close file
-	file.Close()
-	return fileLines, nil
+
+	return backupPath, nil
 }
 
-func WriteHostsFileLines(fileLines []string) error {
-	// get location of hosts file
-	hostsFilePath := settings.LoadConfig().LoadBalancer.HostsFile
+// stage re-rendered sites of cluster for its current nodes
+func RenderClusterSites(tx *transaction.Transaction, cluster string, hosts Hosts) error {
+	configs, err := sitespec.RenderCluster(cluster, hosts.Names())
+	if err != nil {
+		return fmt.Errorf("There was an issue updating site upstreams: %w", err)
+	}
 
-	file, err := os.OpenFile(hostsFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	for configPath, configLines := range configs {
+		tx.WriteLines(configPath, configLines, 0644)
+	}
+
+	return nil
+}
+
+// apply staged changes, everything is rolled back if a change fails or
+// nginx rejects the result. the outcome is written to the audit log. with
+// --dry-run the plan is printed and transaction.ErrDryRun returned.
+func Commit(tx *transaction.Transaction, action transaction.NginxAction) error {
+	planned, err := tx.Diff()
 	if err != nil {
-		return err
+		return fmt.Errorf("There was an issue planning changes: %w", err)
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	for _, str := range fileLines {
-		_, err = writer.WriteString(str + "\n")
-		if err != nil {
-			//fmt.Println("Error writing to file:", err)
-			return err
+	// with --dry-run only show what would change
+	if transaction.DryRun() {
+		if planned == "" {
+			fmt.Println("No files would change.")
+		} else {
+			fmt.Print(planned)
 		}
+		if action == transaction.NginxReload {
+			fmt.Println("Nginx would be reloaded.")
+		}
+		fmt.Println("Dry run, nothing was changed.")
+		return transaction.ErrDryRun
+	}
+
+	commandLine := strings.Join(os.Args[1:], " ")
+
+	// keep the current state, see 'backup restore'
+	if !tx.Empty() {
+		_, snapshotErr := snapshot.Create(commandLine)
+		if snapshotErr != nil {
+			return fmt.Errorf("There was an issue creating a backup: %w", snapshotErr)
+		}
+	}
+
+	entry := audit.Entry{
+		Time:     time.Now(),
+		User:     lock.GetUser(),
+		Command:  commandLine,
+		Clusters: tx.Clusters,
+		Hosts:    tx.Hosts,
+		Outcome:  audit.OutcomeApplied,
+		Diff:     planned,
 	}
 
-	err = writer.Flush()
+	err = tx.Commit(action)
 	if err != nil {
-		//fmt.Println("Error flushing writer:", err)
-		return err
+		entry.Outcome = audit.OutcomeRolledBack
+		if errors.Is(err, transaction.ErrRollbackFailed) {
+			entry.Outcome = audit.OutcomeFailed
+		}
+		entry.Error = err.Error()
+	}
+
+	if !tx.Empty() {
+		auditErr := audit.Append(entry)
+		if auditErr != nil {
+			fmt.Println("There was an issue writing the audit log:", auditErr)
+		}
+	}
+
+	if err != nil {
+		message := "There was an issue applying changes: " + err.Error()
+		if entry.Outcome == audit.OutcomeFailed {
+			return &errs.Error{Message: message + "\nSome changes could not be rolled back, check the files above.", Err: err}
+		}
+		return &errs.Error{Kind: errs.ErrRolledBack, Message: message + "\nChanges were rolled back.", Err: err}
 	}
 
 	return nil
 }
 
-func CreateClusterConfigDir(cluster string) error {
-	return nil
+// write changed cluster and its re-rendered sites, then reload nginx.
+// changedHosts are the nodes the command was about.
+func applyCluster(hostsFile *HostsFile, cluster string, hosts Hosts, changedHosts ...string) error {
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(changedHosts...)
+	tx.WriteFile(GetHostsFilePath(), []byte(hostsFile.String()), 0644)
+
+	// point site upstreams at the new node list
+	if err := RenderClusterSites(tx, cluster, hosts); err != nil {
+		return err
+	}
+
+	return Commit(tx, transaction.NginxReload)
 }
 
-func RemoveClusterConfigDir(cluster string, backup bool) error {
-	return nil
+// schema of 'lb list --output json|yaml'
+type Cluster struct {
+	Name string `json:"name" yaml:"name"`
+	// sorted host names
+	Nodes []string `json:"nodes" yaml:"nodes"`
+	Sites []string `json:"sites" yaml:"sites"`
 }
 
-func RestartNginx(fileLinesBackup []string) bool {
-	// restart nginx, if error, restore hosts file.
-	if !settings.CheckDevMode() {
-		nginxerr := nginx.RestartNginx()
-		if nginxerr != nil {
-			fmt.Println("Nginx issue: ", nginxerr)
-			fmt.Println("Restoring hosts file...")
-
-			// write changes
-			err := WriteHostsFileLines(fileLinesBackup)
-			if err != nil {
-				fmt.Println("There was an issue restoring file:", err)
-				return false
-			}
-
-			fmt.Println("Hosts file successfully restored!")
-			return false
+// schema of 'lb <cluster> status --output json|yaml'
+type Node struct {
+	Host      string `json:"host" yaml:"host"`
+	IpAddress string `json:"ip" yaml:"ip"`
+	Enabled   bool   `json:"enabled" yaml:"enabled"`
+	// hosts moved onto this node, see Move
+	CarryingTrafficFor []string `json:"carryingTrafficFor" yaml:"carryingTrafficFor"`
+}
+
+func GetClusters() ([]Cluster, error) {
+	hostsFile, err := ReadHostsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := []Cluster{}
+	for _, name := range hostsFile.Clusters() {
+		cluster := Cluster{Name: name, Nodes: []string{}, Sites: []string{}}
+		if block := hostsFile.GetCluster(name); block != nil {
+			cluster.Nodes = append(cluster.Nodes, block.Hosts.Names()...)
 		}
+		cluster.Sites = append(cluster.Sites, GetClusterSites(name)...)
+		clusters = append(clusters, cluster)
 	}
 
-	return true
+	return clusters, nil
 }
 
-func List() {
-	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
-	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
+// nodes of cluster in hosts file order, nil when cluster doesn't exist
+func GetNodes(cluster string) ([]Node, error) {
+	hostsFile, err := ReadHostsFile()
+	if err != nil {
+		return nil, err
 	}
 
-	pattern1 := "^### LB_K8S\\("
-	var clusters []string
-	for _, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			cluster := strings.Split(strings.Split(line, "(")[1], ")")[0]
-			clusters = append(clusters, []string{cluster}...)
-		}
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return nil, nil
 	}
 
-	if len(clusters) > 0 {
-		//fmt.Println("Clusters")
+	nodes := []Node{}
+	for _, host := range block.Hosts.List() {
+		nodes = append(nodes, Node{
+			Host:               host.Name,
+			IpAddress:          host.IpAddress,
+			Enabled:            host.Enabled,
+			CarryingTrafficFor: append([]string{}, host.AdditionalHosts...),
+		})
+	}
+
+	return nodes, nil
+}
+
+func List() error {
+	clusters, err := GetClusters()
+	if err != nil {
+		return fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	output.Print(clusters, func() {
+		if len(clusters) == 0 {
+			fmt.Println("No clusters exist.")
+			return
+		}
+
 		for _, cluster := range clusters {
-			fmt.Println(cluster)
+			fmt.Println(cluster.Name)
 		}
-	} else {
-		fmt.Println("No clusters exist.")
-	}
+	})
 
+	return nil
 }
 
-func New(cluster string) {
+func New(cluster string) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 	if !validate.ValidateClusterName(cluster) {
-		fmt.Println("Invalid cluster name:", cluster)
-		return
+		return errs.New(errs.ErrInvalidArgument, "Invalid cluster name: %v", cluster)
 	}
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
-	}
-
-	pattern1 := "^### LB_K8S\\("
-	pattern2 := "^### LB_K8S_END"
-	var lastOccurence int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			if cluster == strings.Split(strings.Split(line, "(")[1], ")")[0] {
-				formattedString := fmt.Sprintf("Cluster '%v' already exists.", cluster)
-				fmt.Println(formattedString)
-				return
-			}
-		}
+		return fmt.Errorf("There was an issue opening/reading file: %w", readErr)
+	}
 
-		// grab last index of cluster definition
-		if regexp.MustCompile(pattern2).MatchString(line) {
-			lastOccurence = index
-		}
+	// check if cluster exists
+	if hostsFile.GetCluster(cluster) != nil {
+		return errs.New(errs.ErrClusterExists, "Cluster '%v' already exists.", cluster)
 	}
 
 	// add new cluster
-	newLines := []string{"", "# DO NOT EDIT, USE proxymanager", "### LB_K8S(" + cluster + ")", "### LB_K8S_END"}
-	var newFileContent []string
-	if lastOccurence != 0 && lastOccurence != (len(fileLines)-1) {
-		newFileContent = append(fileLines[:lastOccurence+1], append(newLines, fileLines[lastOccurence+1:]...)...)
-	} else {
-		newFileContent = append(fileLines, newLines...)
-	}
+	_, _ = hostsFile.AddCluster(cluster)
+
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.WriteFile(GetHostsFilePath(), []byte(hostsFile.String()), 0644)
 
-	// rewrite hosts file
-	writeErr := WriteHostsFileLines(newFileContent)
-	if writeErr != nil {
-		fmt.Println("There was an issue writing to file:", writeErr)
+	// sites of the cluster are created in its config dir
+	CreateClusterConfigDir(tx, cluster)
+
+	if err := Commit(tx, transaction.NginxSkip); err != nil {
+		return err
 	}
 
 	formattedString := fmt.Sprintf("Cluster '%v' created.", cluster)
 	fmt.Println(formattedString)
+
+	return nil
 }
 
-func Remove(cluster string) {
+func Remove(cluster string, backup bool) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
+		return fmt.Errorf("There was an issue opening/reading file: %w", readErr)
 	}
 
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
-	// backup filelines
-	fileLinesBackup := make([]string, len(fileLines))
-	_ = copy(fileLinesBackup, fileLines)
+	if block.Hosts.Len() > 0 {
+		return errs.New(errs.ErrConflict, "Node count is higher than 0 on cluster '%v'. Remove canceled.", cluster)
+	}
+	if len(GetClusterSites(cluster)) > 0 && !backup {
+		return errs.New(errs.ErrConflict, "Cluster '%v' still has sites. Remove them or use '--backup' to archive them. Remove canceled.", cluster)
+	}
 
 	// remove cluster
-	if startLine != 0 && endLine != 0 {
-		if GetClusterNodeCount(cluster) > 0 {
-			fmt.Printf("Node count is higher than 0 on cluster '%v'. Remove canceled.\n", cluster)
-			return
-		}
-		fileLines = append(fileLines[:startLine-2], fileLines[endLine+1:]...)
-	} else {
-		// exit since cluster doesn't exist
-		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
-		fmt.Println(formattedString)
-		return
-	}
+	_ = hostsFile.RemoveCluster(cluster)
 
-	// write changes
-	err := WriteHostsFileLines(fileLines)
-	if err != nil {
-		fmt.Println("There was an issue writing file:", err)
-		return
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.WriteFile(GetHostsFilePath(), []byte(hostsFile.String()), 0644)
+
+	backupPath, dirErr := RemoveClusterConfigDir(tx, cluster, backup)
+	if dirErr != nil {
+		return fmt.Errorf("There was an issue removing the cluster config dir: %w", dirErr)
 	}
 
-	// restart nginx, if error, restore hosts file.
-	if !RestartNginx(fileLinesBackup) {
-		return
+	// restart nginx, if error, restore hosts file and config dir.
+	if err := Commit(tx, transaction.NginxReload); err != nil {
+		return err
 	}
 
 	formattedString := fmt.Sprintf("Cluster '%v' removed.", cluster)
 	fmt.Println(formattedString)
+	if backupPath != "" {
+		fmt.Printf("Sites of cluster '%v' archived to '%v'.\n", cluster, backupPath)
+	}
+
+	return nil
 }
 
-func Add(cluster string, ipAddress string, host string) {
+func Add(cluster string, ipAddress string, host string) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 
 	// validate ip address
 	if !validate.ValidateIPAddress(ipAddress) {
-		fmt.Println("Invalid IP Address format. must be value between 0.0.0.0 - 255.255.255.255.")
-		return
+		return errs.New(errs.ErrInvalidArgument, "Invalid IP Address format. must be value between 0.0.0.0 - 255.255.255.255.")
 	}
 
 	// make hostname lowercase and validate
 	host = strings.ToLower(host)
 	if !validate.ValidateHostName(host) {
-		fmt.Println("Invalid Hostname format. Can only contain lowercase letters, numbers, hypens, and periods.")
-		return
+		return errs.New(errs.ErrInvalidArgument, "Invalid Hostname format. Can only contain lowercase letters, numbers, hypens, and periods.")
 	}
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
+		return fmt.Errorf("There was an issue opening/reading file: %w", readErr)
 	}
 
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
-	}
-
-	if startLine == 0 {
-		// exit since cluster doesn't exist
-		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
-		fmt.Println(formattedString)
-		return
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
 	// check whole hosts file for hostname
-	for _, str := range fileLines {
-		if regexp.MustCompile(host + ".?( |$)").MatchString(str) {
-			formattedString := fmt.Sprintf("Host '%v' exists in hosts file.", host)
-			fmt.Println(formattedString)
-			return
-		}
+	if hostsFile.HostExists(host) {
+		return errs.New(errs.ErrHostExists, "Host '%v' exists in hosts file.", host)
 	}
 
-	hosts := NewHostConfig(fileLines[startLine:endLine])
 	// check IP Address
-	if hosts.IPExists(ipAddress) {
-		formattedString := fmt.Sprintf("IP Address '%v' already exists in cluster '%v'.", ipAddress, cluster)
-		fmt.Println(formattedString)
-		return
+	if block.Hosts.IPExists(ipAddress) {
+		return errs.New(errs.ErrHostExists, "IP Address '%v' already exists in cluster '%v'.", ipAddress, cluster)
 	}
 
-	hosts.AddHost(host, ipAddress)
-	fileLinesBackup := make([]string, len(fileLines))
-	_ = copy(fileLinesBackup, fileLines)
-	fileLines = append(fileLines[:startLine], append(hosts.ToArray(), fileLines[endLine:]...)...)
+	block.Hosts.AddHost(host, ipAddress)
 
-	//fmt.Println(fileLines[:startLine])
-	//fmt.Println(fileLines[endLine:])
-
-	// write changes
-	err := WriteHostsFileLines(fileLines)
-	if err != nil {
-		fmt.Println("There was an issue writing file:", err)
-		return
-	}
-
-	// restart nginx, if error, restore hosts file.
-	if !RestartNginx(fileLinesBackup) {
-		return
+	if err := applyCluster(hostsFile, cluster, block.Hosts, host); err != nil {
+		return err
 	}
 
 	formattedString := fmt.Sprintf("Host '%v' added to cluster '%v'.", host, cluster)
 	fmt.Println(formattedString)
+
+	return nil
 }
 
-func Del(cluster string, host string) {
+func Del(cluster string, host string) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 	host = strings.ToLower(host)
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
-	}
-
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
-	}
-
-	if startLine == 0 {
-		// exit since cluster doesn't exist
-		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
-		fmt.Println(formattedString)
-		return
+		return fmt.Errorf("There was an issue opening/reading file: %w", readErr)
 	}
 
-	hosts := NewHostConfig(fileLines[startLine:endLine])
-	if !hosts.HostExists(host) {
-		formattedString := fmt.Sprintf("Host '%v' does not exist in cluster '%v'.", host, cluster)
-		fmt.Println(formattedString)
-		return
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
-	hosts.DelHost(host)
-	fileLinesBackup := make([]string, len(fileLines))
-	_ = copy(fileLinesBackup, fileLines)
-
-	if len(hosts) == 0 {
-		fileLines = append(fileLines[:startLine], fileLines[endLine:]...)
-	} else {
-		fileLines = append(fileLines[:startLine], append(hosts.ToArray(), fileLines[endLine:]...)...)
+	if !block.Hosts.HostExists(host) {
+		return errs.New(errs.ErrHostNotFound, "Host '%v' does not exist in cluster '%v'.", host, cluster)
 	}
 
-	// write changes
-	err := WriteHostsFileLines(fileLines)
-	if err != nil {
-		fmt.Println("There was an issue writing file:", err)
-		return
-	}
+	block.Hosts.DelHost(host)
 
-	// restart nginx, if error, restore hosts file.
-	if !RestartNginx(fileLinesBackup) {
-		return
+	if err := applyCluster(hostsFile, cluster, block.Hosts, host); err != nil {
+		return err
 	}
 
 	formattedString := fmt.Sprintf("Host '%v' removed from cluster '%v'.", host, cluster)
 	fmt.Println(formattedString)
+
+	return nil
 }
 
-func Status(cluster string) {
+func Status(cluster string) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 
-	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
-	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
+	nodes, err := GetNodes(cluster)
+	if err != nil {
+		return fmt.Errorf("There was an issue opening/reading file: %w", err)
 	}
 
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
+	if nodes == nil {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
-	if startLine == 0 {
-		// exit since cluster doesn't exist
-		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
-		fmt.Println(formattedString)
-		return
-	}
+	output.Print(nodes, func() {
+		if len(nodes) == 0 {
+			formattedString := fmt.Sprintf("No hosts defined in cluster '%v'.", cluster)
+			fmt.Println(formattedString)
+			return
+		}
 
-	if startLine != endLine {
-		hosts := NewHostConfig(fileLines[startLine:endLine])
-		hosts.PrintHosts()
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintln(w, "Host\tIP Address\tEnabled\tAdditional Hosts")
+		for _, n := range nodes {
+			formattedString := fmt.Sprintf("%v\t%v\t%v\t%v", n.Host, n.IpAddress, n.Enabled, strings.Join(n.CarryingTrafficFor, ","))
+			fmt.Fprintln(w, formattedString)
+		}
+		w.Flush()
+	})
 
-	} else {
-		formattedString := fmt.Sprintf("No hosts defined in cluster '%v'.", cluster)
-		fmt.Println(formattedString)
-	}
+	return nil
 }
 
-func Move(cluster string, fromHost string, toHost string) {
+func Move(cluster string, fromHost string, toHost string) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 	fromHost = strings.ToLower(fromHost)
 	toHost = strings.ToLower(toHost)
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
+		return fmt.Errorf("There was an issue opening/reading file: %w", readErr)
 	}
 
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
-	if startLine == 0 {
-		// exit since cluster doesn't exist
-		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
-		fmt.Println(formattedString)
-		return
-	}
-
-	hosts := NewHostConfig(fileLines[startLine:endLine])
 	// check fromHost
-	if !hosts.HostExists(fromHost) {
-		formattedString := fmt.Sprintf("Host '%v' does not exist in cluster '%v'.", fromHost, cluster)
-		fmt.Println(formattedString)
-		return
+	if !block.Hosts.HostExists(fromHost) {
+		return errs.New(errs.ErrHostNotFound, "Host '%v' does not exist in cluster '%v'.", fromHost, cluster)
 	}
 
 	// check toHost
-	if !hosts.HostExists(toHost) {
-		formattedString := fmt.Sprintf("Host '%v' does not exist in cluster '%v'.", toHost, cluster)
-		fmt.Println(formattedString)
-		return
+	if !block.Hosts.HostExists(toHost) {
+		return errs.New(errs.ErrHostNotFound, "Host '%v' does not exist in cluster '%v'.", toHost, cluster)
 	}
 
-	hosterr := hosts.MoveTraffic(fromHost, toHost)
+	hosterr := block.Hosts.MoveTraffic(fromHost, toHost)
 	if hosterr != nil {
-		fmt.Println("There was an issue moving traffic:", hosterr)
-		return
-	}
-	fileLinesBackup := make([]string, len(fileLines))
-	_ = copy(fileLinesBackup, fileLines)
-	fileLines = append(fileLines[:startLine], append(hosts.ToArray(), fileLines[endLine:]...)...)
-
-	//fmt.Println(fileLines[:startLine])
-	//fmt.Println(fileLines[endLine:])
-
-	// write changes
-	err := WriteHostsFileLines(fileLines)
-	if err != nil {
-		fmt.Println("There was an issue writing file:", err)
-		return
+		return errs.Wrap(errs.ErrConflict, hosterr, "There was an issue moving traffic")
 	}
 
-	// restart nginx, if error, restore hosts file.
-	if !RestartNginx(fileLinesBackup) {
-		return
+	if err := applyCluster(hostsFile, cluster, block.Hosts, fromHost, toHost); err != nil {
+		return err
 	}
 
 	formattedString := fmt.Sprintf("Traffic moved from '%v' to '%v' in cluster '%v'.", fromHost, toHost, cluster)
 	fmt.Println(formattedString)
+
+	return nil
 }
 
-func Restore(cluster string, host string) {
+func Restore(cluster string, host string) error {
 	// make cluster name lowercase
 	cluster = strings.ToLower(cluster)
 	host = strings.ToLower(host)
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
-		fmt.Println("There was an issue opening/reading file:", readErr)
-		return
-	}
-
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
+		return fmt.Errorf("There was an issue opening/reading file: %w", readErr)
 	}
 
-	if startLine == 0 {
-		// exit since cluster doesn't exist
-		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
-		fmt.Println(formattedString)
-		return
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
-	hosts := NewHostConfig(fileLines[startLine:endLine])
-	if !hosts.HostExists(host) {
-		formattedString := fmt.Sprintf("Host '%v' does not exist in cluster '%v'.", host, cluster)
-		fmt.Println(formattedString)
-		return
+	if !block.Hosts.HostExists(host) {
+		return errs.New(errs.ErrHostNotFound, "Host '%v' does not exist in cluster '%v'.", host, cluster)
 	}
 
-	restoreErr := hosts.RestoreTraffic(host)
+	restoreErr := block.Hosts.RestoreTraffic(host)
 	if restoreErr != nil {
-		fmt.Println("There was an issue restoring traffic:", restoreErr)
-		return
+		return errs.Wrap(errs.ErrConflict, restoreErr, "There was an issue restoring traffic")
 	}
 
-	fileLinesBackup := make([]string, len(fileLines))
-	_ = copy(fileLinesBackup, fileLines)
-	fileLines = append(fileLines[:startLine], append(hosts.ToArray(), fileLines[endLine:]...)...)
-
-	// write changes
-	err := WriteHostsFileLines(fileLines)
-	if err != nil {
-		fmt.Println("There was an issue writing file:", err)
-		return
-	}
-
-	// restart nginx, if error, restore hosts file.
-	if !RestartNginx(fileLinesBackup) {
-		return
+	if err := applyCluster(hostsFile, cluster, block.Hosts, host); err != nil {
+		return err
 	}
 
 	formattedString := fmt.Sprintf("Traffic restored for '%v' in cluster '%v'.", host, cluster)
 	fmt.Println(formattedString)
+
+	return nil
 }
 
 func GetClusterNodeCount(cluster string) int {
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
 		fmt.Println("There was an issue opening/reading file:", readErr)
 		return 0
 	}
 
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			// add cluster to map
-			return index - startLine
-		}
+	block := hostsFile.GetCluster(cluster)
+	if block == nil {
+		return 0
 	}
 
-	return 0
+	return block.Hosts.Len()
 }
 
 func GetClusterNodes(cluster string) []string {
@@ -628,41 +564,19 @@ func GetClusterNodes(cluster string) []string {
 	cluster = strings.ToLower(cluster)
 
 	// read hosts file
-	fileLines, readErr := ReadHostsFileLines()
+	hostsFile, readErr := ReadHostsFile()
 	if readErr != nil {
 		fmt.Println("There was an issue opening/reading file:", readErr)
 		return nil
 	}
 
-	pattern1 := "^### LB_K8S\\(" + cluster + "\\)"
-	pattern2 := "^### LB_K8S_END"
-	var startLine int
-	var endLine int
-	for index, line := range fileLines {
-		// check if cluster exists
-		if regexp.MustCompile(pattern1).MatchString(line) {
-			startLine = index + 1
-		}
-
-		// grab cluster block end
-		if startLine != 0 && regexp.MustCompile(pattern2).MatchString(line) {
-			endLine = index
-			break
-		}
-	}
-
-	if startLine == 0 || startLine == endLine {
+	block := hostsFile.GetCluster(cluster)
+	if block == nil || block.Hosts.Len() == 0 {
 		// exit since cluster doesn't exist
 		formattedString := fmt.Sprintf("Cluster '%v' does not exist.", cluster)
 		fmt.Println(formattedString)
 		return nil
 	}
 
-	hosts := NewHostConfig(fileLines[startLine:endLine])
-	var hostList []string
-	for k, _ := range hosts {
-		hostList = append(hostList, []string{k}...)
-	}
-
-	return hostList
+	return block.Hosts.Names()
 }
@@ -1,215 +1,147 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
-	"regexp"
 	"strings"
+	"time"
 
-	"nickneal.dev/go-proxymanager/loadbalancer"
-	"nickneal.dev/go-proxymanager/proxy"
+	"github.com/spf13/cobra"
+
+	auditlog "nickneal.dev/go-proxymanager/utils/audit"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/lock"
+	"nickneal.dev/go-proxymanager/utils/output"
 	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+// exit codes, scripts can tell failures apart without parsing messages
+const (
+	exitError = 1
+	// parser errors and invalid ip addresses, hostnames, ports, ...
+	exitUsage = 2
+	// cluster, host, site, backup or template doesn't exist
+	exitNotFound = 3
+	// cluster, host or site exists already
+	exitExists = 4
+	// the current state doesn't allow the change
+	exitConflict = 5
+	// nginx -t rejected the change, it was rolled back
+	exitNginxValidation = 6
+	// applying the change or reloading nginx failed, it was rolled back
+	exitRolledBack = 7
+	// the change failed and could not be fully rolled back
+	exitRollbackFailed = 8
+	// another proxymanager is running
+	exitLocked = 9
 )
 
-type Command struct {
-	name     string
-	function string
-	data     map[string]string
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, transaction.ErrRollbackFailed):
+		return exitRollbackFailed
+	case errors.Is(err, errs.ErrNginxValidation):
+		return exitNginxValidation
+	case errors.Is(err, errs.ErrRolledBack):
+		return exitRolledBack
+	case errors.Is(err, lock.ErrLocked):
+		return exitLocked
+	case errors.Is(err, errs.ErrInvalidArgument):
+		return exitUsage
+	case errors.Is(err, errs.ErrClusterNotFound), errors.Is(err, errs.ErrHostNotFound),
+		errors.Is(err, errs.ErrSiteNotFound), errors.Is(err, errs.ErrBackupNotFound), errors.Is(err, errs.ErrTemplateNotFound):
+		return exitNotFound
+	case errors.Is(err, errs.ErrClusterExists), errors.Is(err, errs.ErrHostExists), errors.Is(err, errs.ErrSiteExists):
+		return exitExists
+	case errors.Is(err, errs.ErrConflict):
+		return exitConflict
+	}
+
+	return exitError
 }
 
-func parseArgs() Command {
-	// collect arguments from command
-	args := os.Args[1:]
-
-	// insitalize command
-	var command Command
-	command.data = make(map[string]string)
-
-	if len(args) >= 1 {
-		switch args[0] {
-		case "lb": // load balancer module
-			command.name = args[0] // set command name
-			// check if args are at least 3 in length to extract subcommand
-
-			if len(args) >= 2 && args[1] == "list" {
-				command.function = "list"
-				return command
-			}
-
-			if len(args) >= 3 {
-				if args[1] == "new" || args[1] == "remove" {
-					command.function = args[1]
-					command.data["cluster"] = args[2]
-					return command
-				}
-
-				command.function = args[2] // set function name
-				switch command.function {
-				case "add", "move":
-					// error out if all data not present
-					if len(args) < 5 {
-						fmt.Printf("parser: not enough args supplied for %v %v\n", command.name, command.function)
-						printCommandHelp(command.name)
-						os.Exit(1)
-					}
-					command.data["cluster"] = args[1]
-					if command.function == "add" {
-						command.data["host"] = args[3]
-						command.data["ip"] = args[4]
-					} else {
-						command.data["from"] = args[3]
-						command.data["to"] = args[4]
-					}
-				case "del", "restore":
-					// error out if all data not present
-					if len(args) < 4 {
-						fmt.Printf("parser: not enough args supplied for %v %v\n", command.name, command.function)
-						printCommandHelp(command.name)
-						os.Exit(1)
-					}
-					command.data["cluster"] = args[1]
-					command.data["host"] = args[3]
-				case "status":
-					command.data["cluster"] = args[1]
-				default:
-					printCommandHelp(command.name)
-					os.Exit(0)
-				}
-
-				return command
-			} else {
-				printCommandHelp(command.name)
-				os.Exit(0)
-			}
-		case "proxy": // proxy module
-			command.name = args[0] // set command name
-			if len(args) >= 2 {
-				switch args[1] {
-				case "list":
-					command.function = args[1]
-					// check for optional args
-					if len(args) > 2 {
-						loopArgs := args[2:]
-						for index, str := range loopArgs {
-							switch str {
-							case "--k8s":
-								key := strings.Replace(str, "--", "", 1)
-								// make sure lookahead isn't out of array bounds
-								var value string
-								if (index + 1) < len(loopArgs) {
-									value = loopArgs[index+1]
-								} else {
-									continue
-								}
-								// make sure next value isn't another param
-								if !regexp.MustCompile("^--.*").MatchString(value) {
-									command.data[key] = value
-								}
-							}
-						}
-					}
-				case "enable", "disable", "remove":
-					command.function = args[1]
-					if len(args) < 3 {
-						fmt.Printf("parser: not enough args supplied for %v %v\n", command.name, command.function)
-						printCommandHelp(command.name)
-						os.Exit(1)
-					}
-
-					command.data["hostname"] = args[2]
-
-					// check for optional args
-					if len(args) > 3 {
-						loopArgs := args[3:]
-						for index, str := range loopArgs {
-							switch str {
-							case "--k8s":
-								key := strings.Replace(str, "--", "", 1)
-								// make sure lookahead isn't out of array bounds
-								var value string
-								if (index + 1) < len(loopArgs) {
-									value = loopArgs[index+1]
-								} else {
-									continue
-								}
-								// make sure next value isn't another param
-								if !regexp.MustCompile("^--.*").MatchString(value) {
-									command.data[key] = value
-								}
-							}
-						}
-					}
-				case "new":
-					command.function = args[1]
-					if len(args) < 4 {
-						fmt.Printf("parser: not enough args supplied for %v %v\n", command.name, command.function)
-						printCommandHelp(command.name)
-						os.Exit(1)
-					}
-
-					command.data["hostname"] = args[2]
-
-					loopArgs := args[3:]
-					for index, str := range loopArgs {
-						switch str {
-						case "--ip", "--port", "--k8s", "--proxy-uri":
-							key := strings.Replace(str, "--", "", 1)
-							// make sure lookahead isn't out of array bounds
-							var value string
-							if (index + 1) < len(loopArgs) {
-								value = loopArgs[index+1]
-							} else {
-								continue
-							}
-							// make sure next value isn't another param
-							if !regexp.MustCompile("^--.*").MatchString(value) {
-								command.data[key] = value
-							}
-						case "--ssl", "--ssl-bypass-firewall", "--proxy-ssl", "--proxy-ssl-verify-off":
-							key := strings.Replace(str, "--", "", 1)
-							command.data[key] = "true"
-						}
-					}
-
-					if (command.data["ip"] == "" && command.data["k8s"] == "") || (command.data["ip"] != "" && command.data["k8s"] != "") {
-						fmt.Println("parser: must either specify '--ip' or '--k8s'")
-						printCommandHelp(command.name)
-						os.Exit(1)
-					}
-
-					// require port if k8s
-					if command.data["k8s"] != "" && command.data["port"] == "" {
-						fmt.Println("parser: must specify '--port' if '--k8s' is specified")
-						printCommandHelp(command.name)
-						os.Exit(1)
-					}
-
-				default:
-					printCommandHelp(command.name)
-					os.Exit(0)
-				}
-
-				return command
-			} else {
-				printCommandHelp(command.name)
-				os.Exit(0)
-			}
-
-			printCommandHelp("proxy")
-		case "fw":
-			printCommandHelp("fw")
-		default:
-			printHelp()
-		}
+// annotation of commands that change files, they hold the lock and take
+// --wait. "true", or the name of the flag that makes a run change files.
+const mutating = "mutating"
+
+var (
+	// set once flags and args were accepted, errors before that are usage
+	// errors
+	parsed bool
+	// released before exiting, deferred calls don't run on os.Exit
+	held *lock.Lock
+)
+
+// --output value, json, yaml or table
+type formatValue struct{}
+
+func (f formatValue) String() string {
+	return string(output.GetFormat())
+}
+
+func (f formatValue) Set(value string) error {
+	if err := output.SetFormat(value); err != nil {
+		return fmt.Errorf("unknown format '%v', use json, yaml or table", value)
+	}
+	return nil
+}
+
+func (f formatValue) Type() string {
+	return "format"
+}
+
+// --since value, a duration, days or a date, see auditlog.ParseSince
+type sinceValue struct {
+	since *time.Time
+	value string
+}
+
+func (s *sinceValue) String() string {
+	return s.value
+}
 
-	} else {
-		fmt.Println("parser: no arguments supplied.")
-		printHelp()
-		os.Exit(1)
+func (s *sinceValue) Set(value string) error {
+	since, err := auditlog.ParseSince(value, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid time '%v', use a duration, days or a date, e.g. 24h, 7d or 2024-05-01", value)
 	}
+	*s.since = since
+	s.value = value
+	return nil
+}
 
-	return command
+func (s *sinceValue) Type() string {
+	return "duration|date"
+}
+
+// --wait for commands that change files, plus --dry-run when the changes
+// are staged in a transaction and can be planned
+func addMutatingFlags(cmd *cobra.Command, dryRun bool) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[mutating] = "true"
+
+	cmd.Flags().Duration("wait", 0, "wait up to duration for another running proxymanager, e.g. 30s")
+	if dryRun {
+		cmd.Flags().Bool("dry-run", false, "print a diff of the files that would change, without writing or reloading")
+	}
+}
+
+// e.g. 'check' only changes files with --fix
+func isMutating(cmd *cobra.Command) bool {
+	switch value := cmd.Annotations[mutating]; value {
+	case "", "false":
+		return false
+	case "true":
+		return true
+	default:
+		set, _ := cmd.Flags().GetBool(value)
+		return set
+	}
 }
 
 func isRootUser() bool {
@@ -220,101 +152,118 @@ func isRootUser() bool {
 	return currentUser.Username == "root"
 }
 
-func printHelp() {
-	fmt.Printf("Usage: %v COMMAND { OPTIONS | help }\n", os.Args[0])
-	fmt.Println()
-	fmt.Println("Commands:")
-	fmt.Printf("\t%v lb    - manages loadbalancing for clusters defined in /etc/hosts.\n", os.Args[0])
-	fmt.Printf("\t%v proxy - manages proxy configs used by nginx.\n", os.Args[0])
+// runs after flags and args were validated, before the command
+func preRun(cmd *cobra.Command, args []string) error {
+	// cobra checks --ip xor --k8s and similar groups after this, too late
+	// to hold the lock or tell usage errors apart
+	if err := cmd.ValidateFlagGroups(); err != nil {
+		return err
+	}
+	parsed = true
+
+	// check if root user except if devmode is enabled
+	if !isRootUser() && !settings.CheckDevMode() {
+		return fmt.Errorf("error: '%v' must be run as root user.", cmd.Root().Name())
+	}
 
+	if !isMutating(cmd) {
+		return nil
+	}
+
+	// show planned file changes instead of applying them
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		transaction.SetDryRun(true)
+		return nil
+	}
+
+	// one mutating run at a time
+	wait, _ := cmd.Flags().GetDuration("wait")
+	lockFile, err := lock.Acquire(settings.LoadConfig().LockFile, wait)
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	held = lockFile
+
+	return nil
 }
 
-func printCommandHelp(command string) {
-	switch command {
-	case "lb":
-		fmt.Printf("Usage: %v %v { list | ( new | remove ) <cluster> | <cluster> ( add | del | move | restore | status ) ARGS... }\n\n", os.Args[0], command)
-	case "proxy":
-		fmt.Printf("Usage: %v %v { list ARGS... | ( new | remove | enable | disable ) <hostname> ARGS... }\n\n", os.Args[0], command)
-	default:
-		printHelp()
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "proxymanager",
+		Short: "manages reverse proxies on an nginx server and load balancing for on premise kubernetes clusters",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Help()
+			return errs.New(errs.ErrInvalidArgument, "parser: no arguments supplied.")
+		},
+		PersistentPreRunE: preRun,
+		SilenceErrors:     true,
+		SilenceUsage:      true,
 	}
+	root.CompletionOptions.DisableDefaultCmd = true
+
+	// commands that print data, e.g. list and status, honour it
+	root.PersistentFlags().VarP(formatValue{}, "output", "o", "print as json, yaml or table")
+
+	root.AddCommand(
+		newLbCommand(),
+		newProxyCommand(),
+		newSslCommand(),
+		newFwCommand(),
+		newBackupCommand(),
+		newAuditCommand(),
+		newApplyCommand(),
+		newExportCommand(),
+		newCheckCommand(),
+		newTemplateCommand(),
+	)
+
+	return root
 }
 
-func main() {
-	// check if root user except if devmode is enabled
-	if !isRootUser() && !settings.CheckDevMode() {
-		formattedString := fmt.Sprintf("error: '%v' must be run as root user.", os.Args[0])
-		fmt.Println(formattedString)
-		os.Exit(1)
+// 'lb <cluster> <command> ...' is 'lb <command> <cluster> ...' with the
+// cluster first, swap them so cobra finds the subcommand
+func clusterFirst(root *cobra.Command, args []string) []string {
+	if len(args) < 3 || args[0] != "lb" || strings.HasPrefix(args[1], "-") {
+		return args
 	}
 
-	// get args
-	command := parseArgs()
-
-	// route command
-	switch command.name {
-	case "lb":
-		switch command.function {
-		case "list":
-			loadbalancer.List()
-		case "new":
-			loadbalancer.New(command.data["cluster"])
-		case "remove":
-			loadbalancer.Remove(command.data["cluster"])
-		case "status":
-			loadbalancer.Status(command.data["cluster"])
-		case "add":
-			loadbalancer.Add(command.data["cluster"], command.data["ip"], command.data["host"])
-		case "del":
-			loadbalancer.Del(command.data["cluster"], command.data["host"])
-		case "move":
-			loadbalancer.Move(command.data["cluster"], command.data["from"], command.data["to"])
-		case "restore":
-			loadbalancer.Restore(command.data["cluster"], command.data["host"])
-		}
-	case "proxy":
-		switch command.function {
-		case "list":
-			proxy.List(command.data["k8s"])
-		case "enable":
-			proxy.Enable(command.data["k8s"], command.data["hostname"])
-		case "disable":
-			proxy.Disable(command.data["k8s"], command.data["hostname"])
-		case "remove":
-			proxy.Remove(command.data["k8s"], command.data["hostname"])
-		case "new":
-			var ssl bool
-			var sslBypassFirewall bool
-			var proxySsl bool
-			var proxySslVerifyOff bool
-
-			if command.data["ssl"] == "true" {
-				ssl = true
-			} else {
-				ssl = false
-			}
-
-			if command.data["ssl-bypass-firewall"] == "true" {
-				sslBypassFirewall = true
-			} else {
-				sslBypassFirewall = false
-			}
-
-			if command.data["proxy-ssl"] == "true" {
-				proxySsl = true
-			} else {
-				proxySsl = false
-			}
-
-			if command.data["proxy-ssl-verify-off"] == "true" {
-				proxySslVerifyOff = true
-			} else {
-				proxySslVerifyOff = false
-			}
-
-			proxy.New(command.data["k8s"], command.data["hostname"], command.data["ip"], command.data["port"], command.data["proxy-uri"], ssl, sslBypassFirewall, proxySsl, proxySslVerifyOff)
+	lb, _, err := root.Find(args[:1])
+	if err != nil || args[1] == "help" {
+		return args
+	}
+	for _, sub := range lb.Commands() {
+		if sub.Name() == args[1] {
+			return args
 		}
+	}
+
+	swapped := append([]string{args[0], args[2], args[1]}, args[3:]...)
+	return swapped
+}
+
+func main() {
+	root := newRootCommand()
+	root.SetArgs(clusterFirst(root, os.Args[1:]))
+
+	cmd, err := root.ExecuteC()
+
+	if held != nil {
+		held.Release()
+	}
+
+	// dry runs print their plan and change nothing
+	if err == nil || errors.Is(err, transaction.ErrDryRun) {
+		return
+	}
 
+	// unknown commands and flags, missing args, ...
+	if !parsed {
+		fmt.Fprintln(os.Stderr, "parser:", err)
+		fmt.Fprintf(os.Stderr, "Run '%v --help' for usage.\n", cmd.CommandPath())
+		os.Exit(exitUsage)
 	}
 
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(exitCode(err))
 }
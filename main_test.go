@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"nickneal.dev/go-proxymanager/check"
+)
+
+func TestClusterFirst(t *testing.T) {
+	tests := []struct {
+		Args     string
+		Expected string
+	}{
+		{"lb prod status", "lb status prod"},
+		{"lb prod add node1 10.0.0.1", "lb add prod node1 10.0.0.1"},
+		{"lb new prod", "lb new prod"},
+		{"lb remove prod --backup", "lb remove prod --backup"},
+		{"lb help status", "lb help status"},
+		{"lb --help status", "lb --help status"},
+		{"lb list", "lb list"},
+		{"proxy enable test.local", "proxy enable test.local"},
+	}
+
+	root := newRootCommand()
+	for _, test := range tests {
+		output := strings.Join(clusterFirst(root, strings.Fields(test.Args)), " ")
+		if output != test.Expected {
+			t.Errorf("Expected '%v', received '%v'", test.Expected, output)
+		}
+	}
+}
+
+// rejected before running the command, nothing is touched
+func TestUsageErrors(t *testing.T) {
+	tests := []string{
+		"unknown",
+		"proxy new test.local",
+		"proxy new test.local --ip 10.0.0.1 --k8s test1",
+		"proxy new test.local --ip 10.0.0.1 --proxy-verify-ssl-off",
+		"proxy update test.local --proxy-ssl --no-proxy-ssl",
+		"proxy migrate test.local",
+		"proxy migrate test.local --to-ip 10.0.0.1 --to-k8s test1",
+		"proxy list --output xml",
+		"--output xml lb list",
+		"proxy enable",
+		"lb prod add node1",
+		"lb list --dry-run",
+		"fw block 10.0.0.1 --dry-run",
+		"ssl renew",
+		"ssl renew test.local --all",
+		"audit --since yesterday",
+		"backup restore",
+	}
+
+	for _, test := range tests {
+		parsed = false
+		root := newRootCommand()
+		root.SetOut(io.Discard)
+		root.SetArgs(clusterFirst(root, strings.Fields(test)))
+
+		_, err := root.ExecuteC()
+		if err == nil || parsed {
+			t.Errorf("'%v': Expected usage error, received '%v'", test, err)
+		}
+	}
+}
+
+func TestIsMutating(t *testing.T) {
+	tests := []struct {
+		Args     string
+		Expected bool
+	}{
+		{"lb list", false},
+		{"lb add test1 node01.local 10.0.0.1", true},
+		{"check", false},
+		{"check --fix", true},
+		{"check --fix=false", false},
+	}
+
+	for _, test := range tests {
+		root := newRootCommand()
+		cmd, args, err := root.Find(strings.Fields(test.Args))
+		if err != nil {
+			t.Errorf("'%v': Expected command, received '%v'", test.Args, err)
+			continue
+		}
+		cmd.ParseFlags(args)
+
+		if output := isMutating(cmd); output != test.Expected {
+			t.Errorf("'%v': Expected mutating %v, received %v", test.Args, test.Expected, output)
+		}
+	}
+}
+
+// errors go to stderr, stdout stays a parseable document
+func TestCheckJsonOutput(t *testing.T) {
+	if os.Getenv("PROXYMANAGER_TEST_MAIN") == "true" {
+		os.Args = []string{"proxymanager", "check", "-o", "json"}
+		main()
+		os.Exit(0)
+	}
+
+	// fixture paths are relative to a package dir
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCheckJsonOutput$")
+	cmd.Dir = "check"
+	cmd.Env = append(os.Environ(), "PROXYMANAGER_TEST_MAIN=true", "PROXYMANAGER_DEV_MODE=true",
+		"PROXYMANAGER_CONFIG_PATH=../test_configs/proxymanager.yml")
+
+	stdout, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("Expected exit code 1, received '%v'", err)
+	}
+	if !strings.Contains(string(exitErr.Stderr), "1 problem(s) found.") {
+		t.Errorf("Expected problems found on stderr, received %q", exitErr.Stderr)
+	}
+
+	var problems []check.Problem
+	if err := json.Unmarshal(stdout, &problems); err != nil {
+		t.Fatalf("Expected json on stdout, received %q: %v", stdout, err)
+	}
+	if len(problems) != 1 || problems[0].Kind != "symlink" {
+		t.Errorf("Expected 1 symlink problem, received '%v'", problems)
+	}
+}
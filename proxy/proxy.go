@@ -5,24 +5,41 @@
 package proxy
 
 import (
-	"bufio"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"text/tabwriter"
 
 	"nickneal.dev/go-proxymanager/loadbalancer"
-	"nickneal.dev/go-proxymanager/utils/nginx"
+	sslcert "nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/atomicfile"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/output"
 	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+	"nickneal.dev/go-proxymanager/utils/transaction"
 	"nickneal.dev/go-proxymanager/utils/validate"
 )
 
+// schema of 'proxy list --output json|yaml'
 type Site struct {
-	Name    string
-	Enabled bool
+	Name string `json:"name" yaml:"name"`
+	// "" for normal proxy sites
+	Cluster string `json:"cluster" yaml:"cluster"`
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	// ip address, or k8s_<cluster> for sites behind a cluster upstream
+	Backend string `json:"backend" yaml:"backend"`
+	Port    string `json:"port" yaml:"port"`
+	Ssl     bool   `json:"ssl" yaml:"ssl"`
+	// false for sites created outside of proxymanager or before specs,
+	// their backend, port and ssl are unknown
+	HasSpec bool `json:"hasSpec" yaml:"hasSpec"`
+	// "" for sites without a spec
+	Template string `json:"template" yaml:"template"`
 }
 
 func GetNginxDir() string {
@@ -59,7 +76,6 @@ func GetEnabledSites() ([]string, error) {
 	enabledConfigDir := GetEnabledConfigDir()
 	enabledEntries, eErr := os.ReadDir(enabledConfigDir)
 	if eErr != nil {
-		fmt.Println(eErr)
 		return nil, eErr
 	}
 
@@ -78,7 +94,6 @@ func GetEnabledSites() ([]string, error) {
 func GetAvailableSites(cluster string) ([]string, error) {
 	availableEntries, aErr := os.ReadDir(GetAvailableConfigDir(cluster))
 	if aErr != nil {
-		fmt.Println(aErr)
 		return nil, aErr
 	}
 
@@ -94,6 +109,23 @@ func GetAvailableSites(cluster string) ([]string, error) {
 	return siteArray, nil
 }
 
+// clusters with a config dir under sites-available
+func GetClusters() ([]string, error) {
+	entries, err := os.ReadDir(GetAvailableConfigDir(""))
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []string
+	for _, e := range entries {
+		if e.IsDir() && regexp.MustCompile("^k8s_.*").MatchString(e.Name()) {
+			clusters = append(clusters, strings.TrimPrefix(e.Name(), "k8s_"))
+		}
+	}
+
+	return clusters, nil
+}
+
 func ClusterExists(cluster string) bool {
 	return DirectoryExist(GetAvailableConfigDir(cluster))
 }
@@ -120,185 +152,216 @@ func SiteEnabled(hostname string) bool {
 	return false
 }
 
-func RestartNginx() bool {
-	// restart nginx, if error, restore hosts file.
-	if !settings.CheckDevMode() {
-		nginxerr := nginx.RestartNginx()
-		if nginxerr != nil {
-			return false
+// sites of cluster ("" for normal proxy sites), details come from their
+// stored specs
+func GetSites(cluster string) ([]Site, error) {
+	availableSites, err := GetAvailableSites(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := []Site{}
+	for _, a := range availableSites {
+		site := Site{Name: a, Cluster: cluster, Enabled: SiteEnabled(a)}
+
+		spec, specErr := sitespec.Load(a)
+		if specErr == nil {
+			site.HasSpec = true
+			site.Backend = spec.IpAddress
+			if spec.Cluster != "" {
+				site.Backend = "k8s_" + spec.Cluster
+			}
+			site.Port = spec.Port
+			site.Ssl = spec.Ssl
+			site.Template = spec.Template
+			if site.Template == "" {
+				site.Template = sitespec.DefaultTemplate
+			}
 		}
+		sites = append(sites, site)
 	}
 
-	return true
+	return sites, nil
 }
 
-func List(cluster string) {
+func List(cluster string) error {
 	// make cluster lowercase
 	cluster = strings.ToLower(cluster)
 
 	if !ClusterExists(cluster) {
-		fmt.Printf("cluster '%v' does not exist.\n", cluster)
-		return
+		return errs.New(errs.ErrClusterNotFound, "cluster '%v' does not exist.", cluster)
 	}
 
-	availableSites, asErr := GetAvailableSites(cluster)
-	if asErr != nil {
-		fmt.Println(asErr)
-		return
+	sites, err := GetSites(cluster)
+	if err != nil {
+		return err
 	}
 
-	if availableSites == nil {
-		output := "No sites available"
-		if cluster != "" {
-			output = output + " in cluster '" + cluster + "'"
+	output.Print(sites, func() {
+		if len(sites) == 0 {
+			message := "No sites available"
+			if cluster != "" {
+				message = message + " in cluster '" + cluster + "'"
+			}
+			fmt.Println(message)
+			return
 		}
-		fmt.Println(output)
-		return
-	}
 
-	var output []Site
-	for _, a := range availableSites {
-		var site Site
-		site.Name = a
-		site.Enabled = SiteEnabled(a)
-		output = append(output, []Site{site}...)
-	}
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintln(w, "Site\tEnabled\tBackend\tPort\tSSL\tTemplate")
+		for _, s := range sites {
+			backend, port, ssl, template := "-", "-", "-", "-"
+			if s.HasSpec {
+				backend = s.Backend
+				if s.Port != "" {
+					port = s.Port
+				}
+				ssl = fmt.Sprintf("%v", s.Ssl)
+				template = s.Template
+			}
+			formattedstring := fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v", s.Name, s.Enabled, backend, port, ssl, template)
+			fmt.Fprintln(w, formattedstring)
+		}
+		w.Flush()
+	})
 
-	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
-	fmt.Fprintln(w, "Site\tEnabled")
-	for _, s := range output {
-		formattedstring := fmt.Sprintf("%v\t%v", s.Name, s.Enabled)
-		fmt.Fprintln(w, formattedstring)
-	}
-	w.Flush()
+	return nil
 }
 
-func Enable(cluster string, hostname string) {
+func Enable(cluster string, hostname string) error {
 	// make sure args are lowercase
 	cluster = strings.ToLower(cluster)
 	hostname = strings.ToLower(hostname)
+	cluster = ResolveCluster(cluster, hostname)
 
 	if !ClusterExists(cluster) {
-		fmt.Printf("Cluster '%v' does not exist.\n", cluster)
-		return
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
 	if !SiteExistsInCluster(cluster, hostname) {
-		if cluster == "" {
-			fmt.Printf("Site '%v' does not exist.\n", hostname)
-		} else {
-			fmt.Printf("Site '%v' does not exist in cluster '%v'.\n", hostname, cluster)
-		}
-		return
+		return siteNotFound(cluster, hostname)
 	}
 
+	// nothing to do, not an error
 	if SiteEnabled(hostname) {
 		fmt.Printf("Site '%v' is already enabled.\n", hostname)
-		return
+		return nil
+	}
+
+	// bring config up to date with its spec, cluster nodes may have
+	// changed while the site was disabled
+	configLines, renderErr := RenderSite(cluster, hostname)
+	if renderErr != nil {
+		return fmt.Errorf("There was an error rendering '%v': %w", hostname, renderErr)
 	}
 
-	// create symlink
 	sourcePath := GetAvailableConfigDir(cluster) + "/" + hostname + ".conf"
 	destinationPath := GetEnabledConfigDir() + "/" + hostname + ".conf"
-	err := os.Symlink(sourcePath, destinationPath)
-	if err != nil {
-		fmt.Printf("There was an error enabling '%v'.\n", hostname)
+
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+	if configLines != nil {
+		tx.WriteLines(sourcePath, configLines, 0644)
 	}
+	tx.Symlink(sourcePath, destinationPath)
 
-	// restart nginx
-	if !RestartNginx() {
-		rmErr := os.Remove(destinationPath)
-		if rmErr != nil {
-			fmt.Println("There was an error reverting changes:", rmErr)
-			fmt.Println("be sure to delete symlink before restarting nginx:", destinationPath)
-		}
-		fmt.Println("There was an error in nginx config.")
-		return
+	// restart nginx, config and symlink are rolled back on error
+	if err := loadbalancer.Commit(tx, transaction.NginxReload); err != nil {
+		return err
 	}
 
 	// finished
 	fmt.Printf("'%v' enabled.\n", hostname)
 
+	InstallPendingSsl(cluster, hostname)
+
+	return nil
 }
 
-func Disable(cluster string, hostname string) {
+func Disable(cluster string, hostname string) error {
 	// make sure args are lowercase
 	cluster = strings.ToLower(cluster)
 	hostname = strings.ToLower(hostname)
+	cluster = ResolveCluster(cluster, hostname)
 
 	if !ClusterExists(cluster) {
-		fmt.Printf("Cluster '%v' does not exist.\n", cluster)
-		return
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
 	if !SiteExistsInCluster(cluster, hostname) {
-		if cluster == "" {
-			fmt.Printf("Site '%v' does not exist.\n", hostname)
-		} else {
-			fmt.Printf("Site '%v' does not exist in cluster '%v'.\n", hostname, cluster)
-		}
-		return
+		return siteNotFound(cluster, hostname)
 	}
 
+	// nothing to do, not an error
 	if !SiteEnabled(hostname) {
 		fmt.Printf("Site '%v' is already disabled.\n", hostname)
-		return
+		return nil
 	}
 
 	// remove symlink
 	destinationPath := GetEnabledConfigDir() + "/" + hostname + ".conf"
 
-	err := os.Remove(destinationPath)
-	if err != nil {
-		fmt.Printf("There was an error disabling '%v'.\n", hostname)
-	}
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+	tx.Remove(destinationPath)
 
-	// restart nginx
-	if !RestartNginx() {
-		fmt.Println("There was an issue restarting nginx.")
-		fmt.Println("Your site will be disabled after the next nginx restart.")
-		return
+	// restart nginx, the symlink is restored on error
+	if err := loadbalancer.Commit(tx, transaction.NginxReload); err != nil {
+		return err
 	}
 
 	// finished
 	fmt.Printf("'%v' disabled.\n", hostname)
+
+	return nil
 }
 
-func Remove(cluster string, hostname string) {
+func Remove(cluster string, hostname string) error {
 	// make sure args are lowercase
 	cluster = strings.ToLower(cluster)
 	hostname = strings.ToLower(hostname)
+	cluster = ResolveCluster(cluster, hostname)
 
 	if !ClusterExists(cluster) {
-		fmt.Printf("Cluster '%v' does not exist.\n", cluster)
-		return
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 	}
 
 	if !SiteExistsInCluster(cluster, hostname) {
-		if cluster == "" {
-			fmt.Printf("Site '%v' does not exist.\n", hostname)
-		} else {
-			fmt.Printf("Site '%v' does not exist in cluster '%v'.\n", hostname, cluster)
-		}
-		return
+		return siteNotFound(cluster, hostname)
 	}
 
 	if SiteEnabled(hostname) {
-		fmt.Printf("Site '%v' is enabled. Please disable before removing.\n", hostname)
-		return
+		return errs.New(errs.ErrConflict, "Site '%v' is enabled. Please disable before removing.", hostname)
 	}
 
-	// remove config
+	// remove config, stored inputs are no longer needed either
 	sourcePath := GetAvailableConfigDir(cluster) + "/" + hostname + ".conf"
 
-	err := os.Remove(sourcePath)
-	if err != nil {
-		fmt.Printf("There was an error removing '%v'.\n", hostname)
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+	tx.Remove(sourcePath)
+	tx.Remove(sitespec.GetSpecPath(hostname))
+
+	if err := loadbalancer.Commit(tx, transaction.NginxSkip); err != nil {
+		return err
 	}
 
 	// finished
 	fmt.Printf("'%v' removed.\n", hostname)
 
+	return nil
+}
+
+// "Site 'x' does not exist." for sites outside of clusters
+func siteNotFound(cluster string, hostname string) error {
+	if cluster == "" {
+		return errs.New(errs.ErrSiteNotFound, "Site '%v' does not exist.", hostname)
+	}
+
+	return errs.New(errs.ErrSiteNotFound, "Site '%v' does not exist in cluster '%v'.", hostname, cluster)
 }
 
 // for new site only
@@ -332,30 +395,74 @@ func SiteExists(hostname string) bool {
 	return false
 }
 
-// for new site only
-func CreateSiteConfig(filePath string, fileLines []string) error {
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
+// find the cluster a site belongs to ("" for normal proxy sites)
+func GetSiteCluster(hostname string) (string, bool) {
+	if SiteExistsInCluster("", hostname) {
+		return "", true
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	for _, str := range fileLines {
-		_, err = writer.WriteString(str + "\n")
-		if err != nil {
-			//fmt.Println("Error writing to file:", err)
-			return err
+	clusters, _ := GetClusters()
+	for _, cluster := range clusters {
+		if SiteExistsInCluster(cluster, hostname) {
+			return cluster, true
 		}
 	}
 
-	err = writer.Flush()
+	return "", false
+}
+
+func GetSiteConfigPath(cluster string, hostname string) string {
+	return sitespec.GetConfigPath(cluster, hostname)
+}
+
+// sites given without --k8s are looked up in the spec store
+func ResolveCluster(cluster string, hostname string) string {
+	if cluster != "" || SiteExistsInCluster("", hostname) {
+		return cluster
+	}
+
+	spec, err := sitespec.Load(hostname)
+	if err == nil {
+		return spec.Cluster
+	}
+
+	return cluster
+}
+
+// render site config from its stored spec. returns the new config lines
+// if they differ from the file, nil for sites without a spec.
+func RenderSite(cluster string, hostname string) ([]string, error) {
+	spec, err := sitespec.Load(hostname)
 	if err != nil {
-		//fmt.Println("Error flushing writer:", err)
-		return err
+		return nil, nil
 	}
 
-	return nil
+	var nodes []string
+	if cluster != "" {
+		nodes = loadbalancer.GetClusterNodes(cluster)
+	}
+
+	configLines, err := sitespec.Render(spec, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	configPath := GetSiteConfigPath(cluster, hostname)
+	currentLines, err := sslcert.ReadConfigLines(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Join(currentLines, "\n") == strings.Join(configLines, "\n") {
+		return nil, nil
+	}
+
+	return configLines, nil
+}
+
+// write site config, replacing an existing one atomically
+func CreateSiteConfig(filePath string, fileLines []string) error {
+	return atomicfile.WriteLines(filePath, fileLines, 0644)
 }
 
 func GetMD5Hash(text string) string {
@@ -371,112 +478,448 @@ func New(cluster string,
 	ssl bool,
 	sslBypassFirewall bool,
 	proxySsl bool,
-	proxySslVerifyOff bool) {
+	proxySslVerifyOff bool,
+	template string) error {
 
 	// make sure args are lowercase
 	cluster = strings.ToLower(cluster)
 	hostname = strings.ToLower(hostname)
+	template = templateName(template)
 
 	// check if hostname config exists on web server
 	if SiteExists(hostname) {
-		fmt.Printf("Site '%v' is already in use on this server.\n", hostname)
-		return
+		return errs.New(errs.ErrSiteExists, "Site '%v' is already in use on this server.", hostname)
 	}
 
 	// if cluster isn't specified, verify IP address
 	if cluster == "" && (ipAddress == "" || !validate.ValidateIPAddress(ipAddress)) {
-		fmt.Println("IP Address not valid:", ipAddress)
-		return
+		return errs.New(errs.ErrInvalidArgument, "IP Address not valid: %v", ipAddress)
 	}
 
 	if port != "" && !validate.ValidatePort(port) {
-		fmt.Printf("Port '%v' is invalid. please specify a port in the following range: 1024-49151\n", port)
-		return
+		return errs.New(errs.ErrInvalidArgument, "Port '%v' is invalid. please specify a port in the following range: 1024-49151", port)
 	}
 
 	if uri != "" && !validate.ValidateUri(uri) {
-		fmt.Printf("Uri '%v' is invalid.\nA uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~\n", uri)
-		return
+		return errs.New(errs.ErrInvalidArgument, "Uri '%v' is invalid.\nA uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", uri)
 	}
 
-	// define config
-	var config string
-	var backend string
+	// site inputs are stored so the config can be rendered again later
+	spec := sitespec.Spec{
+		Hostname:          hostname,
+		Cluster:           cluster,
+		IpAddress:         ipAddress,
+		Port:              port,
+		Uri:               uri,
+		Ssl:               ssl,
+		SslBypassFirewall: sslBypassFirewall,
+		ProxySsl:          proxySsl,
+		ProxySslVerifyOff: proxySslVerifyOff,
+		Template:          template,
+	}
+	var nodes []string
+
+	if _, err := sitespec.GetTemplate(template, cluster != ""); err != nil {
+		return err
+	}
 
 	// run check only of cluster is defined
 	if cluster != "" {
 		//check if cluster exists
 		if !ClusterExists(cluster) {
-			fmt.Printf("Cluster '%v' does not exist.\n", cluster)
-			return
+			return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
 		}
 
 		// check if there are any nodes in the cluster
 		if loadbalancer.GetClusterNodeCount(cluster) == 0 {
-			fmt.Printf("Cluster '%v' has no assigned nodes.\n", cluster)
-			return
+			return errs.New(errs.ErrConflict, "Cluster '%v' has no assigned nodes.", cluster)
 		}
 
 		if port == "" {
-			fmt.Printf("no port was specified.")
-			return
+			return errs.New(errs.ErrInvalidArgument, "no port was specified.")
+		}
+
+		// k8s sites proxy to an upstream of the cluster nodes
+		spec.IpAddress = ""
+		nodes = loadbalancer.GetClusterNodes(cluster)
+	}
+
+	configLines, renderErr := sitespec.Render(spec, nodes)
+	if renderErr != nil {
+		return fmt.Errorf("There was an issue rendering the site config: %w", renderErr)
+	}
+
+	// prepare for writing file.
+	filePath := GetSiteConfigPath(cluster, hostname)
+
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+
+	// serve acme challenges, the certificate is requested once enabled
+	if ssl {
+		tx.Mkdir(filepath.Dir(sslcert.GetSnippetPath()), 0755)
+		tx.WriteLines(sslcert.GetSnippetPath(), sslcert.GetAcmeSnippet(), 0644)
+	}
+
+	tx.WriteLines(filePath, configLines, 0644)
+
+	err := sitespec.Stage(tx, spec)
+	if err != nil {
+		return fmt.Errorf("There was an issue saving the site spec: %w", err)
+	}
+
+	// nothing is left behind on error
+	if err := loadbalancer.Commit(tx, transaction.NginxSkip); err != nil {
+		return err
+	}
+
+	if cluster == "" {
+		fmt.Printf("Site '%v' created.\n", hostname)
+	} else {
+		fmt.Printf("Site '%v' created in cluster '%v'.\n", hostname, cluster)
+	}
+
+	if ssl {
+		fmt.Printf("SSL certificate will be installed when '%v' is enabled.\n", hostname)
+	}
+
+	return nil
+}
+
+// recover specs for sites that were created before specs were stored or by
+// hand, hostname "" imports every site without a spec.
+func Import(hostname string) error {
+	hostname = strings.ToLower(hostname)
+
+	clusters, err := GetClusters()
+	if err != nil {
+		return err
+	}
+
+	// specs are committed together once every site was read
+	tx := transaction.New()
+	var imported []string
+	failed := 0
+	for _, cluster := range append([]string{""}, clusters...) {
+		availableSites, _ := GetAvailableSites(cluster)
+		for _, a := range availableSites {
+			if hostname != "" && a != hostname {
+				continue
+			}
+
+			if sitespec.Exists(a) {
+				if hostname != "" {
+					return errs.New(errs.ErrConflict, "Site '%v' already has a spec.", a)
+				}
+				continue
+			}
+
+			configPath := GetSiteConfigPath(cluster, a)
+			fileLines, readErr := sslcert.ReadConfigLines(configPath)
+			if readErr != nil {
+				fmt.Printf("There was an issue reading '%v': %v\n", a, readErr)
+				failed++
+				continue
+			}
+
+			spec, importErr := sitespec.Import(cluster, a, fileLines)
+			if importErr != nil {
+				fmt.Printf("Spec for '%v' could not be imported: %v\n", a, importErr)
+				failed++
+				continue
+			}
+
+			stageErr := sitespec.Stage(tx, spec)
+			if stageErr != nil {
+				fmt.Printf("There was an issue saving the spec of '%v': %v\n", a, stageErr)
+				failed++
+				continue
+			}
+			tx.AddCluster(cluster)
+			tx.AddHost(a)
+			imported = append(imported, a)
+
+			// hand edits are lost the next time the site is rendered
+			var nodes []string
+			if cluster != "" {
+				nodes = loadbalancer.GetClusterNodes(cluster)
+			}
+			configLines, renderErr := sitespec.Render(spec, nodes)
+			if renderErr == nil && strings.Join(configLines, "\n") != strings.Join(fileLines, "\n") {
+				fmt.Printf("Warning: config of '%v' differs from its rendered template.\n", a)
+			}
 		}
+	}
 
-		ipAddress = GetMD5Hash(hostname)
-		config = settings.LoadConfig().Proxy.K8sProxyConfig
+	if hostname != "" && len(imported) == 0 && !SiteExists(hostname) {
+		return errs.New(errs.ErrSiteNotFound, "Site '%v' does not exist.", hostname)
+	}
 
-		var upstreamNodes string
-		for _, str := range loadbalancer.GetClusterNodes(cluster) {
-			upstreamNodes = upstreamNodes + "\tserver " + str + ":" + port + ";\n"
+	// nginx doesn't read specs
+	if len(imported) > 0 {
+		if err := loadbalancer.Commit(tx, transaction.NginxSkip); err != nil {
+			return err
 		}
+	}
+
+	for _, a := range imported {
+		fmt.Printf("Spec imported for '%v'.\n", a)
+	}
+
+	// every failure was printed above
+	if failed > 0 {
+		return fmt.Errorf("%v spec(s) could not be imported.", failed)
+	}
+
+	if hostname == "" && len(imported) == 0 {
+		fmt.Println("No sites without a spec.")
+	}
+
+	return nil
+}
 
-		config = strings.Replace(config, "%UPSTREAM_NAME%", ipAddress, 1)
-		config = strings.Replace(config, "%UPSTREAM_NODES%", upstreamNodes, 1)
+// changes for Update, empty/nil fields are left as they are
+type SiteChanges struct {
+	IpAddress         string
+	Port              string
+	Uri               string
+	ProxySsl          *bool
+	ProxySslVerifyOff *bool
+	// "default" goes back to proxyConfig and k8sProxyConfig
+	Template string
+}
 
+// templates are stored by name, the default as ""
+func templateName(template string) string {
+	template = strings.ToLower(template)
+	if template == sitespec.DefaultTemplate {
+		return ""
+	}
+
+	return template
+}
+
+// change an existing site in place without disabling it
+func Update(cluster string, hostname string, changes SiteChanges) error {
+	// make sure args are lowercase
+	cluster = strings.ToLower(cluster)
+	hostname = strings.ToLower(hostname)
+	cluster = ResolveCluster(cluster, hostname)
+
+	if !ClusterExists(cluster) {
+		return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", cluster)
+	}
+
+	if !SiteExistsInCluster(cluster, hostname) {
+		return siteNotFound(cluster, hostname)
+	}
+
+	configPath := GetSiteConfigPath(cluster, hostname)
+	fileLines, readErr := sslcert.ReadConfigLines(configPath)
+	if readErr != nil {
+		return fmt.Errorf("There was an issue reading the site config: %w", readErr)
+	}
+
+	// sites without a stored spec are imported from their config
+	spec, specErr := sitespec.Load(hostname)
+	if specErr != nil {
+		var importErr error
+		spec, importErr = sitespec.Import(cluster, hostname, fileLines)
+		if importErr != nil {
+			return errs.New(errs.ErrConflict, "Site '%v' has no spec and it could not be imported: %v", hostname, importErr)
+		}
+	}
+	oldSpec := spec
+
+	if changes.IpAddress != "" {
+		if cluster != "" {
+			return errs.New(errs.ErrInvalidArgument, "Site '%v' is in cluster '%v' and has no IP Address.", hostname, cluster)
+		}
+
+		if !validate.ValidateIPAddress(changes.IpAddress) {
+			return errs.New(errs.ErrInvalidArgument, "IP Address not valid: %v", changes.IpAddress)
+		}
+		spec.IpAddress = changes.IpAddress
+	}
+
+	if changes.Port != "" {
+		if !validate.ValidatePort(changes.Port) {
+			return errs.New(errs.ErrInvalidArgument, "Port '%v' is invalid. please specify a port in the following range: 1024-49151", changes.Port)
+		}
+		spec.Port = changes.Port
+	}
+
+	if changes.Uri != "" {
+		if !validate.ValidateUri(changes.Uri) {
+			return errs.New(errs.ErrInvalidArgument, "Uri '%v' is invalid.\nA uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", changes.Uri)
+		}
+		spec.Uri = changes.Uri
+	}
+
+	if changes.ProxySsl != nil {
+		spec.ProxySsl = *changes.ProxySsl
+	}
+
+	if changes.ProxySslVerifyOff != nil {
+		spec.ProxySslVerifyOff = *changes.ProxySslVerifyOff
+	}
+
+	if changes.Template != "" {
+		spec.Template = templateName(changes.Template)
+		if _, err := sitespec.GetTemplate(spec.Template, cluster != ""); err != nil {
+			return err
+		}
+	}
+
+	// nothing to do, not an error
+	if spec == oldSpec && specErr == nil {
+		fmt.Printf("No changes for '%v'.\n", hostname)
+		return nil
+	}
+
+	var nodes []string
+	if cluster != "" {
+		nodes = loadbalancer.GetClusterNodes(cluster)
+	}
+
+	configLines, renderErr := sitespec.Render(spec, nodes)
+	if renderErr != nil {
+		return fmt.Errorf("There was an issue rendering the site config: %w", renderErr)
+	}
+
+	// swap config
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+	tx.WriteLines(configPath, configLines, 0644)
+
+	err := sitespec.Stage(tx, spec)
+	if err != nil {
+		return fmt.Errorf("There was an issue saving the site spec: %w", err)
+	}
+
+	// only enabled sites are loaded by nginx
+	action := transaction.NginxSkip
+	if SiteEnabled(hostname) {
+		action = transaction.NginxReload
+	}
+
+	if err := loadbalancer.Commit(tx, action); err != nil {
+		return err
+	}
+
+	fmt.Printf("Site '%v' updated.\n", hostname)
+
+	return nil
+}
+
+// move a site between clusters or between a cluster and a normal proxy
+// site. toCluster "" moves the site to ipAddress.
+func Migrate(hostname string, toCluster string, ipAddress string, port string) error {
+	// make sure args are lowercase
+	toCluster = strings.ToLower(toCluster)
+	hostname = strings.ToLower(hostname)
+
+	cluster, found := GetSiteCluster(hostname)
+	if !found {
+		return errs.New(errs.ErrSiteNotFound, "Site '%v' does not exist.", hostname)
+	}
+
+	if toCluster != "" {
+		if !ClusterExists(toCluster) {
+			return errs.New(errs.ErrClusterNotFound, "Cluster '%v' does not exist.", toCluster)
+		}
+
+		if toCluster == cluster {
+			return errs.New(errs.ErrConflict, "Site '%v' is already in cluster '%v'.", hostname, cluster)
+		}
+
+		if loadbalancer.GetClusterNodeCount(toCluster) == 0 {
+			return errs.New(errs.ErrConflict, "Cluster '%v' has no assigned nodes.", toCluster)
+		}
+
+		if port == "" {
+			return errs.New(errs.ErrInvalidArgument, "no port was specified.")
+		}
+
+		// k8s sites proxy to the upstream
+		ipAddress = ""
 	} else {
-		// load config for non-k8s resource
-		config = settings.LoadConfig().Proxy.ProxyConfig
+		if cluster == "" {
+			return errs.New(errs.ErrConflict, "Site '%v' is not in a cluster, use 'proxy update --ip' instead.", hostname)
+		}
+
+		if !validate.ValidateIPAddress(ipAddress) {
+			return errs.New(errs.ErrInvalidArgument, "IP Address not valid: %v", ipAddress)
+		}
 	}
 
-	// config params
-	backend = "http://" + ipAddress
-	if proxySsl {
-		backend = "https://" + ipAddress
+	if port != "" && !validate.ValidatePort(port) {
+		return errs.New(errs.ErrInvalidArgument, "Port '%v' is invalid. please specify a port in the following range: 1024-49151", port)
 	}
 
-	if cluster == "" && port != "" {
-		backend = backend + ":" + port
+	configPath := GetSiteConfigPath(cluster, hostname)
+	fileLines, readErr := sslcert.ReadConfigLines(configPath)
+	if readErr != nil {
+		return fmt.Errorf("There was an issue reading the site config: %w", readErr)
 	}
 
-	if uri != "" {
-		backend = backend + uri
+	// sites without a stored spec are imported from their config
+	spec, specErr := sitespec.Load(hostname)
+	if specErr != nil {
+		var importErr error
+		spec, importErr = sitespec.Import(cluster, hostname, fileLines)
+		if importErr != nil {
+			return errs.New(errs.ErrConflict, "Site '%v' has no spec and it could not be imported: %v", hostname, importErr)
+		}
 	}
 
-	verifyBackendSsl := ""
-	if proxySsl && proxySslVerifyOff {
-		verifyBackendSsl = "proxy_ssl_verify off;"
+	spec.Cluster = toCluster
+	spec.IpAddress = ipAddress
+	if port != "" {
+		spec.Port = port
 	}
 
-	// perpare config
-	config = strings.Replace(config, "%HOSTNAME%", hostname, 1)
-	config = strings.Replace(config, "%BACKEND%", backend, 1)
-	config = strings.Replace(config, "%PROXY_SSL_VERIFY_OFF%", verifyBackendSsl, 1)
+	var nodes []string
+	if toCluster != "" {
+		nodes = loadbalancer.GetClusterNodes(toCluster)
+	}
 
-	// prepare for writing file.
-	configDir := GetAvailableConfigDir(cluster)
-	configLines := strings.Split(config, "\n")
-	filePath := configDir + "/" + hostname + ".conf"
+	configLines, renderErr := sitespec.Render(spec, nodes)
+	if renderErr != nil {
+		return fmt.Errorf("There was an issue rendering the site config: %w", renderErr)
+	}
+
+	// write new config next to the old one and point the symlink at it,
+	// the old config goes once nginx accepted the new one
+	newConfigPath := GetSiteConfigPath(toCluster, hostname)
+	action := transaction.NginxSkip
+
+	tx := transaction.New()
+	tx.AddCluster(cluster, toCluster)
+	tx.AddHost(hostname)
+	tx.WriteLines(newConfigPath, configLines, 0644)
+	if SiteEnabled(hostname) {
+		tx.Symlink(newConfigPath, GetEnabledConfigDir()+"/"+hostname+".conf")
+		action = transaction.NginxReload
+	}
+	tx.Remove(configPath)
 
-	err := CreateSiteConfig(filePath, configLines)
+	err := sitespec.Stage(tx, spec)
 	if err != nil {
-		fmt.Println("There was an issue creating the site config.", err)
-		return
+		return fmt.Errorf("There was an issue saving the site spec: %w", err)
 	}
 
-	if cluster == "" {
-		fmt.Printf("Site '%v' created.\n", hostname)
+	if err := loadbalancer.Commit(tx, action); err != nil {
+		return err
+	}
+
+	if toCluster != "" {
+		fmt.Printf("Site '%v' migrated to cluster '%v'.\n", hostname, toCluster)
 	} else {
-		fmt.Printf("Site '%v' created in cluster '%v'.\n", hostname, cluster)
+		fmt.Printf("Site '%v' migrated to '%v'.\n", hostname, ipAddress)
 	}
 
+	return nil
 }
@@ -11,6 +11,12 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	sslcert "nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/output"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
 )
 
 func Getwd() string {
@@ -35,6 +41,30 @@ func BuildFilePath(filePath string) string {
 	}
 }
 
+// enable and disable replace the sites-enabled fixtures with symlinks, put
+// the fixtures back once the test is done
+func RestoreEnabledSites(t *testing.T) {
+	enabledDir := BuildFilePath("test_configs/nginx/sites-enabled")
+	fixtures := make(map[string][]byte)
+	entries, _ := os.ReadDir(enabledDir)
+	for _, e := range entries {
+		data, err := os.ReadFile(enabledDir + "/" + e.Name())
+		if err == nil {
+			fixtures[e.Name()] = data
+		}
+	}
+
+	t.Cleanup(func() {
+		entries, _ := os.ReadDir(enabledDir)
+		for _, e := range entries {
+			os.Remove(enabledDir + "/" + e.Name())
+		}
+		for name, data := range fixtures {
+			os.WriteFile(enabledDir+"/"+name, data, 0644)
+		}
+	})
+}
+
 func CreateTestFile(cluster string, site string) error {
 	dirString := GetAvailableConfigDir(cluster)
 	fileString := dirString + "/" + site + ".conf"
@@ -215,6 +245,30 @@ func TestClusterExists(t *testing.T) {
 	os.Clearenv()
 }
 
+func TestGetClusters(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	get, err := GetClusters()
+	if err != nil {
+		t.Errorf("Error occured %v", err)
+		return
+	}
+	want := []string{"empty", "test1", "test2"}
+
+	if len(get) != len(want) {
+		t.Errorf("Expected '%v' but received '%v'", want, get)
+		return
+	}
+
+	for i, _ := range get {
+		if get[i] != want[i] {
+			t.Errorf("Expected '%v' at index %d but received '%v'", want[i], i, get[i])
+		}
+	}
+
+	os.Clearenv()
+}
+
 type siteExistsInClusterTest struct {
 	Cluster  string
 	Hostname string
@@ -267,14 +321,15 @@ func TestSiteEnabled(t *testing.T) {
 type listTest struct {
 	Cluster  string
 	Expected string
+	Error    error
 }
 
 // TODO: add test to test empty dir for cluster ""
 var listTests = []listTest{
-	listTest{"", "SiteEnabledsingle.localfalse"},
-	listTest{"test1", "SiteEnabledtest.localtrue"},
-	listTest{"test3", "cluster'test3'doesnotexist."},
-	listTest{"empty", "Nositesavailableincluster'empty'"},
+	listTest{"", "SiteEnabledBackendPortSSLTemplatesingle.localfalse----", nil},
+	listTest{"test1", "SiteEnabledBackendPortSSLTemplatetest.localtrue----", nil},
+	listTest{"test3", "cluster'test3'doesnotexist.", errs.ErrClusterNotFound},
+	listTest{"empty", "Nositesavailableincluster'empty'", nil},
 }
 
 func TestList(t *testing.T) {
@@ -287,7 +342,7 @@ func TestList(t *testing.T) {
 		os.Stdout = w
 
 		// Run function
-		List(test.Cluster)
+		err := List(test.Cluster)
 
 		// revert STDOUT
 		w.Close()
@@ -297,6 +352,9 @@ func TestList(t *testing.T) {
 		var buf bytes.Buffer
 		_, _ = buf.ReadFrom(r)
 		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
 
 		// strip new lines and spaces (used since table formatting is unpredictable)
 		output = strings.ReplaceAll(output, " ", "")
@@ -306,6 +364,49 @@ func TestList(t *testing.T) {
 			t.Errorf("Expected '%v', received '%v'", test.Expected, output)
 		}
 
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Cluster '%v': Expected error '%v', received '%v'", test.Cluster, test.Error, err)
+		}
+
+	}
+
+	os.Clearenv()
+}
+
+func TestListOutput(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	defer output.SetFormat("table")
+
+	tests := []struct {
+		Format   string
+		Cluster  string
+		Expected string
+	}{
+		{"json", "test1", "[{\"name\":\"test.local\",\"cluster\":\"test1\",\"enabled\":true,\"backend\":\"\",\"port\":\"\",\"ssl\":false,\"hasSpec\":false,\"template\":\"\"}]"},
+		{"json", "empty", "[]"},
+		{"yaml", "", "-name:single.localcluster:\"\"enabled:falsebackend:\"\"port:\"\"ssl:falsehasSpec:falsetemplate:\"\""},
+	}
+
+	for _, test := range tests {
+		output.SetFormat(test.Format)
+
+		// redirect STDOUT to buffer
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		List(test.Cluster)
+
+		w.Close()
+		os.Stdout = oldStdout
+
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(r)
+		received := strings.Join(strings.Fields(buf.String()), "")
+
+		if received != test.Expected {
+			t.Errorf("Format '%v': Expected '%v', received '%v'", test.Format, test.Expected, received)
+		}
 	}
 
 	os.Clearenv()
@@ -316,19 +417,21 @@ type enableTest struct {
 	Hostname string
 	Expected string
 	Enabled  bool
+	Error    error
 }
 
 var enableTests = []enableTest{
-	enableTest{"", "single.local", "'single.local' enabled.", true},
-	enableTest{"test1", "test.local", "Site 'test.local' is already enabled.", false},
-	enableTest{"test1", "fail.local", "Site 'fail.local' does not exist in cluster 'test1'.", false},
-	enableTest{"", "fail.local", "Site 'fail.local' does not exist.", false},
-	enableTest{"test3", "fail.local", "Cluster 'test3' does not exist.", false},
+	enableTest{"", "single.local", "'single.local' enabled.", true, nil},
+	enableTest{"test1", "test.local", "Site 'test.local' is already enabled.", false, nil},
+	enableTest{"test1", "fail.local", "Site 'fail.local' does not exist in cluster 'test1'.", false, errs.ErrSiteNotFound},
+	enableTest{"", "fail.local", "Site 'fail.local' does not exist.", false, errs.ErrSiteNotFound},
+	enableTest{"test3", "fail.local", "Cluster 'test3' does not exist.", false, errs.ErrClusterNotFound},
 }
 
 func TestEnable(t *testing.T) {
 	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
 	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent restart
+	RestoreEnabledSites(t)
 
 	for _, test := range enableTests {
 		// redirect STDOUT to buffer
@@ -337,7 +440,7 @@ func TestEnable(t *testing.T) {
 		os.Stdout = w
 
 		// Run function
-		Enable(test.Cluster, test.Hostname)
+		err := Enable(test.Cluster, test.Hostname)
 
 		// revert STDOUT
 		w.Close()
@@ -347,6 +450,9 @@ func TestEnable(t *testing.T) {
 		var buf bytes.Buffer
 		_, _ = buf.ReadFrom(r)
 		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
 
 		// strip newline chars
 		output = strings.ReplaceAll(output, "\n", "")
@@ -357,6 +463,10 @@ func TestEnable(t *testing.T) {
 			continue
 		}
 
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+
 		// check if site enabled.
 		siteEnabled := SiteEnabled(test.Hostname)
 		if !siteEnabled && test.Enabled {
@@ -377,19 +487,21 @@ type disableTest struct {
 	Hostname string
 	Expected string
 	Disabled bool
+	Error    error
 }
 
 var disableTests = []disableTest{
-	disableTest{"test1", "test.local", "'test.local' disabled.", true},
-	disableTest{"", "single.local", "Site 'single.local' is already disabled.", false},
-	disableTest{"test1", "fail.local", "Site 'fail.local' does not exist in cluster 'test1'.", false},
-	disableTest{"", "fail.local", "Site 'fail.local' does not exist.", false},
-	disableTest{"test3", "fail.local", "Cluster 'test3' does not exist.", false},
+	disableTest{"test1", "test.local", "'test.local' disabled.", true, nil},
+	disableTest{"", "single.local", "Site 'single.local' is already disabled.", false, nil},
+	disableTest{"test1", "fail.local", "Site 'fail.local' does not exist in cluster 'test1'.", false, errs.ErrSiteNotFound},
+	disableTest{"", "fail.local", "Site 'fail.local' does not exist.", false, errs.ErrSiteNotFound},
+	disableTest{"test3", "fail.local", "Cluster 'test3' does not exist.", false, errs.ErrClusterNotFound},
 }
 
 func TestDisable(t *testing.T) {
 	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
 	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent restart
+	RestoreEnabledSites(t)
 
 	for _, test := range disableTests {
 		// redirect STDout to buffer
@@ -398,7 +510,7 @@ func TestDisable(t *testing.T) {
 		os.Stdout = w
 
 		// Run function
-		Disable(test.Cluster, test.Hostname)
+		err := Disable(test.Cluster, test.Hostname)
 
 		// revert stdout
 		w.Close()
@@ -408,6 +520,9 @@ func TestDisable(t *testing.T) {
 		var buf bytes.Buffer
 		_, _ = buf.ReadFrom(r)
 		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
 
 		// strip newline chars
 		output = strings.ReplaceAll(output, "\n", "")
@@ -418,6 +533,10 @@ func TestDisable(t *testing.T) {
 			continue
 		}
 
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+
 		// check if site disabled
 		siteEnabled := SiteEnabled(test.Hostname)
 		if siteEnabled && test.Disabled {
@@ -438,15 +557,16 @@ type removeTest struct {
 	Hostname string
 	Create   bool
 	Expected string
+	Error    error
 }
 
 var removeTests = []removeTest{
-	removeTest{"empty", "new.local", true, "'new.local' removed."},                                            // in-cluster
-	removeTest{"", "new.local", true, "'new.local' removed."},                                                 // non-cluster
-	removeTest{"test1", "test.local", false, "Site 'test.local' is enabled. Please disable before removing."}, // test enabled site
-	removeTest{"", "new.local", false, "Site 'new.local' does not exist."},                                    // test site that doesn't exist
-	removeTest{"empty", "new.local", false, "Site 'new.local' does not exist in cluster 'empty'."},            // test site that doesn't exist cluster
-	removeTest{"test3", "new.local", false, "Cluster 'test3' does not exist."},                                // test cluster that doesn't exist
+	removeTest{"empty", "new.local", true, "'new.local' removed.", nil},                                                         // in-cluster
+	removeTest{"", "new.local", true, "'new.local' removed.", nil},                                                              // non-cluster
+	removeTest{"test1", "test.local", false, "Site 'test.local' is enabled. Please disable before removing.", errs.ErrConflict}, // test enabled site
+	removeTest{"", "new.local", false, "Site 'new.local' does not exist.", errs.ErrSiteNotFound},                                // test site that doesn't exist
+	removeTest{"empty", "new.local", false, "Site 'new.local' does not exist in cluster 'empty'.", errs.ErrSiteNotFound},        // test site that doesn't exist cluster
+	removeTest{"test3", "new.local", false, "Cluster 'test3' does not exist.", errs.ErrClusterNotFound},                         // test cluster that doesn't exist
 }
 
 func TestRemove(t *testing.T) {
@@ -467,7 +587,7 @@ func TestRemove(t *testing.T) {
 		os.Stdout = w
 
 		// run function
-		Remove(test.Cluster, test.Hostname)
+		err := Remove(test.Cluster, test.Hostname)
 
 		//revert stdout
 		w.Close()
@@ -477,6 +597,9 @@ func TestRemove(t *testing.T) {
 		var buf bytes.Buffer
 		_, _ = buf.ReadFrom(r)
 		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
 
 		// strip newline chars
 		output = strings.ReplaceAll(output, "\n", "")
@@ -486,6 +609,10 @@ func TestRemove(t *testing.T) {
 			t.Errorf("Expected '%v' for site '%v' in cluster '%v', received '%v'", test.Expected, test.Hostname, test.Cluster, output)
 		}
 
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+
 		// check if test file that was created was removed
 		if test.Create && SiteExistsInCluster(test.Cluster, test.Hostname) {
 			t.Errorf("Site '%v' in cluster '%v' was not removed as planned", test.Hostname, test.Cluster)
@@ -576,24 +703,25 @@ func TestNew(t *testing.T) {
 		CheckFileHash bool
 		FileHash string
 		Cleanup bool // used to tell test to cleanup file after run
+		Error error
 	}{
 		//{"","","","","",false, false, false, false, "", false, "", false},
-		{"","single.local","10.0.0.1","1024","",false, false, false, false, "Site 'single.local' is already in use on this server.", false, "", false},
-		{"","fail1.local","10.0.0.256","1024","",false, false, false, false, "IP Address not valid: 10.0.0.256", false, "", false},
-		{"","fail2.local","10.0.0.1","1023","",false, false, false, false, "Port '1023' is invalid. please specify a port in the following range: 1024-49151", false, "", false},
-		{"","fail3.local","10.0.0.1","49152","",false, false, false, false, "Port '49152' is invalid. please specify a port in the following range: 1024-49151", false, "", false},
-		{"","fail4.local","10.0.0.1","abcd","",false, false, false, false, "Port 'abcd' is invalid. please specify a port in the following range: 1024-49151", false, "", false},
-		{"","fail5.local","10.0.0.1","1024","/uri?a=b",false, false, false, false, "Uri '/uri?a=b' is invalid.A uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", false, "", false},
-		{"","fail6.local","10.0.0.1","1024","uri-test",false, false, false, false, "Uri 'uri-test' is invalid.A uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", false, "", false},
-		{"fail","fail7.local","10.0.0.1","1024","",false, false, false, false, "Cluster 'fail' does not exist.", false, "", false},
-		{"empty","fail8.local","10.0.0.1","1024","",false, false, false, false, "Cluster 'empty' has no assigned nodes.", false, "", false},
-		{"test1","fail9.local","10.0.0.1","","",false, false, false, false, "no port was specified.", false, "", false},
-		{"","pass1.local","10.0.0.1","1024","/uri",false, false, false, false, "Site 'pass1.local' created.", true, "2fe65cf7669d5c171b9aa9770734cebc30055f2d6b548efeac1f0c255aff667c", true},
-		{"","pass2.local","10.0.0.1","","/uri",false, false, true, false, "Site 'pass2.local' created.", true, "c1793490c15b47400a1725bdd9bd87180734e733a1e00b6e533e93f9917102fa", true},
-		{"","pass3.local","10.0.0.1","","",false, false, true, true, "Site 'pass3.local' created.", true, "2a689129d8875ea403d3cd8d755fb9bf5628d2b8806ea47d1ab376133bb6cb71", true},
-		{"test1","pass4.local","10.0.0.1","8080","/uri",false, false, false, false, "Site 'pass4.local' created in cluster 'test1'.", true, "f142358983ee6999fa855155837039205c027833946ee14bed139c3992bc7017", true},
-		{"test1","pass5.local","10.0.0.1","8443","/uri",false, false, true, false, "Site 'pass5.local' created in cluster 'test1'.", true, "5648642a6773dd7269908b4d2d4b0251c67037d93bb3582fa06b87fe8168f179", true},
-		{"test1","pass6.local","10.0.0.1","8443","",false, false, true, true, "Site 'pass6.local' created in cluster 'test1'.", true, "a9e1beb93458df052bc947cbd4449ae6ba65a107c5111fd14b15afa8e24975e1", true},
+		{"","single.local","10.0.0.1","1024","",false, false, false, false, "Site 'single.local' is already in use on this server.", false, "", false, errs.ErrSiteExists},
+		{"","fail1.local","10.0.0.256","1024","",false, false, false, false, "IP Address not valid: 10.0.0.256", false, "", false, errs.ErrInvalidArgument},
+		{"","fail2.local","10.0.0.1","1023","",false, false, false, false, "Port '1023' is invalid. please specify a port in the following range: 1024-49151", false, "", false, errs.ErrInvalidArgument},
+		{"","fail3.local","10.0.0.1","49152","",false, false, false, false, "Port '49152' is invalid. please specify a port in the following range: 1024-49151", false, "", false, errs.ErrInvalidArgument},
+		{"","fail4.local","10.0.0.1","abcd","",false, false, false, false, "Port 'abcd' is invalid. please specify a port in the following range: 1024-49151", false, "", false, errs.ErrInvalidArgument},
+		{"","fail5.local","10.0.0.1","1024","/uri?a=b",false, false, false, false, "Uri '/uri?a=b' is invalid.A uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", false, "", false, errs.ErrInvalidArgument},
+		{"","fail6.local","10.0.0.1","1024","uri-test",false, false, false, false, "Uri 'uri-test' is invalid.A uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", false, "", false, errs.ErrInvalidArgument},
+		{"fail","fail7.local","10.0.0.1","1024","",false, false, false, false, "Cluster 'fail' does not exist.", false, "", false, errs.ErrClusterNotFound},
+		{"empty","fail8.local","10.0.0.1","1024","",false, false, false, false, "Cluster 'empty' has no assigned nodes.", false, "", false, errs.ErrConflict},
+		{"test1","fail9.local","10.0.0.1","","",false, false, false, false, "no port was specified.", false, "", false, errs.ErrInvalidArgument},
+		{"","pass1.local","10.0.0.1","1024","/uri",false, false, false, false, "Site 'pass1.local' created.", true, "2fe65cf7669d5c171b9aa9770734cebc30055f2d6b548efeac1f0c255aff667c", true, nil},
+		{"","pass2.local","10.0.0.1","","/uri",false, false, true, false, "Site 'pass2.local' created.", true, "c1793490c15b47400a1725bdd9bd87180734e733a1e00b6e533e93f9917102fa", true, nil},
+		{"","pass3.local","10.0.0.1","","",false, false, true, true, "Site 'pass3.local' created.", true, "2a689129d8875ea403d3cd8d755fb9bf5628d2b8806ea47d1ab376133bb6cb71", true, nil},
+		{"test1","pass4.local","10.0.0.1","8080","/uri",false, false, false, false, "Site 'pass4.local' created in cluster 'test1'.", true, "f142358983ee6999fa855155837039205c027833946ee14bed139c3992bc7017", true, nil},
+		{"test1","pass5.local","10.0.0.1","8443","/uri",false, false, true, false, "Site 'pass5.local' created in cluster 'test1'.", true, "5648642a6773dd7269908b4d2d4b0251c67037d93bb3582fa06b87fe8168f179", true, nil},
+		{"test1","pass6.local","10.0.0.1","8443","",false, false, true, true, "Site 'pass6.local' created in cluster 'test1'.", true, "a9e1beb93458df052bc947cbd4449ae6ba65a107c5111fd14b15afa8e24975e1", true, nil},
 	}
 
 	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
@@ -606,7 +734,7 @@ func TestNew(t *testing.T) {
 		os.Stdout = w
 
 		// run function
-		New(test.Cluster,
+		err := New(test.Cluster,
 			test.Hostname,
 			test.IPAddress,
 			test.Port,
@@ -614,7 +742,8 @@ func TestNew(t *testing.T) {
 			test.ssl,
 			test.sslBypassFirewall,
 			test.proxySsl,
-			test.proxySslVerifyOff)
+			test.proxySslVerifyOff,
+			"")
 
 		// revert stdout
 		w.Close()
@@ -624,6 +753,9 @@ func TestNew(t *testing.T) {
 		var buf bytes.Buffer
 		_, _ = buf.ReadFrom(r)
 		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
 
 		// strip newline chars
 		output = strings.ReplaceAll(output, "\n", "")
@@ -633,6 +765,10 @@ func TestNew(t *testing.T) {
 			t.Errorf("Site '%v': Expected '%v', received '%v'", test.Hostname, test.Expected, output)
 		}
 
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+
 		// check file hash
 		if test.CheckFileHash {
 			filePath := GetAvailableConfigDir(test.Cluster) + "/" + test.Hostname + ".conf"
@@ -642,13 +778,274 @@ func TestNew(t *testing.T) {
 			}
 		}
 
+		// check site spec was stored
+		if test.CheckFileHash {
+			spec, specErr := sitespec.Load(test.Hostname)
+			if specErr != nil || spec.Cluster != test.Cluster || spec.Port != test.Port || spec.Uri != test.URI {
+				t.Errorf("Site '%v': Expected stored spec, received '%v' (%v)", test.Hostname, spec, specErr)
+			}
+		}
+
 		// cleanup any files created
 		if test.Cleanup {
 			filePath := GetAvailableConfigDir(test.Cluster) + "/" + test.Hostname + ".conf"
 			os.Remove(filePath)
+			sitespec.Delete(test.Hostname)
 		}
 		
 	}
 
 	os.Clearenv()
 }
+
+func TestUpdate(t *testing.T) {
+	proxySsl := true
+	tests := []struct {
+		Cluster  string
+		Hostname string
+		Changes  SiteChanges
+		Expected string
+		Port     string
+		Uri      string
+		ProxySsl bool
+		Error    error
+	}{
+		{"", "missing.local", SiteChanges{Port: "2048"}, "Site 'missing.local' does not exist.", "", "", false, errs.ErrSiteNotFound},
+		{"", "update1.local", SiteChanges{}, "No changes for 'update1.local'.", "1024", "/uri", false, nil},
+		{"", "update1.local", SiteChanges{IpAddress: "10.0.0.256"}, "IP Address not valid: 10.0.0.256", "1024", "/uri", false, errs.ErrInvalidArgument},
+		{"", "update1.local", SiteChanges{Port: "1023"}, "Port '1023' is invalid. please specify a port in the following range: 1024-49151", "1024", "/uri", false, errs.ErrInvalidArgument},
+		{"", "update1.local", SiteChanges{Uri: "uri-test"}, "Uri 'uri-test' is invalid.A uri must start with a '/' and only contain the following characters: a-z, A-Z, 0-9, /, -, _, ., and ~", "1024", "/uri", false, errs.ErrInvalidArgument},
+		{"", "update1.local", SiteChanges{Port: "2048", Uri: "/new"}, "Site 'update1.local' updated.", "2048", "/new", false, nil},
+		{"", "update1.local", SiteChanges{ProxySsl: &proxySsl}, "Site 'update1.local' updated.", "2048", "/new", true, nil},
+		{"test1", "update2.local", SiteChanges{IpAddress: "10.0.0.2"}, "Site 'update2.local' is in cluster 'test1' and has no IP Address.", "8080", "", false, errs.ErrInvalidArgument},
+		{"", "update2.local", SiteChanges{Port: "8443"}, "Site 'update2.local' updated.", "8443", "", false, nil},
+	}
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent restart
+
+	// create sites to update, discarding output
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	New("", "update1.local", "10.0.0.1", "1024", "/uri", false, false, false, false, "")
+	New("test1", "update2.local", "", "8080", "", false, false, false, false, "")
+	os.Stdout = oldStdout
+
+	for _, test := range tests {
+		// check command ouput
+		// redirect stdout
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		// run function
+		err := Update(test.Cluster, test.Hostname, test.Changes)
+
+		// revert stdout
+		w.Close()
+		os.Stdout = oldStdout
+
+		// collect output to string
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(r)
+		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
+
+		// strip newline chars
+		output = strings.ReplaceAll(output, "\n", "")
+
+		// check output
+		if output != test.Expected {
+			t.Errorf("Site '%v': Expected '%v', received '%v'", test.Hostname, test.Expected, output)
+		}
+
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+
+		if test.Port == "" {
+			continue
+		}
+
+		// check spec and config match
+		spec, specErr := sitespec.Load(test.Hostname)
+		if specErr != nil || spec.Port != test.Port || spec.Uri != test.Uri || spec.ProxySsl != test.ProxySsl {
+			t.Errorf("Site '%v': Expected port '%v' uri '%v', received '%v' (%v)", test.Hostname, test.Port, test.Uri, spec, specErr)
+		}
+
+		configLines, _ := sitespec.Render(spec, loadbalancer.GetClusterNodes(spec.Cluster))
+		fileLines, _ := sslcert.ReadConfigLines(GetSiteConfigPath(spec.Cluster, test.Hostname))
+		if strings.Join(configLines, "\n") != strings.Join(fileLines, "\n") {
+			t.Errorf("Site '%v': config does not match spec", test.Hostname)
+		}
+	}
+
+	// cleanup any files created
+	for _, site := range []struct{ Cluster, Hostname string }{{"", "update1.local"}, {"test1", "update2.local"}} {
+		os.Remove(GetAvailableConfigDir(site.Cluster) + "/" + site.Hostname + ".conf")
+		sitespec.Delete(site.Hostname)
+	}
+
+	os.Clearenv()
+}
+
+func TestTemplates(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent restart
+
+	// discard output
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+
+	tests := []struct {
+		Cluster  string
+		Hostname string
+		Template string
+		Error    error
+	}{
+		{"", "grpc.local", "grpc", nil},
+		{"", "default.local", "Default", nil},
+		{"test1", "grpc2.local", "grpc", errs.ErrInvalidArgument},
+		{"", "static.local", "static", errs.ErrTemplateNotFound},
+	}
+
+	for _, test := range tests {
+		err := New(test.Cluster, test.Hostname, "10.0.0.1", "8080", "", false, false, false, false, test.Template)
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+	}
+
+	// the default is stored as ""
+	if spec, _ := sitespec.Load("default.local"); spec.Template != "" {
+		t.Errorf("Expected default template, received '%v'", spec.Template)
+	}
+
+	templates, err := GetTemplates()
+	if err != nil || len(templates) != 3 {
+		t.Fatalf("Expected 3 templates, received '%v' (%v)", templates, err)
+	}
+	if grpc := templates[1]; grpc.Name != "grpc" || !grpc.Proxy || grpc.K8s || strings.Join(grpc.Sites, ",") != "grpc.local" {
+		t.Errorf("Unexpected template '%v'", grpc)
+	}
+
+	// re-renders keep the template
+	err = Update("", "grpc.local", SiteChanges{Port: "40051"})
+	fileLines, _ := sslcert.ReadConfigLines(GetSiteConfigPath("", "grpc.local"))
+	if err != nil || strings.Join(fileLines, "\n") != "hostname=\"grpc.local\"\ngrpc_pass=\"grpc://10.0.0.1:40051\"\n" {
+		t.Errorf("Expected grpc config, received '%v' (%v)", fileLines, err)
+	}
+
+	err = Update("", "grpc.local", SiteChanges{Template: "default"})
+	if spec, _ := sitespec.Load("grpc.local"); err != nil || spec.Template != "" {
+		t.Errorf("Expected default template, received '%v' (%v)", spec.Template, err)
+	}
+
+	if err := ShowTemplate("static", false); !errors.Is(err, errs.ErrTemplateNotFound) {
+		t.Errorf("Expected '%v', received '%v'", errs.ErrTemplateNotFound, err)
+	}
+
+	os.Stdout = oldStdout
+
+	for _, hostname := range []string{"grpc.local", "default.local"} {
+		os.Remove(GetAvailableConfigDir("") + "/" + hostname + ".conf")
+		sitespec.Delete(hostname)
+	}
+
+	os.Clearenv()
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		Hostname  string
+		ToCluster string
+		IpAddress string
+		Port      string
+		Expected  string
+		Cluster   string // cluster the site should be in afterwards
+		Error     error
+	}{
+		{"missing.local", "test1", "", "8080", "Site 'missing.local' does not exist.", "", errs.ErrSiteNotFound},
+		{"migrate1.local", "fail", "", "8080", "Cluster 'fail' does not exist.", "", errs.ErrClusterNotFound},
+		{"migrate1.local", "empty", "", "8080", "Cluster 'empty' has no assigned nodes.", "", errs.ErrConflict},
+		{"migrate1.local", "", "10.0.0.2", "", "Site 'migrate1.local' is not in a cluster, use 'proxy update --ip' instead.", "", errs.ErrConflict},
+		{"migrate1.local", "test1", "", "1023", "Port '1023' is invalid. please specify a port in the following range: 1024-49151", "", errs.ErrInvalidArgument},
+		{"migrate1.local", "test1", "", "8080", "Site 'migrate1.local' migrated to cluster 'test1'.", "test1", nil},
+		{"migrate1.local", "test1", "", "8080", "Site 'migrate1.local' is already in cluster 'test1'.", "test1", errs.ErrConflict},
+		{"migrate1.local", "test2", "", "8443", "Site 'migrate1.local' migrated to cluster 'test2'.", "test2", nil},
+		{"migrate1.local", "", "10.0.0.256", "", "IP Address not valid: 10.0.0.256", "test2", errs.ErrInvalidArgument},
+		{"migrate1.local", "", "10.0.0.2", "", "Site 'migrate1.local' migrated to '10.0.0.2'.", "", nil},
+	}
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent restart
+
+	// create and enable site to migrate, discarding output
+	oldStdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	New("", "migrate1.local", "10.0.0.1", "1024", "", false, false, false, false, "")
+	Enable("", "migrate1.local")
+	os.Stdout = oldStdout
+
+	for _, test := range tests {
+		// check command ouput
+		// redirect stdout
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		// run function
+		err := Migrate(test.Hostname, test.ToCluster, test.IpAddress, test.Port)
+
+		// revert stdout
+		w.Close()
+		os.Stdout = oldStdout
+
+		// collect output to string
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(r)
+		output := buf.String()
+		if err != nil {
+			output += err.Error()
+		}
+
+		// strip newline chars
+		output = strings.ReplaceAll(output, "\n", "")
+
+		// check output
+		if output != test.Expected {
+			t.Errorf("Site '%v': Expected '%v', received '%v'", test.Hostname, test.Expected, output)
+		}
+
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+		}
+
+		if test.Hostname == "missing.local" {
+			continue
+		}
+
+		// site is only in its new cluster and still enabled
+		if cluster, _ := GetSiteCluster(test.Hostname); cluster != test.Cluster {
+			t.Errorf("Site '%v': Expected cluster '%v', received '%v'", test.Hostname, test.Cluster, cluster)
+		}
+
+		linkPath, _ := os.Readlink(GetEnabledConfigDir() + "/" + test.Hostname + ".conf")
+		if linkPath != GetSiteConfigPath(test.Cluster, test.Hostname) {
+			t.Errorf("Site '%v': Expected link to '%v', received '%v'", test.Hostname, GetSiteConfigPath(test.Cluster, test.Hostname), linkPath)
+		}
+
+		if spec, _ := sitespec.Load(test.Hostname); spec.Cluster != test.Cluster {
+			t.Errorf("Site '%v': Expected spec in cluster '%v', received '%v'", test.Hostname, test.Cluster, spec)
+		}
+	}
+
+	// cleanup any files created
+	os.Remove(GetEnabledConfigDir() + "/migrate1.local.conf")
+	os.Remove(GetSiteConfigPath("", "migrate1.local"))
+	sitespec.Delete("migrate1.local")
+
+	os.Clearenv()
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nickneal.dev/go-proxymanager/loadbalancer"
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+type SslStatus struct {
+	Site    string
+	Cluster string
+	Expires time.Time
+	Status  string
+}
+
+func InstallSsl(hostname string, bypassFirewall bool) error {
+	hostname = strings.ToLower(hostname)
+
+	cluster, found := GetSiteCluster(hostname)
+	if !found {
+		return errs.New(errs.ErrSiteNotFound, "Site '%v' does not exist.", hostname)
+	}
+
+	// http-01 challenges are answered by the site itself
+	if !SiteEnabled(hostname) {
+		return errs.New(errs.ErrConflict, "Site '%v' must be enabled before installing ssl.", hostname)
+	}
+
+	err := installSsl(cluster, hostname, bypassFirewall)
+	if err != nil {
+		return fmt.Errorf("There was an issue installing ssl: %w", err)
+	}
+
+	fmt.Printf("SSL installed for '%v'.\n", hostname)
+
+	return nil
+}
+
+func RenewSsl(hostname string, bypassFirewall bool) error {
+	hostname = strings.ToLower(hostname)
+
+	cluster, found := GetSiteCluster(hostname)
+	if !found {
+		return errs.New(errs.ErrSiteNotFound, "Site '%v' does not exist.", hostname)
+	}
+
+	if !SiteEnabled(hostname) {
+		return errs.New(errs.ErrConflict, "Site '%v' must be enabled before renewing ssl.", hostname)
+	}
+
+	fileLines, err := ssl.ReadConfigLines(GetSiteConfigPath(cluster, hostname))
+	if err != nil {
+		return fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	if !ssl.HasSslConfig(fileLines) {
+		return errs.New(errs.ErrConflict, "SSL is not installed for '%v'.", hostname)
+	}
+
+	certPem, keyPem, err := ssl.Issue(hostname, bypassFirewall || ssl.BypassFirewall(fileLines))
+	if err != nil {
+		return fmt.Errorf("There was an issue renewing ssl: %w", err)
+	}
+
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+	ssl.StageCert(tx, hostname, certPem, keyPem)
+
+	// the previous certificate is put back if nginx rejects the new one
+	err = loadbalancer.Commit(tx, transaction.NginxReload)
+	if err != nil {
+		return fmt.Errorf("There was an issue renewing ssl: %w", err)
+	}
+
+	fmt.Printf("SSL renewed for '%v'.\n", hostname)
+
+	return nil
+}
+
+// serve acme challenges from the site, request a certificate and switch the
+// site to 443. certificate and config are committed together.
+func installSsl(cluster string, hostname string, bypassFirewall bool) error {
+	configPath := GetSiteConfigPath(cluster, hostname)
+	fileLines, err := ssl.ReadConfigLines(configPath)
+	if err != nil {
+		return fmt.Errorf("There was an issue opening/reading file: %w", err)
+	}
+
+	// challenges are answered before a certificate is requested
+	if !ssl.HasAcmeConfig(fileLines) || ssl.BypassFirewall(fileLines) != bypassFirewall {
+		fileLines, err = ssl.AddAcmeConfig(fileLines, hostname, bypassFirewall)
+		if err != nil {
+			return err
+		}
+
+		tx := transaction.New()
+		tx.AddCluster(cluster)
+		tx.AddHost(hostname)
+		tx.Mkdir(filepath.Dir(ssl.GetSnippetPath()), 0755)
+		tx.WriteLines(ssl.GetSnippetPath(), ssl.GetAcmeSnippet(), 0644)
+		tx.WriteLines(configPath, fileLines, 0644)
+
+		err = loadbalancer.Commit(tx, transaction.NginxReload)
+		if err != nil {
+			return err
+		}
+	}
+
+	certPem, keyPem, err := ssl.Issue(hostname, bypassFirewall)
+	if err != nil {
+		return err
+	}
+
+	fileLines, err = ssl.AddSslConfig(fileLines, hostname)
+	if err != nil {
+		return err
+	}
+
+	tx := transaction.New()
+	tx.AddCluster(cluster)
+	tx.AddHost(hostname)
+	ssl.StageCert(tx, hostname, certPem, keyPem)
+	tx.WriteLines(configPath, fileLines, 0644)
+
+	return loadbalancer.Commit(tx, transaction.NginxReload)
+}
+
+// install certificates for sites created with --ssl once they are enabled
+func InstallPendingSsl(cluster string, hostname string) {
+	fileLines, err := ssl.ReadConfigLines(GetSiteConfigPath(cluster, hostname))
+	if err != nil || !ssl.SslPending(fileLines) {
+		return
+	}
+
+	fmt.Printf("Installing ssl certificate for '%v'...\n", hostname)
+	err = installSsl(cluster, hostname, ssl.BypassFirewall(fileLines))
+	if err != nil {
+		fmt.Println("There was an issue installing ssl:", err)
+		return
+	}
+
+	fmt.Printf("SSL installed for '%v'.\n", hostname)
+}
+
+// renew every proxymanager certificate expiring within the configured
+// window, nginx is reloaded once after all renewals. returns an error if
+// any renewal failed.
+func RenewAllSsl(bypassFirewall bool) error {
+	renewBefore := time.Now().AddDate(0, 0, settings.LoadConfig().Ssl.RenewDays)
+
+	clusters, err := GetClusters()
+	if err != nil {
+		return err
+	}
+
+	var output []SslStatus
+	var renewed []int
+	tx := transaction.New()
+	success := true
+	for _, cluster := range append([]string{""}, clusters...) {
+		availableSites, _ := GetAvailableSites(cluster)
+		for _, hostname := range availableSites {
+			fileLines, readErr := ssl.ReadConfigLines(GetSiteConfigPath(cluster, hostname))
+			certPath := ssl.GetConfigCertPath(fileLines)
+			if readErr != nil || certPath == "" {
+				continue
+			}
+
+			status := SslStatus{Site: hostname, Cluster: cluster}
+			status.Expires, err = ssl.GetCertExpiry(certPath)
+
+			switch {
+			case err != nil:
+				status.Status = "failed: " + err.Error()
+				success = false
+			case certPath != ssl.GetCertPath(hostname):
+				// certificate was not issued by proxymanager
+				status.Status = "unmanaged"
+			case status.Expires.After(renewBefore):
+				status.Status = "ok"
+			case !SiteEnabled(hostname):
+				status.Status = "disabled"
+			default:
+				certPem, keyPem, renewErr := ssl.Issue(hostname, bypassFirewall || ssl.BypassFirewall(fileLines))
+				if renewErr != nil {
+					status.Status = "failed: " + renewErr.Error()
+					success = false
+					break
+				}
+
+				tx.AddCluster(cluster)
+				tx.AddHost(hostname)
+				ssl.StageCert(tx, hostname, certPem, keyPem)
+
+				status.Expires, _ = ssl.GetPemExpiry(certPem)
+				status.Status = "renewed"
+				renewed = append(renewed, len(output))
+			}
+
+			output = append(output, status)
+		}
+	}
+
+	// one reload for every renewed certificate, all of them are put back if
+	// nginx rejects one
+	var commitErr error
+	if len(renewed) > 0 {
+		commitErr = loadbalancer.Commit(tx, transaction.NginxReload)
+		if commitErr != nil {
+			for _, index := range renewed {
+				output[index].Status = "failed: rolled back"
+			}
+		}
+	}
+
+	if len(output) == 0 {
+		fmt.Println("No ssl sites available")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	fmt.Fprintln(w, "Site\tCluster\tExpires\tStatus")
+	for _, s := range output {
+		expires := "-"
+		if !s.Expires.IsZero() {
+			expires = s.Expires.Format("2006-01-02")
+		}
+		formattedstring := fmt.Sprintf("%v\t%v\t%v\t%v", s.Site, s.Cluster, expires, s.Status)
+		fmt.Fprintln(w, formattedstring)
+	}
+	w.Flush()
+
+	if commitErr != nil {
+		return commitErr
+	}
+
+	// the table shows which sites failed
+	if !success {
+		return errors.New("Some certificates could not be renewed.")
+	}
+
+	return nil
+}
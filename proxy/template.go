@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"nickneal.dev/go-proxymanager/utils/output"
+	"nickneal.dev/go-proxymanager/utils/sitespec"
+)
+
+// schema of 'template list --output json|yaml'
+type Template struct {
+	Name string `json:"name" yaml:"name"`
+	// usable for normal and k8s sites
+	Proxy bool `json:"proxy" yaml:"proxy"`
+	K8s   bool `json:"k8s" yaml:"k8s"`
+	// sites rendered from the template
+	Sites []string `json:"sites" yaml:"sites"`
+}
+
+// default and named templates with the sites using them
+func GetTemplates() ([]Template, error) {
+	clusters, err := GetClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	templateSites := make(map[string][]string)
+	for _, cluster := range append([]string{""}, clusters...) {
+		sites, _ := GetSites(cluster)
+		for _, site := range sites {
+			if site.HasSpec {
+				templateSites[site.Template] = append(templateSites[site.Template], site.Name)
+			}
+		}
+	}
+
+	templates := []Template{}
+	for _, name := range sitespec.GetTemplateNames() {
+		_, proxyErr := sitespec.GetTemplate(name, false)
+		_, k8sErr := sitespec.GetTemplate(name, true)
+
+		sites := templateSites[name]
+		if sites == nil {
+			sites = []string{}
+		}
+
+		templates = append(templates, Template{Name: name, Proxy: proxyErr == nil, K8s: k8sErr == nil, Sites: sites})
+	}
+
+	return templates, nil
+}
+
+func ListTemplates() error {
+	templates, err := GetTemplates()
+	if err != nil {
+		return err
+	}
+
+	output.Print(templates, func() {
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+		fmt.Fprintln(w, "Template\tProxy\tK8s\tSites")
+		for _, t := range templates {
+			sites := "-"
+			if len(t.Sites) > 0 {
+				sites = strings.Join(t.Sites, ",")
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", t.Name, t.Proxy, t.K8s, sites)
+		}
+		w.Flush()
+	})
+
+	return nil
+}
+
+// print the config of template for normal or k8s sites as it is in
+// proxymanager.yml
+func ShowTemplate(name string, k8s bool) error {
+	name = strings.ToLower(name)
+
+	config, err := sitespec.GetTemplate(name, k8s)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(config)
+	if !strings.HasSuffix(config, "\n") {
+		fmt.Println()
+	}
+
+	return nil
+}
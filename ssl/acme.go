@@ -0,0 +1,232 @@
+package ssl
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+// how long a single certificate request may take
+const acmeTimeout = 5 * time.Minute
+
+func GetAccountKeyPath() string {
+	return settings.LoadConfig().Ssl.CertDir + "/account.key"
+}
+
+func GetChallengeDir() string {
+	return settings.LoadConfig().Ssl.WebRoot + "/.well-known/acme-challenge"
+}
+
+// load the acme account key, creating one on first use
+func LoadAccountKey() (crypto.Signer, error) {
+	keyPath := GetAccountKeyPath()
+
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		return decodeKey(data)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPem, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(keyPath), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(keyPath, keyPem, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func NewClient() (*acme.Client, error) {
+	config := settings.LoadConfig()
+
+	key, err := LoadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: config.Ssl.AcmeDirectory,
+		UserAgent:    "proxymanager",
+	}
+
+	if config.Ssl.AcmeInsecure {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+
+	return client, nil
+}
+
+// request a certificate for hostname using http-01 challenges, challenge
+// responses are written under the webroot served by the acme snippet.
+// returns pem encoded certificate chain and private key.
+func Obtain(hostname string) ([]byte, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+	defer cancel()
+
+	client, err := NewClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// register account, existing accounts are fine
+	account := &acme.Account{}
+	if email := settings.LoadConfig().Ssl.AcmeEmail; email != "" {
+		account.Contact = []string{"mailto:" + email}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(hostname))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		err = authorize(ctx, client, authzURL)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// create certificate key and csr
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hostname},
+		DNSNames: []string{hostname},
+	}, certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certPem []byte
+	for _, b := range der {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+
+	keyPem, err := encodeKey(certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certPem, keyPem, nil
+}
+
+func authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+			break
+		}
+	}
+
+	if challenge == nil {
+		return errors.New("ssl(authorize): no http-01 challenge offered for '" + authz.Identifier.Value + "'")
+	}
+
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+
+	// write challenge response for nginx to serve
+	challengeDir := GetChallengeDir()
+	err = os.MkdirAll(challengeDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	challengePath := challengeDir + "/" + challenge.Token
+	err = os.WriteFile(challengePath, []byte(response), 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(challengePath)
+
+	_, err = client.Accept(ctx, challenge)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodeKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("ssl(decodeKey): no pem data found")
+	}
+
+	return x509.ParseECPrivateKey(block.Bytes)
+}
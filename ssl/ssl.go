@@ -0,0 +1,295 @@
+// certificates for proxymanager sites
+// certificates are requested over acme http-01. the challenge location is
+// added to a site with a snippet include, and the listen/certificate
+// directives are added once a certificate has been issued. both are placed
+// between marker comments directly below the site's server_name.
+package ssl
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"nickneal.dev/go-proxymanager/firewall"
+	"nickneal.dev/go-proxymanager/utils/atomicfile"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+const (
+	acmeBegin = "# PROXYMANAGER ACME BEGIN"
+	acmeEnd   = "# PROXYMANAGER ACME END"
+	sslBegin  = "# PROXYMANAGER SSL BEGIN"
+	sslEnd    = "# PROXYMANAGER SSL END"
+
+	// remembers --ssl-bypass-firewall for certificates issued later
+	bypassFirewallMarker = "# ssl-bypass-firewall"
+)
+
+func GetSnippetPath() string {
+	return settings.LoadConfig().Proxy.NginxDir + "/snippets/proxymanager-acme.conf"
+}
+
+func GetCertPath(hostname string) string {
+	return settings.LoadConfig().Ssl.CertDir + "/" + hostname + "/fullchain.pem"
+}
+
+func GetKeyPath(hostname string) string {
+	return settings.LoadConfig().Ssl.CertDir + "/" + hostname + "/privkey.pem"
+}
+
+// nginx snippet serving acme http-01 challenge responses
+func GetAcmeSnippet() []string {
+	return []string{
+		"# DO NOT EDIT, USE proxymanager",
+		"location ^~ /.well-known/acme-challenge/ {",
+		"    default_type \"text/plain\";",
+		"    root " + settings.LoadConfig().Ssl.WebRoot + ";",
+		"}",
+	}
+}
+
+func ReadConfigLines(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return []string{}, err
+	}
+	defer file.Close()
+
+	var fileLines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fileLines = append(fileLines, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return []string{}, err
+	}
+
+	return fileLines, nil
+}
+
+func WriteConfigLines(filePath string, fileLines []string) error {
+	return atomicfile.WriteLines(filePath, fileLines, 0644)
+}
+
+func hasBlock(fileLines []string, begin string) bool {
+	for _, line := range fileLines {
+		if strings.TrimSpace(line) == begin {
+			return true
+		}
+	}
+
+	return false
+}
+
+func HasAcmeConfig(fileLines []string) bool {
+	return hasBlock(fileLines, acmeBegin)
+}
+
+func HasSslConfig(fileLines []string) bool {
+	return hasBlock(fileLines, sslBegin)
+}
+
+// site was created with --ssl but no certificate was installed yet
+func SslPending(fileLines []string) bool {
+	return HasAcmeConfig(fileLines) && !HasSslConfig(fileLines)
+}
+
+func BypassFirewall(fileLines []string) bool {
+	inBlock := false
+	for _, line := range fileLines {
+		switch strings.TrimSpace(line) {
+		case acmeBegin:
+			inBlock = true
+		case acmeEnd:
+			inBlock = false
+		case bypassFirewallMarker:
+			if inBlock {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// remove marker block (including markers) from config
+func removeBlock(fileLines []string, begin string, end string) []string {
+	var newLines []string
+	inBlock := false
+	for _, line := range fileLines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == begin {
+			inBlock = true
+			continue
+		}
+
+		if inBlock {
+			if trimmed == end {
+				inBlock = false
+			}
+			continue
+		}
+
+		newLines = append(newLines, line)
+	}
+
+	return newLines
+}
+
+// insert block directly below the server_name line for hostname
+func insertBlock(fileLines []string, hostname string, block []string) ([]string, error) {
+	pattern := regexp.MustCompile(`^(\s*)server_name\s+([^;]*);`)
+	for index, line := range fileLines {
+		matches := pattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		for _, name := range strings.Fields(matches[2]) {
+			if name != hostname {
+				continue
+			}
+
+			var indented []string
+			for _, str := range block {
+				indented = append(indented, matches[1]+str)
+			}
+
+			newLines := append([]string{}, fileLines[:index+1]...)
+			newLines = append(newLines, indented...)
+			return append(newLines, fileLines[index+1:]...), nil
+		}
+	}
+
+	return nil, errors.New("ssl(insertBlock): no server_name found for '" + hostname + "'")
+}
+
+func AddAcmeConfig(fileLines []string, hostname string, bypassFirewall bool) ([]string, error) {
+	block := []string{acmeBegin}
+	if bypassFirewall {
+		block = append(block, bypassFirewallMarker)
+	}
+	block = append(block, "include "+GetSnippetPath()+";", acmeEnd)
+
+	return insertBlock(removeBlock(fileLines, acmeBegin, acmeEnd), hostname, block)
+}
+
+func AddSslConfig(fileLines []string, hostname string) ([]string, error) {
+	return AddSslConfigPaths(fileLines, hostname, GetCertPath(hostname), GetKeyPath(hostname))
+}
+
+// AddSslConfig with a certificate and key stored outside of certDir
+func AddSslConfigPaths(fileLines []string, hostname string, certPath string, keyPath string) ([]string, error) {
+	fileLines = removeBlock(fileLines, sslBegin, sslEnd)
+
+	block := []string{sslBegin}
+	// a server without listen directives listens on 80 implicitly, keep
+	// that once 443 is added.
+	hasListen := false
+	for _, line := range fileLines {
+		if regexp.MustCompile(`^\s*listen\s`).MatchString(line) {
+			hasListen = true
+			break
+		}
+	}
+	if !hasListen {
+		block = append(block, "listen 80;")
+	}
+
+	block = append(block,
+		"listen 443 ssl;",
+		"ssl_certificate "+certPath+";",
+		"ssl_certificate_key "+keyPath+";",
+		sslEnd)
+
+	return insertBlock(fileLines, hostname, block)
+}
+
+// opens the firewall for Issue, replaced in tests
+var bypass = firewall.Bypass
+
+// request a certificate, with bypassFirewall port 80 is open to any
+// address while the challenge is answered
+func Issue(hostname string, bypassFirewall bool) (certPem []byte, keyPem []byte, err error) {
+	if bypassFirewall {
+		restore, bypassErr := bypass("80")
+		if bypassErr != nil {
+			return nil, nil, bypassErr
+		}
+		defer func() {
+			if rerr := restore(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+
+	return Obtain(hostname)
+}
+
+// stage key and certificate in tx, on commit either both are replaced or
+// neither is
+func StageCert(tx *transaction.Transaction, hostname string, certPem []byte, keyPem []byte) {
+	tx.Mkdir(filepath.Dir(GetCertPath(hostname)), 0700)
+	tx.WriteFile(GetKeyPath(hostname), keyPem, 0600)
+	tx.WriteFile(GetCertPath(hostname), certPem, 0644)
+}
+
+// certificate file referenced by a site config, "" if none
+func GetConfigCertPath(fileLines []string) string {
+	return getConfigPath(fileLines, "ssl_certificate")
+}
+
+// key file referenced by a site config, "" if none
+func GetConfigKeyPath(fileLines []string) string {
+	return getConfigPath(fileLines, "ssl_certificate_key")
+}
+
+func getConfigPath(fileLines []string, directive string) string {
+	pattern := regexp.MustCompile(`^\s*` + directive + `\s+([^;\s]+)\s*;`)
+	for _, line := range fileLines {
+		matches := pattern.FindStringSubmatch(line)
+		if matches != nil {
+			return matches[1]
+		}
+	}
+
+	return ""
+}
+
+func GetCertExpiry(certPath string) (time.Time, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	expiry, err := GetPemExpiry(data)
+	if err != nil {
+		return time.Time{}, errors.New("ssl(GetCertExpiry): " + err.Error() + " in '" + certPath + "'")
+	}
+
+	return expiry, nil
+}
+
+// expiry of the first certificate in a pem chain
+func GetPemExpiry(data []byte) (time.Time, error) {
+	// first certificate in the chain is the site's
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, errors.New("no pem data found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return cert.NotAfter, nil
+}
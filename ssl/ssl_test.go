@@ -0,0 +1,491 @@
+package ssl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/firewall"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+func Getwd() string {
+	cwd, _ := os.Getwd()
+	return cwd
+}
+
+func GetConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return Getwd() + "\\..\\test_configs\\proxymanager.yml"
+	} else {
+		return Getwd() + "/../test_configs/proxymanager.yml"
+	}
+}
+
+var siteConfig = []string{
+	"server {",
+	"    server_name test.local;",
+	"    location / {",
+	"        proxy_pass http://10.0.0.1;",
+	"    }",
+	"}",
+}
+
+func TestAddAcmeConfig(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	tests := []struct {
+		Hostname       string
+		BypassFirewall bool
+		Expected       []string
+		Error          bool
+	}{
+		{"test.local", false, []string{
+			"server {",
+			"    server_name test.local;",
+			"    # PROXYMANAGER ACME BEGIN",
+			"    include ../test_configs/nginx/snippets/proxymanager-acme.conf;",
+			"    # PROXYMANAGER ACME END",
+		}, false},
+		{"test.local", true, []string{
+			"server {",
+			"    server_name test.local;",
+			"    # PROXYMANAGER ACME BEGIN",
+			"    # ssl-bypass-firewall",
+			"    include ../test_configs/nginx/snippets/proxymanager-acme.conf;",
+			"    # PROXYMANAGER ACME END",
+		}, false},
+		{"fail.local", false, nil, true},
+	}
+
+	for _, test := range tests {
+		output, err := AddAcmeConfig(siteConfig, test.Hostname, test.BypassFirewall)
+		if (err != nil) != test.Error {
+			t.Errorf("'%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+			continue
+		}
+
+		for i := range test.Expected {
+			if output[i] != test.Expected[i] {
+				t.Errorf("'%v': Expected '%v' at index %d but received '%v'", test.Hostname, test.Expected[i], i, output[i])
+			}
+		}
+
+		if test.Error {
+			continue
+		}
+
+		if !SslPending(output) {
+			t.Errorf("'%v': Expected ssl to be pending", test.Hostname)
+		}
+
+		if BypassFirewall(output) != test.BypassFirewall {
+			t.Errorf("'%v': Expected bypass firewall '%v'", test.Hostname, test.BypassFirewall)
+		}
+
+		// adding again replaces the block
+		again, _ := AddAcmeConfig(output, test.Hostname, test.BypassFirewall)
+		if strings.Join(again, "\n") != strings.Join(output, "\n") {
+			t.Errorf("'%v': Expected acme config to be replaced, received '%v'", test.Hostname, again)
+		}
+	}
+
+	os.Clearenv()
+}
+
+func TestAddSslConfig(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+
+	tests := []struct {
+		FileLines []string
+		Expected  []string
+	}{
+		{siteConfig, []string{
+			"server {",
+			"    server_name test.local;",
+			"    # PROXYMANAGER SSL BEGIN",
+			"    listen 80;",
+			"    listen 443 ssl;",
+			"    ssl_certificate ../test_configs/ssl/test.local/fullchain.pem;",
+			"    ssl_certificate_key ../test_configs/ssl/test.local/privkey.pem;",
+			"    # PROXYMANAGER SSL END",
+			"    location / {",
+		}},
+		{[]string{"server {", "  listen 8080;", "  server_name www.test.local test.local;", "}"}, []string{
+			"server {",
+			"  listen 8080;",
+			"  server_name www.test.local test.local;",
+			"  # PROXYMANAGER SSL BEGIN",
+			"  listen 443 ssl;",
+		}},
+	}
+
+	for _, test := range tests {
+		output, err := AddSslConfig(test.FileLines, "test.local")
+		if err != nil {
+			t.Errorf("Error occured %v", err)
+			continue
+		}
+
+		for i := range test.Expected {
+			if output[i] != test.Expected[i] {
+				t.Errorf("Expected '%v' at index %d but received '%v'", test.Expected[i], i, output[i])
+			}
+		}
+
+		if !HasSslConfig(output) || SslPending(output) {
+			t.Errorf("Expected ssl config to be installed, received '%v'", output)
+		}
+	}
+
+	os.Clearenv()
+}
+
+func TestGetConfigCertPath(t *testing.T) {
+	tests := []struct {
+		FileLines []string
+		Expected  string
+	}{
+		{siteConfig, ""},
+		{[]string{"    ssl_certificate_key /ssl/privkey.pem;", "    ssl_certificate /ssl/fullchain.pem;"}, "/ssl/fullchain.pem"},
+		{[]string{"ssl_certificate\t/ssl/fullchain.pem ;"}, "/ssl/fullchain.pem"},
+		{[]string{"#ssl_certificate /ssl/fullchain.pem;"}, ""},
+	}
+
+	for _, test := range tests {
+		if output := GetConfigCertPath(test.FileLines); output != test.Expected {
+			t.Errorf("Expected '%v' for '%v', received '%v'", test.Expected, test.FileLines, output)
+		}
+	}
+}
+
+func TestGetConfigKeyPath(t *testing.T) {
+	tests := []struct {
+		FileLines []string
+		Expected  string
+	}{
+		{siteConfig, ""},
+		{[]string{"    ssl_certificate /ssl/fullchain.pem;", "    ssl_certificate_key /ssl/privkey.pem;"}, "/ssl/privkey.pem"},
+		{[]string{"ssl_certificate /ssl/fullchain.pem;"}, ""},
+	}
+
+	for _, test := range tests {
+		if output := GetConfigKeyPath(test.FileLines); output != test.Expected {
+			t.Errorf("Expected '%v' for '%v', received '%v'", test.Expected, test.FileLines, output)
+		}
+	}
+}
+
+func TestGetCertExpiry(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test.local"},
+		NotBefore:    time.Now(),
+		NotAfter:     notAfter,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	certFile, _ := os.CreateTemp("", "proxymanager-*.pem")
+	_ = pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certFile.Close()
+	defer os.Remove(certFile.Name())
+
+	output, err := GetCertExpiry(certFile.Name())
+	if err != nil || !output.Equal(notAfter) {
+		t.Errorf("Expected '%v', received '%v' (%v)", notAfter, output, err)
+	}
+
+	if _, err := GetCertExpiry(certFile.Name() + ".missing"); err == nil {
+		t.Errorf("Expected error for missing certificate")
+	}
+}
+
+// minimal acme server standing in for pebble. challenges are validated by
+// reading the response from the webroot on disk instead of over http.
+type acmeStandIn struct {
+	sync.Mutex
+	server    *httptest.Server
+	caKey     *ecdsa.PrivateKey
+	caCert    *x509.Certificate
+	token     string
+	validated bool
+	cert      []byte
+}
+
+func (a *acmeStandIn) url(path string) string {
+	return a.server.URL + path
+}
+
+func (a *acmeStandIn) payload(r *http.Request) map[string]string {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&jws)
+
+	data, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	values := map[string]string{}
+	_ = json.Unmarshal(data, &values)
+	return values
+}
+
+func (a *acmeStandIn) order(status string) map[string]interface{} {
+	order := map[string]interface{}{
+		"status":         status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": "test.local"}},
+		"authorizations": []string{a.url("/authz/1")},
+		"finalize":       a.url("/finalize/1"),
+	}
+	if status == "valid" {
+		order["certificate"] = a.url("/cert/1")
+	}
+	return order
+}
+
+func (a *acmeStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	w.Header().Set("Content-Type", "application/json")
+
+	var response interface{}
+	switch r.URL.Path {
+	case "/directory":
+		response = map[string]string{
+			"newNonce":   a.url("/nonce"),
+			"newAccount": a.url("/account"),
+			"newOrder":   a.url("/order"),
+			"revokeCert": a.url("/revoke"),
+			"keyChange":  a.url("/key"),
+		}
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+		return
+	case "/account":
+		w.Header().Set("Location", a.url("/account/1"))
+		w.WriteHeader(http.StatusCreated)
+		response = map[string]string{"status": "valid"}
+	case "/order":
+		w.Header().Set("Location", a.url("/order/1"))
+		w.WriteHeader(http.StatusCreated)
+		response = a.order("pending")
+	case "/order/1":
+		status := "pending"
+		if a.cert != nil {
+			status = "valid"
+		} else if a.validated {
+			status = "ready"
+		}
+		response = a.order(status)
+	case "/authz/1":
+		status := "pending"
+		if a.validated {
+			status = "valid"
+		}
+		response = map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": "test.local"},
+			"challenges": []map[string]string{{"type": "http-01", "url": a.url("/chal/1"), "token": a.token, "status": status}},
+		}
+	case "/chal/1":
+		// check response written for nginx to serve
+		data, err := os.ReadFile(GetChallengeDir() + "/" + a.token)
+		a.validated = err == nil && strings.HasPrefix(string(data), a.token+".")
+		status := "invalid"
+		if a.validated {
+			status = "valid"
+		}
+		response = map[string]string{"type": "http-01", "url": a.url("/chal/1"), "token": a.token, "status": status}
+	case "/finalize/1":
+		der, _ := base64.RawURLEncoding.DecodeString(a.payload(r)["csr"])
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		a.cert, _ = x509.CreateCertificate(rand.Reader, template, a.caCert, csr.PublicKey, a.caKey)
+		response = a.order("valid")
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: a.cert})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: a.caCert.Raw})
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func newAcmeStandIn(t *testing.T) *acmeStandIn {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "proxymanager test ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	standIn := &acmeStandIn{caKey: caKey, caCert: caCert, token: "token1"}
+	standIn.server = httptest.NewTLSServer(standIn)
+	return standIn
+}
+
+func TestObtain(t *testing.T) {
+	standIn := newAcmeStandIn(t)
+	defer standIn.server.Close()
+
+	// point config at stand-in
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	config := settings.LoadConfig()
+	config.Ssl.AcmeDirectory = standIn.url("/directory")
+	config.Ssl.AcmeInsecure = true
+
+	configFile, _ := os.CreateTemp("", "proxymanager-*.yml")
+	configData, _ := yaml.Marshal(config)
+	configFile.Write(configData)
+	configFile.Close()
+	defer os.Remove(configFile.Name())
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", configFile.Name())
+
+	certPem, keyPem, err := Obtain("test.local")
+	if err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	block, rest := pem.Decode(certPem)
+	if block == nil {
+		t.Fatalf("Expected pem certificate, received '%s'", certPem)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || cert.Subject.CommonName != "test.local" {
+		t.Errorf("Expected certificate for 'test.local', received '%v' (%v)", cert, err)
+	}
+
+	if chain, _ := pem.Decode(rest); chain == nil {
+		t.Errorf("Expected certificate chain to be bundled")
+	}
+
+	if _, err := decodeKey(keyPem); err != nil {
+		t.Errorf("Expected valid private key, received error %v", err)
+	}
+
+	// challenge response is removed after validation
+	if _, err := os.Stat(GetChallengeDir() + "/" + standIn.token); err == nil {
+		t.Errorf("Expected challenge response to be removed")
+	}
+
+	// cleanup
+	os.RemoveAll(config.Ssl.CertDir)
+	os.RemoveAll(config.Ssl.WebRoot)
+
+	os.Clearenv()
+}
+
+func TestStageCert(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	config := settings.LoadConfig()
+	config.Ssl.CertDir = t.TempDir()
+
+	configPath := t.TempDir() + "/proxymanager.yml"
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(configPath, configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", configPath)
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true") // set dev mode to prevent reload
+
+	tx := transaction.New()
+	StageCert(tx, "test.local", []byte("cert"), []byte("key"))
+	if err := tx.Commit(transaction.NginxReload); err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	// a failed certificate write puts the key back
+	os.Remove(GetCertPath("test.local"))
+	os.Mkdir(GetCertPath("test.local"), 0755)
+	os.WriteFile(GetCertPath("test.local")+"/file", []byte{}, 0644)
+
+	tx = transaction.New()
+	StageCert(tx, "test.local", []byte("new cert"), []byte("new key"))
+	if err := tx.Commit(transaction.NginxReload); err == nil {
+		t.Errorf("Expected certificate write to fail")
+	}
+
+	if keyData, _ := os.ReadFile(GetKeyPath("test.local")); string(keyData) != "key" {
+		t.Errorf("Expected key to be restored, received '%s'", keyData)
+	}
+
+	os.Clearenv()
+}
+
+// the certificate is issued but a firewall left open is reported
+func TestIssueRestoreFails(t *testing.T) {
+	standIn := newAcmeStandIn(t)
+	defer standIn.server.Close()
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", GetConfigPath())
+	config := settings.LoadConfig()
+	config.Ssl.AcmeDirectory = standIn.url("/directory")
+	config.Ssl.AcmeInsecure = true
+	config.Ssl.CertDir = t.TempDir()
+	config.Ssl.WebRoot = t.TempDir()
+
+	configPath := t.TempDir() + "/proxymanager.yml"
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(configPath, configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", configPath)
+
+	restored := false
+	bypass = func(port string) (func() error, error) {
+		return func() error {
+			restored = true
+			return fmt.Errorf("removal failed")
+		}, nil
+	}
+	defer func() { bypass = firewall.Bypass }()
+
+	if _, _, err := Issue("test.local", true); err == nil || err.Error() != "removal failed" {
+		t.Errorf("Expected 'removal failed', received '%v'", err)
+	}
+	if !restored {
+		t.Errorf("Expected bypass to be removed")
+	}
+
+	os.Clearenv()
+}
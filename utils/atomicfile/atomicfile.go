@@ -0,0 +1,93 @@
+// crash safe file writes
+// data is written to a temp file in the target's dir, synced and renamed
+// over the target, so readers see either the old or the new content and a
+// failed write never leaves a truncated file behind.
+package atomicfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// replaced in tests to simulate failures
+var (
+	writeData = func(file *os.File, data []byte) (int, error) {
+		return file.Write(data)
+	}
+	syncFile = func(file *os.File) error {
+		return file.Sync()
+	}
+	rename = os.Rename
+)
+
+// write data to filePath, keeping mode and owner of an existing file. perm
+// is used for new files.
+func WriteFile(filePath string, data []byte, perm fs.FileMode) error {
+	// replace the target of symlinks, not the link
+	if resolved, err := filepath.EvalSymlinks(filePath); err == nil {
+		filePath = resolved
+	}
+
+	info, statErr := os.Stat(filePath)
+	if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
+		return statErr
+	}
+	if statErr == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(filePath)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	err = writeTemp(file, data, perm, info)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rename(tempPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func writeTemp(file *os.File, data []byte, perm fs.FileMode, info fs.FileInfo) error {
+	_, err := writeData(file, data)
+	if err != nil {
+		return err
+	}
+
+	err = file.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
+	if info != nil {
+		err = chown(file, info)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncFile(file)
+}
+
+// write lines, each terminated by a newline
+func WriteLines(filePath string, fileLines []string, perm fs.FileMode) error {
+	var data strings.Builder
+	for _, str := range fileLines {
+		data.WriteString(str + "\n")
+	}
+
+	return WriteFile(filePath, []byte(data.String()), perm)
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package atomicfile
+
+import (
+	"io/fs"
+	"os"
+)
+
+func chown(file *os.File, info fs.FileInfo) error {
+	return nil
+}
+
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// files in dir other than the target, e.g. leftover temp files
+func ListOthers(t *testing.T, dir string, target string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var others []string
+	for _, e := range entries {
+		if e.Name() != target {
+			others = append(others, e.Name())
+		}
+	}
+
+	return others
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "hosts")
+
+	// new file gets perm
+	err := WriteLines(filePath, []string{"127.0.0.1 localhost", ""}, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(filePath)
+	if string(data) != "127.0.0.1 localhost\n\n" {
+		t.Errorf("Expected lines, received %q", string(data))
+	}
+
+	info, _ := os.Stat(filePath)
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, received %v", info.Mode().Perm())
+	}
+
+	// existing file keeps its mode
+	_ = os.Chmod(filePath, 0600)
+	err = WriteFile(filePath, []byte("replaced\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ = os.ReadFile(filePath)
+	if string(data) != "replaced\n" {
+		t.Errorf("Expected 'replaced', received %q", string(data))
+	}
+
+	info, _ = os.Stat(filePath)
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, received %v", info.Mode().Perm())
+	}
+
+	if others := ListOthers(t, dir, "hosts"); len(others) != 0 {
+		t.Errorf("Expected no temp files, received %v", others)
+	}
+}
+
+func TestWriteFileSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	dir := t.TempDir()
+	targetPath := filepath.Join(dir, "site.conf")
+	linkPath := filepath.Join(dir, "link.conf")
+	_ = os.WriteFile(targetPath, []byte("old\n"), 0644)
+	_ = os.Symlink(targetPath, linkPath)
+
+	err := WriteFile(linkPath, []byte("new\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linkTarget, _ := os.Readlink(linkPath); linkTarget != targetPath {
+		t.Errorf("Expected link to '%v', received '%v'", targetPath, linkTarget)
+	}
+
+	if data, _ := os.ReadFile(targetPath); string(data) != "new\n" {
+		t.Errorf("Expected 'new', received %q", string(data))
+	}
+}
+
+func TestWriteFileFailures(t *testing.T) {
+	failure := errors.New("disk full")
+
+	tests := []struct {
+		Name  string
+		Setup func()
+	}{
+		{"write", func() {
+			writeData = func(file *os.File, data []byte) (int, error) {
+				// partial write before failing
+				n, _ := file.Write(data[:len(data)/2])
+				return n, failure
+			}
+		}},
+		{"sync", func() {
+			syncFile = func(file *os.File) error { return failure }
+		}},
+		{"rename", func() {
+			rename = func(oldPath string, newPath string) error { return failure }
+		}},
+	}
+
+	originalWriteData, originalSyncFile, originalRename := writeData, syncFile, rename
+	defer func() {
+		writeData, syncFile, rename = originalWriteData, originalSyncFile, originalRename
+	}()
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		filePath := filepath.Join(dir, "hosts")
+		_ = os.WriteFile(filePath, []byte("127.0.0.1 localhost\n"), 0644)
+
+		test.Setup()
+		err := WriteFile(filePath, []byte("10.0.0.1 node01.local\n10.0.0.2 node02.local\n"), 0644)
+		writeData, syncFile, rename = originalWriteData, originalSyncFile, originalRename
+
+		if !errors.Is(err, failure) {
+			t.Errorf("Failure '%v': Expected '%v', received '%v'", test.Name, failure, err)
+		}
+
+		// original content is untouched and no temp file is left behind
+		if data, _ := os.ReadFile(filePath); string(data) != "127.0.0.1 localhost\n" {
+			t.Errorf("Failure '%v': Expected original content, received %q", test.Name, string(data))
+		}
+
+		if others := ListOthers(t, dir, "hosts"); len(others) != 0 {
+			t.Errorf("Failure '%v': Expected no temp files, received %v", test.Name, others)
+		}
+	}
+
+	// missing dir fails before anything is written
+	err := WriteFile(filepath.Join(t.TempDir(), "missing", "hosts"), []byte("data\n"), 0644)
+	if err == nil {
+		t.Errorf("Expected error for missing dir")
+	}
+}
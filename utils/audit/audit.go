@@ -0,0 +1,151 @@
+// append-only log of lb and proxy changes
+// every commit appends one JSON line to settings auditLog with who ran which
+// command, what it changed and whether it was applied or rolled back.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+const (
+	OutcomeApplied    = "applied"
+	OutcomeRolledBack = "rolled back"
+	// the change failed and putting files back failed too
+	OutcomeFailed = "failed"
+)
+
+type Entry struct {
+	Time time.Time `json:"time" yaml:"time"`
+	// SUDO_USER when run through sudo
+	User     string   `json:"user" yaml:"user"`
+	Command  string   `json:"command" yaml:"command"`
+	Clusters []string `json:"clusters,omitempty" yaml:"clusters,omitempty"`
+	Hosts    []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Outcome  string   `json:"outcome" yaml:"outcome"`
+	Error    string   `json:"error,omitempty" yaml:"error,omitempty"`
+	// unified diff of the planned changes
+	Diff string `json:"diff,omitempty" yaml:"diff,omitempty"`
+}
+
+// entries matching every set field
+type Filter struct {
+	Since   time.Time
+	Cluster string
+	Host    string
+}
+
+func GetPath() string {
+	return settings.LoadConfig().AuditLog
+}
+
+func Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(GetPath()), 0755)
+	if err != nil {
+		return err
+	}
+
+	// O_APPEND keeps concurrent writers from interleaving lines
+	file, err := os.OpenFile(GetPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+
+	return errors.Join(err, file.Close())
+}
+
+func (f Filter) Match(entry Entry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if f.Cluster != "" && !slices.Contains(entry.Clusters, f.Cluster) {
+		return false
+	}
+
+	if f.Host != "" && !slices.Contains(entry.Hosts, f.Host) {
+		return false
+	}
+
+	return true
+}
+
+// entries matching filter, oldest first. lines that can't be parsed, e.g.
+// one cut short by a crash, are skipped and counted.
+func Read(filter Filter) ([]Entry, int, error) {
+	file, err := os.Open(GetPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	skipped := 0
+
+	scanner := bufio.NewScanner(file)
+	// diffs of big hosts files make long lines
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			skipped++
+			continue
+		}
+
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, skipped, scanner.Err()
+}
+
+// start of an 'audit --since' value: a duration back from now ("90m",
+// "24h", "7d"), a date ("2006-01-02"), a local time ("2006-01-02 15:04") or
+// RFC3339
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		count, err := strconv.Atoi(days)
+		if err == nil && count >= 0 {
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if since, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return since, nil
+		}
+	}
+
+	return time.Time{}, errors.New("audit(ParseSince): invalid time '" + value + "'")
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+func SetTestConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := settings.DefaultConfig()
+	config.AuditLog = dir + "/log/audit.log"
+
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(dir+"/proxymanager.yml", configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+}
+
+func TestAppendRead(t *testing.T) {
+	SetTestConfig(t)
+	defer os.Clearenv()
+
+	start := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: start, User: "alice", Command: "lb prod move prod02.k8s prod01.k8s", Clusters: []string{"prod"}, Hosts: []string{"prod02.k8s", "prod01.k8s"}, Outcome: OutcomeApplied, Diff: "--- hosts\n+++ hosts\n"},
+		{Time: start.Add(time.Hour), User: "bob", Command: "proxy enable a.local", Hosts: []string{"a.local"}, Outcome: OutcomeRolledBack, Error: "nginx -t failed"},
+		{Time: start.Add(2 * time.Hour), User: "alice", Command: "lb prod restore prod02.k8s", Clusters: []string{"prod"}, Hosts: []string{"prod02.k8s"}, Outcome: OutcomeApplied},
+	}
+
+	for _, entry := range entries {
+		if err := Append(entry); err != nil {
+			t.Fatalf("Error occured %v", err)
+		}
+	}
+
+	// a line cut short by a crash doesn't hide the others
+	file, _ := os.OpenFile(GetPath(), os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString("{\"time\":\"2024-05-01T06:00:00Z\",\"us\n")
+	file.Close()
+
+	tests := []struct {
+		Filter   Filter
+		Expected string
+	}{
+		{Filter{}, "lb prod move prod02.k8s prod01.k8s,proxy enable a.local,lb prod restore prod02.k8s"},
+		{Filter{Host: "prod02.k8s"}, "lb prod move prod02.k8s prod01.k8s,lb prod restore prod02.k8s"},
+		{Filter{Host: "prod01.k8s"}, "lb prod move prod02.k8s prod01.k8s"},
+		{Filter{Cluster: "prod", Since: start.Add(time.Minute)}, "lb prod restore prod02.k8s"},
+		{Filter{Cluster: "test"}, ""},
+	}
+
+	for _, test := range tests {
+		output, skipped, err := Read(test.Filter)
+		if err != nil || skipped != 1 {
+			t.Errorf("Filter '%+v': Expected 1 skipped line, received %v (%v)", test.Filter, skipped, err)
+		}
+
+		var commands []string
+		for _, entry := range output {
+			commands = append(commands, entry.Command)
+		}
+		if strings.Join(commands, ",") != test.Expected {
+			t.Errorf("Filter '%+v': Expected '%v', received '%v'", test.Filter, test.Expected, strings.Join(commands, ","))
+		}
+	}
+
+	// entries round trip
+	output, _, _ := Read(Filter{Host: "a.local"})
+	if len(output) != 1 || output[0].Error != "nginx -t failed" || output[0].User != "bob" || !output[0].Time.Equal(entries[1].Time) {
+		t.Errorf("Expected '%+v', received '%+v'", entries[1], output)
+	}
+
+	info, _ := os.Stat(GetPath())
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, received %v", info.Mode().Perm())
+	}
+}
+
+func TestReadMissing(t *testing.T) {
+	SetTestConfig(t)
+	defer os.Clearenv()
+
+	output, skipped, err := Read(Filter{})
+	if len(output) != 0 || skipped != 0 || err != nil {
+		t.Errorf("Expected no entries for missing log, received %v, %v, %v", output, skipped, err)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		Value    string
+		Expected time.Time
+		Error    bool
+	}{
+		{"90m", now.Add(-90 * time.Minute), false},
+		{"24h", now.Add(-24 * time.Hour), false},
+		{"7d", time.Date(2024, 5, 3, 12, 30, 0, 0, time.UTC), false},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-05-01 03:15", time.Date(2024, 5, 1, 3, 15, 0, 0, time.UTC), false},
+		{"2024-05-01T03:15:00Z", time.Date(2024, 5, 1, 3, 15, 0, 0, time.UTC), false},
+		{"-1h", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+		{"d", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		output, err := ParseSince(test.Value, now)
+		if (err != nil) != test.Error || !output.Equal(test.Expected) {
+			t.Errorf("Value '%v': Expected '%v' (error %v), received '%v' (%v)", test.Value, test.Expected, test.Error, output, err)
+		}
+	}
+}
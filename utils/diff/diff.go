@@ -0,0 +1,150 @@
+// unified diffs of file content
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lines of context around changes
+const context = 3
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// split keeping line endings, so a missing newline at the end of the file
+// shows up as a change
+func splitLines(text string) []string {
+	var lines []string
+	for text != "" {
+		index := strings.Index(text, "\n")
+		if index < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:index+1])
+		text = text[index+1:]
+	}
+
+	return lines
+}
+
+// edit script from the longest common subsequence of both sides
+func compare(from []string, to []string) []op {
+	// lengths[i][j] is the lcs length of from[i:] and to[j:]
+	lengths := make([][]int, len(from)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			ops = append(ops, op{' ', from[i]})
+			i++
+			j++
+		case j < len(to) && (i == len(from) || lengths[i][j+1] > lengths[i+1][j]):
+			ops = append(ops, op{'+', to[j]})
+			j++
+		default:
+			ops = append(ops, op{'-', from[i]})
+			i++
+		}
+	}
+
+	return ops
+}
+
+// hunk range, "start,count" with start 0 for an empty side
+func formatRange(start int, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+
+	return fmt.Sprintf("%v,%v", start, count)
+}
+
+// unified diff of from and to, "" if both are equal. fromName/toName label
+// the sides, e.g. "/dev/null" for a created file.
+func Unified(fromName string, toName string, from string, to string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := compare(splitLines(from), splitLines(to))
+
+	var builder strings.Builder
+	builder.WriteString("--- " + fromName + "\n")
+	builder.WriteString("+++ " + toName + "\n")
+
+	// line numbers before each op
+	fromLine, toLine := 1, 1
+	fromLines := make([]int, len(ops))
+	toLines := make([]int, len(ops))
+	for index, o := range ops {
+		fromLines[index], toLines[index] = fromLine, toLine
+		if o.kind != '+' {
+			fromLine++
+		}
+		if o.kind != '-' {
+			toLine++
+		}
+	}
+
+	for index := 0; index < len(ops); {
+		if ops[index].kind == ' ' {
+			index++
+			continue
+		}
+
+		// extend the hunk while changes are close enough to share context
+		start := max(index-context, 0)
+		end := index
+		for next := index; next < len(ops); next++ {
+			if ops[next].kind != ' ' {
+				end = next + 1
+			} else if next-end >= 2*context {
+				break
+			}
+		}
+		end = min(end+context, len(ops))
+
+		fromCount, toCount := 0, 0
+		for _, o := range ops[start:end] {
+			if o.kind != '+' {
+				fromCount++
+			}
+			if o.kind != '-' {
+				toCount++
+			}
+		}
+
+		builder.WriteString(fmt.Sprintf("@@ -%v +%v @@\n", formatRange(fromLines[start], fromCount), formatRange(toLines[start], toCount)))
+		for _, o := range ops[start:end] {
+			builder.WriteString(string(o.kind) + o.line)
+			if !strings.HasSuffix(o.line, "\n") {
+				builder.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		index = end
+	}
+
+	return builder.String()
+}
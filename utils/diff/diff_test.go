@@ -0,0 +1,28 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		Name     string
+		From     string
+		To       string
+		Expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"created", "", "a\nb\n", "--- from\n+++ to\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"removed", "a\n", "", "--- from\n+++ to\n@@ -1 +0,0 @@\n-a\n"},
+		{"changed", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"--- from\n+++ to\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"--- from\n+++ to\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n"},
+		{"no newline", "a\nb", "a\nb\n",
+			"--- from\n+++ to\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+	}
+
+	for _, test := range tests {
+		if output := Unified("from", "to", test.From, test.To); output != test.Expected {
+			t.Errorf("Test '%v': Expected %q, received %q", test.Name, test.Expected, output)
+		}
+	}
+}
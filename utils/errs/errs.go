@@ -0,0 +1,65 @@
+// errors returned by lb, proxy and backup commands
+// the message is what the user sees, the kind tells callers what went wrong
+// through errors.Is, e.g. errors.Is(err, errs.ErrClusterNotFound).
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// bad ip address, hostname, port, uri or cluster name
+	ErrInvalidArgument = errors.New("errs: invalid argument")
+
+	ErrClusterNotFound  = errors.New("errs: cluster not found")
+	ErrHostNotFound     = errors.New("errs: host not found")
+	ErrSiteNotFound     = errors.New("errs: site not found")
+	ErrBackupNotFound   = errors.New("errs: backup not found")
+	ErrTemplateNotFound = errors.New("errs: template not found")
+
+	ErrClusterExists = errors.New("errs: cluster exists")
+	ErrHostExists    = errors.New("errs: host exists")
+	ErrSiteExists    = errors.New("errs: site exists")
+
+	// the current state doesn't allow the change, e.g. removing an enabled
+	// site or a cluster with nodes
+	ErrConflict = errors.New("errs: conflict")
+
+	// 'nginx -t' rejected the config, see nginx.ValidationError
+	ErrNginxValidation = errors.New("errs: nginx validation failed")
+	// applying changes or reloading nginx failed and every change was put
+	// back
+	ErrRolledBack = errors.New("errs: changes rolled back")
+)
+
+type Error struct {
+	Kind    error
+	Message string
+	// underlying error, nil if there is none
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	var unwrapped []error
+	for _, err := range []error{e.Kind, e.Err} {
+		if err != nil {
+			unwrapped = append(unwrapped, err)
+		}
+	}
+
+	return unwrapped
+}
+
+func New(kind error, format string, args ...any) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// message is followed by ": " and err
+func Wrap(kind error, err error, format string, args ...any) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...) + ": " + err.Error(), Err: err}
+}
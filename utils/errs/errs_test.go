@@ -0,0 +1,39 @@
+package errs
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		Err      error
+		Expected string
+		Kinds    []error
+		NotKinds []error
+	}{
+		{New(ErrClusterNotFound, "Cluster '%v' does not exist.", "test3"), "Cluster 'test3' does not exist.", []error{ErrClusterNotFound}, []error{ErrSiteNotFound}},
+		{New(ErrInvalidArgument, "no port was specified."), "no port was specified.", []error{ErrInvalidArgument}, []error{ErrConflict}},
+		{Wrap(ErrConflict, os.ErrNotExist, "There was an issue moving traffic"), "There was an issue moving traffic: file does not exist", []error{ErrConflict, os.ErrNotExist}, []error{ErrRolledBack}},
+		{&Error{Message: "failed", Err: os.ErrPermission}, "failed", []error{os.ErrPermission}, []error{ErrRolledBack}},
+	}
+
+	for _, test := range tests {
+		if test.Err.Error() != test.Expected {
+			t.Errorf("Expected '%v', received '%v'", test.Expected, test.Err.Error())
+		}
+
+		for _, kind := range test.Kinds {
+			if !errors.Is(test.Err, kind) {
+				t.Errorf("'%v': Expected to be '%v'", test.Err, kind)
+			}
+		}
+
+		for _, kind := range test.NotKinds {
+			if errors.Is(test.Err, kind) {
+				t.Errorf("'%v': Expected not to be '%v'", test.Err, kind)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// global proxymanager lock
+// commands that change files hold an advisory lock on settings lockFile.
+// the holder writes its pid, user and command line into the file so a
+// blocked run can say who it is waiting for.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how often a waiting run retries the lock
+const retryInterval = 100 * time.Millisecond
+
+var ErrLocked = errors.New("lock: held by another process")
+
+type Lock struct {
+	file *os.File
+}
+
+// lock is held by another run
+type HeldError struct {
+	Pid     string
+	User    string
+	Command string
+}
+
+func (e *HeldError) Error() string {
+	if e.Pid == "" {
+		return "proxymanager is locked by another process"
+	}
+
+	return fmt.Sprintf("proxymanager is locked by pid %v (user %v) running '%v'", e.Pid, e.User, e.Command)
+}
+
+func (e *HeldError) Unwrap() error {
+	return ErrLocked
+}
+
+// user that started the run, the sudo caller if run with sudo
+func GetUser() string {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		return sudoUser
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+
+	return currentUser.Username
+}
+
+// read holder info written by Acquire
+func ReadHolder(lockPath string) *HeldError {
+	holder := &HeldError{}
+
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return holder
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(string(data)), " ", 3)
+	if len(fields) == 3 {
+		holder.Pid = fields[0]
+		holder.User = fields[1]
+		holder.Command = fields[2]
+	}
+
+	return holder
+}
+
+// acquire lock, retrying for up to wait. returns *HeldError if the lock is
+// still held by another run after wait.
+func Acquire(lockPath string, wait time.Duration) (*Lock, error) {
+	err := os.MkdirAll(filepath.Dir(lockPath), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err = tryLock(file)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+			file.Close()
+			if errors.Is(err, ErrLocked) {
+				return nil, ReadHolder(lockPath)
+			}
+			return nil, err
+		}
+
+		time.Sleep(retryInterval)
+	}
+
+	// record holder for blocked runs
+	holder := strconv.Itoa(os.Getpid()) + " " + GetUser() + " " + strings.Join(os.Args, " ") + "\n"
+	err = file.Truncate(0)
+	if err == nil {
+		_, err = file.WriteAt([]byte(holder), 0)
+	}
+	if err != nil {
+		_ = unlock(file)
+		file.Close()
+		return nil, err
+	}
+
+	return &Lock{file: file}, nil
+}
+
+func (l *Lock) Release() error {
+	// clear holder info before unlocking
+	_ = l.file.Truncate(0)
+
+	err := unlock(l.file)
+	closeErr := l.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
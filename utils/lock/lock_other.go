@@ -0,0 +1,16 @@
+//go:build !unix
+
+package lock
+
+import (
+	"os"
+)
+
+// proxymanager only manages nginx on unix, other platforms run unlocked
+func tryLock(file *os.File) error {
+	return nil
+}
+
+func unlock(file *os.File) error {
+	return nil
+}
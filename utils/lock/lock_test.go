@@ -0,0 +1,81 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("locking is only supported on unix")
+	}
+
+	lockPath := filepath.Join(t.TempDir(), "run", "proxymanager.lock")
+
+	first, err := Acquire(lockPath, 0)
+	if err != nil {
+		t.Fatalf("Expected lock, received %v", err)
+	}
+
+	// second run fails naming the holder
+	_, err = Acquire(lockPath, 0)
+	var held *HeldError
+	if !errors.As(err, &held) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected *HeldError, received %v", err)
+	}
+
+	if held.Pid != strconv.Itoa(os.Getpid()) || held.User != GetUser() || held.Command == "" {
+		t.Errorf("Expected holder pid %v user %v, received '%v'", os.Getpid(), GetUser(), held)
+	}
+
+	// waiting gives up after wait
+	start := time.Now()
+	_, err = Acquire(lockPath, 300*time.Millisecond)
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, received %v", err)
+	}
+	if time.Since(start) < 300*time.Millisecond {
+		t.Errorf("Expected to wait 300ms, waited %v", time.Since(start))
+	}
+
+	// waiting succeeds once the holder releases
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = first.Release()
+	}()
+
+	second, err := Acquire(lockPath, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected lock after release, received %v", err)
+	}
+
+	if err := second.Release(); err != nil {
+		t.Errorf("Expected release, received %v", err)
+	}
+
+	// released lock leaves no holder behind
+	if holder := ReadHolder(lockPath); holder.Pid != "" {
+		t.Errorf("Expected no holder, received '%v'", holder)
+	}
+}
+
+func TestHeldError(t *testing.T) {
+	tests := []struct {
+		Error    HeldError
+		Expected string
+	}{
+		{HeldError{"123", "alice", "proxymanager lb prod move a b"}, "proxymanager is locked by pid 123 (user alice) running 'proxymanager lb prod move a b'"},
+		{HeldError{}, "proxymanager is locked by another process"},
+	}
+
+	for _, test := range tests {
+		if output := test.Error.Error(); output != test.Expected {
+			t.Errorf("Expected '%v', received '%v'", test.Expected, output)
+		}
+	}
+}
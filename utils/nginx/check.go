@@ -0,0 +1,138 @@
+package nginx
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"nickneal.dev/go-proxymanager/utils/errs"
+)
+
+// nginx: [emerg] unknown directive "foo" in /etc/nginx/sites-enabled/a.conf:12
+var checkLinePattern = regexp.MustCompile(`^nginx: \[(\w+)\] (.*?)(?: in (\S+):([0-9]+))?$`)
+
+// single problem reported by 'nginx -t'
+type ConfigError struct {
+	Level   string
+	File    string
+	Line    int
+	Message string
+	// proxymanager site the file belongs to, "" for other files
+	Site string
+	// content of the offending line if it could be read
+	Source string
+}
+
+func (e ConfigError) String() string {
+	location := e.File
+	if e.Line > 0 {
+		location = location + ":" + strconv.Itoa(e.Line)
+	}
+
+	var str string
+	switch {
+	case e.Site != "":
+		str = "site '" + e.Site + "' (" + location + "): " + e.Message
+	case location != "":
+		str = location + ": " + e.Message
+	default:
+		str = e.Message
+	}
+
+	if e.Source != "" {
+		str = str + "\n    " + strconv.Itoa(e.Line) + ": " + e.Source
+	}
+
+	return str
+}
+
+// failed 'nginx -t' with its parsed errors
+type ValidationError struct {
+	Errors []ConfigError
+	Output string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 0 {
+		return "nginx -t failed: " + strings.TrimSpace(e.Output)
+	}
+
+	var lines []string
+	for _, configError := range e.Errors {
+		lines = append(lines, configError.String())
+	}
+
+	return "nginx -t failed:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return errs.ErrNginxValidation
+}
+
+// site name of a config file in sites-enabled, sites-available or one of
+// the k8s_<cluster> dirs
+func GetSiteName(filePath string) (string, bool) {
+	if !strings.HasSuffix(filePath, ".conf") {
+		return "", false
+	}
+
+	dir := filepath.Dir(filePath)
+	if strings.HasPrefix(filepath.Base(dir), "k8s_") {
+		dir = filepath.Dir(dir)
+	}
+
+	switch filepath.Base(dir) {
+	case "sites-enabled", "sites-available":
+		return strings.TrimSuffix(filepath.Base(filePath), ".conf"), true
+	}
+
+	return "", false
+}
+
+func readLine(filePath string, line int) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for index := 1; scanner.Scan(); index++ {
+		if index == line {
+			return strings.TrimSpace(scanner.Text())
+		}
+	}
+
+	return ""
+}
+
+// collect errors from 'nginx -t' output, warnings are skipped
+func ParseCheckOutput(output string) *ValidationError {
+	validationError := &ValidationError{Output: output}
+
+	for _, line := range strings.Split(output, "\n") {
+		matches := checkLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+
+		switch matches[1] {
+		case "warn", "notice", "info", "debug":
+			continue
+		}
+
+		configError := ConfigError{Level: matches[1], Message: matches[2], File: matches[3]}
+		if matches[4] != "" {
+			configError.Line, _ = strconv.Atoi(matches[4])
+			configError.Source = readLine(configError.File, configError.Line)
+		}
+		configError.Site, _ = GetSiteName(configError.File)
+
+		validationError.Errors = append(validationError.Errors, configError)
+	}
+
+	return validationError
+}
@@ -0,0 +1,113 @@
+package nginx
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+func TestGetSiteName(t *testing.T) {
+	tests := []struct {
+		FilePath string
+		Site     string
+		Found    bool
+	}{
+		{"/etc/nginx/sites-enabled/app.local.conf", "app.local", true},
+		{"/etc/nginx/sites-available/app.local.conf", "app.local", true},
+		{"/etc/nginx/sites-available/k8s_prod/app.local.conf", "app.local", true},
+		{"/etc/nginx/nginx.conf", "", false},
+		{"/etc/nginx/conf.d/app.local.conf", "", false},
+		{"/etc/nginx/snippets/proxymanager-acme.conf", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		site, found := GetSiteName(test.FilePath)
+		if site != test.Site || found != test.Found {
+			t.Errorf("File '%v': Expected '%v' (%v), received '%v' (%v)", test.FilePath, test.Site, test.Found, site, found)
+		}
+	}
+}
+
+func TestParseCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "sites-enabled"), 0755)
+	sitePath := filepath.Join(dir, "sites-enabled", "app.local.conf")
+	_ = os.WriteFile(sitePath, []byte("server {\n    server_name app.local;\n    proxy_passs http://10.0.0.1;\n}\n"), 0644)
+
+	output := "nginx: [warn] conflicting server name \"app.local\" on 0.0.0.0:80, ignored\n" +
+		"nginx: [emerg] unknown directive \"proxy_passs\" in " + sitePath + ":3\n" +
+		"nginx: [emerg] open() \"/run/nginx.pid\" failed (13: Permission denied)\n" +
+		"nginx: configuration file /etc/nginx/nginx.conf test failed\n"
+
+	validationError := ParseCheckOutput(output)
+
+	expected := []ConfigError{
+		{Level: "emerg", File: sitePath, Line: 3, Message: "unknown directive \"proxy_passs\"", Site: "app.local", Source: "proxy_passs http://10.0.0.1;"},
+		{Level: "emerg", Message: "open() \"/run/nginx.pid\" failed (13: Permission denied)"},
+	}
+
+	if len(validationError.Errors) != len(expected) {
+		t.Fatalf("Expected %v errors, received %v", len(expected), validationError.Errors)
+	}
+
+	for index, configError := range validationError.Errors {
+		if configError != expected[index] {
+			t.Errorf("Error %v: Expected '%v', received '%v'", index, expected[index], configError)
+		}
+	}
+
+	expectedString := "nginx -t failed:\n" +
+		"  site 'app.local' (" + sitePath + ":3): unknown directive \"proxy_passs\"\n" +
+		"    3: proxy_passs http://10.0.0.1;\n" +
+		"  open() \"/run/nginx.pid\" failed (13: Permission denied)"
+	if validationError.Error() != expectedString {
+		t.Errorf("Expected '%v', received '%v'", expectedString, validationError.Error())
+	}
+
+	// output without parsable errors is passed through
+	validationError = ParseCheckOutput("something went wrong\n")
+	if validationError.Error() != "nginx -t failed: something went wrong" {
+		t.Errorf("Expected raw output, received '%v'", validationError.Error())
+	}
+}
+
+func TestCheckNginxConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake nginx scripts need a posix shell")
+	}
+
+	dir := t.TempDir()
+	binaryPath := filepath.Join(dir, "nginx")
+	_ = os.WriteFile(binaryPath, []byte("#!/bin/sh\necho 'nginx: [emerg] unexpected \"}\" in /etc/nginx/nginx.conf:40' >&2\nexit 1\n"), 0755)
+
+	config := settings.DefaultConfig()
+	config.Nginx.Binary = binaryPath
+	data, _ := yaml.Marshal(config)
+	configPath := filepath.Join(dir, "proxymanager.yml")
+	_ = os.WriteFile(configPath, data, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", configPath)
+
+	err := CheckNginxConfig()
+
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("Expected *ValidationError, received '%v'", err)
+	}
+
+	if !errors.Is(err, errs.ErrNginxValidation) {
+		t.Errorf("Expected '%v', received '%v'", errs.ErrNginxValidation, err)
+	}
+
+	if len(validationError.Errors) != 1 || validationError.Errors[0].File != "/etc/nginx/nginx.conf" || validationError.Errors[0].Line != 40 {
+		t.Errorf("Expected error in /etc/nginx/nginx.conf:40, received '%v'", validationError.Errors)
+	}
+
+	os.Clearenv()
+}
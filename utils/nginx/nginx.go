@@ -1,15 +1,120 @@
+// nginx process control
+// config changes are applied with the reload strategy set in nginx.reload,
+// a failed reload falls back to a full restart.
 package nginx
 
 import (
+	"errors"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"nickneal.dev/go-proxymanager/utils/settings"
 )
 
-// check to make sure nginx config is in good state
+// way of making nginx pick up config changes
+type Reloader interface {
+	Reload() error
+}
+
+// '<binary> -s reload'
+type BinaryReloader struct {
+	Binary string
+}
+
+func (r BinaryReloader) Reload() error {
+	return exec.Command(r.Binary, "-s", "reload").Run()
+}
+
+// '<systemctl> <action> <service>', action is reload or restart
+type SystemctlReloader struct {
+	Systemctl string
+	Service   string
+	Action    string
+}
+
+func (r SystemctlReloader) Reload() error {
+	return exec.Command(r.Systemctl, r.Action, r.Service).Run()
+}
+
+// SIGHUP to the nginx master process
+type SignalReloader struct {
+	PidFile string
+}
+
+func (r SignalReloader) Reload() error {
+	data, err := os.ReadFile(r.PidFile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.New("nginx(SignalReloader): invalid pid in '" + r.PidFile + "'")
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return process.Signal(syscall.SIGHUP)
+}
+
+func GetReloader() (Reloader, error) {
+	config := settings.LoadConfig().Nginx
+
+	switch config.Reload {
+	case "nginx":
+		return BinaryReloader{Binary: config.Binary}, nil
+	case "systemctl", "":
+		return SystemctlReloader{Systemctl: config.Systemctl, Service: config.Service, Action: "reload"}, nil
+	case "signal":
+		return SignalReloader{PidFile: config.PidFile}, nil
+	case "restart":
+		return GetRestarter(), nil
+	}
+
+	return nil, errors.New("nginx(GetReloader): unknown reload strategy '" + config.Reload + "'")
+}
+
+func GetRestarter() Reloader {
+	config := settings.LoadConfig().Nginx
+	return SystemctlReloader{Systemctl: config.Systemctl, Service: config.Service, Action: "restart"}
+}
+
+// check to make sure nginx config is in good state, failures are
+// returned as *ValidationError
 func CheckNginxConfig() error {
-	cmd := exec.Command("nginx", "-t")
-	//cmd.Stdout = os.Stdout
-	//cmd.Stderr = os.Stderr
-	err := cmd.Run()
+	cmd := exec.Command(settings.LoadConfig().Nginx.Binary, "-t")
+	output, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ParseCheckOutput(string(output))
+	}
+
+	return err
+}
+
+// reload nginx with the configured strategy, restarting if that fails
+func ReloadNginx() error {
+	err := CheckNginxConfig()
+	if err != nil {
+		return err
+	}
+
+	reloader, err := GetReloader()
+	if err != nil {
+		return err
+	}
+
+	err = reloader.Reload()
+	if err != nil && settings.LoadConfig().Nginx.Reload != "restart" {
+		return GetRestarter().Reload()
+	}
 
 	return err
 }
@@ -18,12 +123,7 @@ func CheckNginxConfig() error {
 func RestartNginx() error {
 	err := CheckNginxConfig()
 	if err == nil {
-		cmd := exec.Command("systemctl", "restart", "nginx")
-		//cmd.Stdout = os.Stdout
-		//cmd.Stderr = os.Stderr
-		err2 := cmd.Run()
-
-		return err2
+		return GetRestarter().Reload()
 	}
 
 	return err
@@ -0,0 +1,131 @@
+package nginx
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+// fake nginx/systemctl that log their args, systemctl fails on 'reload'
+// when failReload is set
+func SetTestConfig(t *testing.T, reload string, failReload bool) string {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "calls.log")
+
+	script := "#!/bin/sh\necho \"$(basename $0) $*\" >> " + logPath + "\n"
+	systemctlScript := script
+	if failReload {
+		systemctlScript = systemctlScript + "[ \"$1\" = reload ] && exit 1\n"
+	}
+	systemctlScript = systemctlScript + "exit 0\n"
+
+	_ = os.WriteFile(filepath.Join(dir, "nginx"), []byte(script), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "systemctl"), []byte(systemctlScript), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "nginx.pid"), []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+
+	config := settings.DefaultConfig()
+	config.Nginx.Reload = reload
+	config.Nginx.Binary = filepath.Join(dir, "nginx")
+	config.Nginx.Systemctl = filepath.Join(dir, "systemctl")
+	config.Nginx.PidFile = filepath.Join(dir, "nginx.pid")
+
+	data, _ := yaml.Marshal(config)
+	configPath := filepath.Join(dir, "proxymanager.yml")
+	_ = os.WriteFile(configPath, data, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", configPath)
+
+	return logPath
+}
+
+func ReadCalls(logPath string) string {
+	data, _ := os.ReadFile(logPath)
+	return strings.ReplaceAll(strings.TrimSpace(string(data)), "\n", ";")
+}
+
+func TestReloadNginx(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake nginx scripts need a posix shell")
+	}
+
+	tests := []struct {
+		Reload     string
+		FailReload bool
+		Expected   string
+	}{
+		{"nginx", false, "nginx -t;nginx -s reload"},
+		{"systemctl", false, "nginx -t;systemctl reload nginx"},
+		{"", false, "nginx -t;systemctl reload nginx"},
+		{"restart", false, "nginx -t;systemctl restart nginx"},
+		{"systemctl", true, "nginx -t;systemctl reload nginx;systemctl restart nginx"},
+		{"restart", true, "nginx -t;systemctl restart nginx"},
+	}
+
+	for _, test := range tests {
+		logPath := SetTestConfig(t, test.Reload, test.FailReload)
+
+		err := ReloadNginx()
+		if err != nil {
+			t.Errorf("Reload '%v': unexpected error %v", test.Reload, err)
+		}
+
+		if calls := ReadCalls(logPath); calls != test.Expected {
+			t.Errorf("Reload '%v': Expected '%v', received '%v'", test.Reload, test.Expected, calls)
+		}
+	}
+
+	// unknown strategies are rejected before anything runs
+	logPath := SetTestConfig(t, "reboot", false)
+	if err := ReloadNginx(); err == nil {
+		t.Errorf("Reload 'reboot': expected error")
+	}
+	if calls := ReadCalls(logPath); calls != "nginx -t" {
+		t.Errorf("Reload 'reboot': Expected 'nginx -t', received '%v'", calls)
+	}
+
+	os.Clearenv()
+}
+
+func TestSignalReloader(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGHUP is not supported on windows")
+	}
+
+	// the pidfile points at the test process itself
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	logPath := SetTestConfig(t, "signal", false)
+
+	err := ReloadNginx()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	select {
+	case <-hup:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected SIGHUP to pid in pidfile")
+	}
+
+	if calls := ReadCalls(logPath); calls != "nginx -t" {
+		t.Errorf("Expected 'nginx -t', received '%v'", calls)
+	}
+
+	// invalid pidfile
+	_ = os.WriteFile(settings.LoadConfig().Nginx.PidFile, []byte("nginx\n"), 0644)
+	if err := (SignalReloader{PidFile: settings.LoadConfig().Nginx.PidFile}).Reload(); err == nil {
+		t.Errorf("Expected error for invalid pidfile")
+	}
+
+	os.Clearenv()
+}
@@ -0,0 +1,66 @@
+// output format of list and status commands
+// tables are for people, json and yaml print the same data with a stable
+// schema for scripts.
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJson  Format = "json"
+	FormatYaml  Format = "yaml"
+)
+
+// set by --output
+var format = FormatTable
+
+func SetFormat(value string) error {
+	switch Format(value) {
+	case FormatTable, FormatJson, FormatYaml:
+		format = Format(value)
+		return nil
+	}
+
+	return errors.New("output(SetFormat): unknown format '" + value + "', use json, yaml or table")
+}
+
+func GetFormat() Format {
+	return format
+}
+
+func Marshal(data any) (string, error) {
+	switch format {
+	case FormatJson:
+		out, err := json.MarshalIndent(data, "", "  ")
+		return string(out) + "\n", err
+	case FormatYaml:
+		out, err := yaml.Marshal(data)
+		return string(out), err
+	}
+
+	return "", errors.New("output(Marshal): format '" + string(format) + "' has no encoding")
+}
+
+// print data in the selected format, table runs for the table format
+func Print(data any, table func()) {
+	if format == FormatTable {
+		table()
+		return
+	}
+
+	out, err := Marshal(data)
+	if err != nil {
+		fmt.Println("There was an issue formatting output:", err)
+		return
+	}
+
+	fmt.Print(out)
+}
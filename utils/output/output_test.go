@@ -0,0 +1,61 @@
+package output
+
+import (
+	"testing"
+)
+
+func TestSetFormat(t *testing.T) {
+	defer SetFormat("table")
+
+	tests := []struct {
+		Value    string
+		Expected Format
+		Error    bool
+	}{
+		{"json", FormatJson, false},
+		{"yaml", FormatYaml, false},
+		{"table", FormatTable, false},
+		{"xml", FormatTable, true},
+		{"JSON", FormatTable, true},
+	}
+
+	for _, test := range tests {
+		format = FormatTable
+		err := SetFormat(test.Value)
+		if (err != nil) != test.Error || GetFormat() != test.Expected {
+			t.Errorf("Value '%v': Expected '%v' (error %v), received '%v' (%v)", test.Value, test.Expected, test.Error, GetFormat(), err)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	defer SetFormat("table")
+
+	type node struct {
+		Host    string   `json:"host" yaml:"host"`
+		Enabled bool     `json:"enabled" yaml:"enabled"`
+		Extra   []string `json:"extra" yaml:"extra"`
+	}
+	data := []node{{Host: "a.local", Enabled: true, Extra: []string{}}}
+
+	tests := []struct {
+		Format   string
+		Expected string
+	}{
+		{"json", "[\n  {\n    \"host\": \"a.local\",\n    \"enabled\": true,\n    \"extra\": []\n  }\n]\n"},
+		{"yaml", "- host: a.local\n  enabled: true\n  extra: []\n"},
+	}
+
+	for _, test := range tests {
+		SetFormat(test.Format)
+		output, err := Marshal(data)
+		if err != nil || output != test.Expected {
+			t.Errorf("Format '%v': Expected '%v', received '%v' (%v)", test.Format, test.Expected, output, err)
+		}
+	}
+
+	SetFormat("table")
+	if _, err := Marshal(data); err == nil {
+		t.Errorf("Expected error for table format")
+	}
+}
@@ -7,21 +7,77 @@ import (
 )
 
 type Config struct {
+	// held by commands that change files so runs don't overlap
+	LockFile string `yaml:"lockFile"`
+	// json lines of every lb/proxy change, see 'audit'
+	AuditLog string `yaml:"auditLog"`
+
 	LoadBalancer struct {
 		HostsFile string `yaml:"hostsFile"`
+		// removed clusters are archived here with 'lb remove --backup'
+		BackupDir string `yaml:"backupDir"`
 	} `yaml:"loadBalancer"`
 
 	Proxy struct {
 		NginxDir       string `yaml:"nginxDir"`
 		ProxyConfig    string `yaml:"proxyConfig"`
 		K8sProxyConfig string `yaml:"k8sProxyConfig"`
+		// named alternatives to proxyConfig and k8sProxyConfig, see
+		// 'template list'
+		Templates map[string]Template `yaml:"templates"`
+		// stored site inputs used to re-render configs
+		SpecDir string `yaml:"specDir"`
 	} `yaml:"proxy"`
+
+	// snapshots taken before every lb/proxy change
+	Backup struct {
+		Dir string `yaml:"dir"`
+		// newest snapshots to keep, 0 keeps all
+		Keep int `yaml:"keep"`
+	} `yaml:"backup"`
+
+	Nginx struct {
+		// how config changes are applied: nginx, systemctl, signal or restart
+		Reload    string `yaml:"reload"`
+		Binary    string `yaml:"binary"`
+		Systemctl string `yaml:"systemctl"`
+		Service   string `yaml:"service"`
+		PidFile   string `yaml:"pidFile"`
+	} `yaml:"nginx"`
+
+	Firewall struct {
+		Backend   string `yaml:"backend"`
+		RulesFile string `yaml:"rulesFile"`
+		Chain     string `yaml:"chain"`
+	} `yaml:"firewall"`
+
+	Ssl struct {
+		CertDir       string `yaml:"certDir"`
+		WebRoot       string `yaml:"webRoot"`
+		AcmeDirectory string `yaml:"acmeDirectory"`
+		AcmeEmail     string `yaml:"acmeEmail"`
+		// skip tls verification of the acme directory, only for test servers like pebble
+		AcmeInsecure bool `yaml:"acmeInsecure"`
+		// renew certificates expiring within this many days
+		RenewDays int `yaml:"renewDays"`
+	} `yaml:"ssl"`
+}
+
+// site config template, either config may be empty if the template is
+// only meant for normal or k8s sites
+type Template struct {
+	ProxyConfig    string `yaml:"proxyConfig"`
+	K8sProxyConfig string `yaml:"k8sProxyConfig"`
 }
 
 func DefaultConfig() *Config {
 	config := &Config{}
+	config.LockFile = "/run/proxymanager.lock"
+	config.AuditLog = "/var/log/proxymanager/audit.log"
 	config.LoadBalancer.HostsFile = "/etc/hosts"
+	config.LoadBalancer.BackupDir = "/var/lib/proxymanager/backups"
 	config.Proxy.NginxDir = "/etc/nginx"
+	config.Proxy.SpecDir = "/var/lib/proxymanager/sites"
 	config.Proxy.ProxyConfig = `
 	test
 	test2
@@ -32,14 +88,29 @@ func DefaultConfig() *Config {
 	test cause
 	why not
 	`
+	config.Backup.Dir = "/var/lib/proxymanager/snapshots"
+	config.Backup.Keep = 50
+	config.Nginx.Reload = "systemctl"
+	config.Nginx.Binary = "nginx"
+	config.Nginx.Systemctl = "systemctl"
+	config.Nginx.Service = "nginx"
+	config.Nginx.PidFile = "/run/nginx.pid"
+	config.Firewall.Backend = "nftables"
+	config.Firewall.RulesFile = "/etc/proxymanager/firewall.rules"
+	config.Firewall.Chain = "proxymanager"
+	config.Ssl.CertDir = "/etc/proxymanager/ssl"
+	config.Ssl.WebRoot = "/var/lib/proxymanager/acme"
+	config.Ssl.AcmeDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+	config.Ssl.RenewDays = 30
 
 	return config
 
 }
 
 func LoadConfig() *Config {
-	// Create config structure
-	config := &Config{}
+	// Create config structure, starting from defaults so that
+	// sections missing from the file keep sane values
+	config := DefaultConfig()
 
 	// Open config file
 	file, err := os.Open(GetConfigPath())
@@ -52,7 +123,7 @@ func LoadConfig() *Config {
 	d := yaml.NewDecoder(file)
 
 	// Start YAML decoding from file
-	if err := d.Decode(&config); err != nil {
+	if err := d.Decode(config); err != nil {
 		return DefaultConfig()
 	}
 
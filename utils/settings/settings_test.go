@@ -3,6 +3,7 @@ package settings
 import (
 	"os"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/mitchellh/hashstructure/v2"
@@ -72,7 +73,7 @@ func TestLoadConfig(t *testing.T) {
 
 	os.Setenv("PROXYMANAGER_CONFIG_PATH", Getwd()+config_path)
 	get, _ := hashstructure.Hash(LoadConfig(), hashstructure.FormatV2, nil)
-	var want = uint64(1896282237486027739) //test config hash (test_configs/proxymanager.yml)
+	var want = uint64(13992108101889722835) //test config hash (test_configs/proxymanager.yml)
 
 	if get != want {
 		t.Errorf("test proxymanager.yml config returned hash '%d' instead of '%d'", get, want)
@@ -86,10 +87,35 @@ func TestDefaultConfig(t *testing.T) {
 
 	os.Setenv("PROXYMANAGER_CONFIG_PATH", Getwd()+config_path)
 	get, _ := hashstructure.Hash(LoadConfig(), hashstructure.FormatV2, nil)
-	var want = uint64(3297509149356143636) //default config hash
+	var want = uint64(7774724776386746238) //default config hash
 
 	if get != want {
 		t.Errorf("test proxymanager.yml config returned hash '%d' instead of '%d'", get, want)
 	}
 	os.Clearenv()
 }
+
+// comments after a block scalar must not end up in the config
+func TestEtcConfig(t *testing.T) {
+
+	var config_path string
+	if runtime.GOOS == "windows" {
+		config_path = "\\..\\..\\etc\\proxymanager.yml"
+	} else {
+		config_path = "/../../etc/proxymanager.yml"
+	}
+
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", Getwd()+config_path)
+	config := LoadConfig()
+
+	for name, value := range map[string]string{"proxyConfig": config.Proxy.ProxyConfig, "k8sProxyConfig": config.Proxy.K8sProxyConfig} {
+		if !strings.HasSuffix(value, "}\n") {
+			t.Errorf("etc/proxymanager.yml '%v' ends with %q instead of '}'", name, value[strings.LastIndex(value, "}"):])
+		}
+	}
+
+	if len(config.Proxy.Templates) == 0 {
+		t.Errorf("etc/proxymanager.yml has no example templates")
+	}
+	os.Clearenv()
+}
@@ -0,0 +1,118 @@
+package sitespec
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"nickneal.dev/go-proxymanager/ssl"
+)
+
+var (
+	serverNamePattern = regexp.MustCompile(`(?:^|[\s{;])server_name\s+([^;]*);`)
+	proxyPassPattern  = regexp.MustCompile(`(?:^|[\s{;])proxy_pass\s+(https?)://([^/:;\s]+)(:[0-9]+)?(/[^;\s]*)?\s*;`)
+	upstreamPattern   = regexp.MustCompile(`^\s*upstream\s+(\S+)\s*\{`)
+	serverPattern     = regexp.MustCompile(`^\s*server\s+([^:;\s]+):([0-9]+)\s*;`)
+	verifyOffPattern  = regexp.MustCompile(`^\s*proxy_ssl_verify\s+off\s*;`)
+)
+
+// recover a spec from a config generated by proxymanager
+func Import(cluster string, hostname string, fileLines []string) (Spec, error) {
+	spec := Spec{Hostname: hostname, Cluster: cluster}
+
+	hasServerName := false
+	hasProxyPass := false
+	upstreamPorts := make(map[string]string)
+	inUpstream := ""
+	for _, line := range fileLines {
+		if matches := upstreamPattern.FindStringSubmatch(line); matches != nil {
+			inUpstream = matches[1]
+			continue
+		}
+
+		if inUpstream != "" {
+			if strings.Contains(line, "}") {
+				inUpstream = ""
+				continue
+			}
+
+			// all nodes of a cluster share the site's port
+			if matches := serverPattern.FindStringSubmatch(line); matches != nil {
+				upstreamPorts[inUpstream] = matches[2]
+			}
+			continue
+		}
+
+		if matches := serverNamePattern.FindStringSubmatch(line); matches != nil {
+			for _, name := range strings.Fields(matches[1]) {
+				if name == hostname {
+					hasServerName = true
+				}
+			}
+		}
+
+		if matches := proxyPassPattern.FindStringSubmatch(line); matches != nil && !hasProxyPass {
+			hasProxyPass = true
+			spec.ProxySsl = matches[1] == "https"
+			spec.IpAddress = matches[2]
+			spec.Port = strings.TrimPrefix(matches[3], ":")
+			spec.Uri = matches[4]
+		}
+
+		if verifyOffPattern.MatchString(line) {
+			spec.ProxySslVerifyOff = true
+		}
+	}
+
+	if !hasServerName {
+		return spec, errors.New("sitespec(Import): no server_name for '" + hostname + "'")
+	}
+
+	if !hasProxyPass {
+		return spec, errors.New("sitespec(Import): no proxy_pass for '" + hostname + "'")
+	}
+
+	if cluster != "" {
+		// backend of k8s sites is the upstream, the port is on its nodes
+		port, found := upstreamPorts[spec.IpAddress]
+		if !found {
+			return spec, errors.New("sitespec(Import): no upstream '" + spec.IpAddress + "' for '" + hostname + "'")
+		}
+		spec.IpAddress = ""
+		spec.Port = port
+	}
+
+	spec.Ssl = ssl.HasAcmeConfig(fileLines)
+	spec.SslBypassFirewall = ssl.BypassFirewall(fileLines)
+
+	return spec, nil
+}
+
+// sorted nodes of the upstream blocks in a config, without ports
+func GetUpstreamNodes(fileLines []string) []string {
+	var nodes []string
+	inUpstream := false
+	for _, line := range fileLines {
+		if upstreamPattern.MatchString(line) {
+			inUpstream = true
+			continue
+		}
+
+		if !inUpstream {
+			continue
+		}
+
+		if strings.Contains(line, "}") {
+			inUpstream = false
+			continue
+		}
+
+		if matches := serverPattern.FindStringSubmatch(line); matches != nil {
+			nodes = append(nodes, matches[1])
+		}
+	}
+	sort.Strings(nodes)
+
+	return nodes
+}
@@ -0,0 +1,74 @@
+package sitespec
+
+import (
+	"strings"
+	"testing"
+)
+
+var importConfig = []string{
+	"server {",
+	"    server_name app.local;",
+	"    location / {",
+	"        proxy_pass https://10.0.0.1:8443/uri;",
+	"        proxy_ssl_verify off;",
+	"    }",
+	"}",
+}
+
+var importK8sConfig = []string{
+	"upstream 5f1b4a5b6f2c {",
+	"\tserver node01.local:8080;",
+	"\tserver node02.local:8080;",
+	"",
+	"}",
+	"",
+	"server {",
+	"    server_name app.local;",
+	"    # PROXYMANAGER ACME BEGIN",
+	"    # ssl-bypass-firewall",
+	"    include /etc/nginx/snippets/proxymanager-acme.conf;",
+	"    # PROXYMANAGER ACME END",
+	"    location / {",
+	"        proxy_pass http://5f1b4a5b6f2c/uri;",
+	"    }",
+	"}",
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		Cluster   string
+		Hostname  string
+		FileLines []string
+		Expected  Spec
+		Error     bool
+	}{
+		{"", "app.local", importConfig, Spec{Hostname: "app.local", IpAddress: "10.0.0.1", Port: "8443", Uri: "/uri", ProxySsl: true, ProxySslVerifyOff: true}, false},
+		{"prod", "app.local", importK8sConfig, Spec{Hostname: "app.local", Cluster: "prod", Port: "8080", Uri: "/uri", Ssl: true, SslBypassFirewall: true}, false},
+		{"", "app.local", []string{"server { server_name app.local; location / { proxy_pass http://10.0.0.1; } }"}, Spec{Hostname: "app.local", IpAddress: "10.0.0.1"}, false},
+		{"", "other.local", importConfig, Spec{}, true},          // server_name mismatch
+		{"", "app.local", importConfig[:3], Spec{}, true},        // no proxy_pass
+		{"prod", "app.local", importK8sConfig[6:], Spec{}, true}, // upstream missing
+	}
+
+	for _, test := range tests {
+		output, err := Import(test.Cluster, test.Hostname, test.FileLines)
+		if (err != nil) != test.Error {
+			t.Errorf("Site '%v': Expected error '%v', received '%v'", test.Hostname, test.Error, err)
+			continue
+		}
+
+		if !test.Error && output != test.Expected {
+			t.Errorf("Site '%v': Expected '%v', received '%v'", test.Hostname, test.Expected, output)
+		}
+	}
+}
+
+func TestGetUpstreamNodes(t *testing.T) {
+	if nodes := strings.Join(GetUpstreamNodes(importK8sConfig), ","); nodes != "node01.local,node02.local" {
+		t.Errorf("Expected 'node01.local,node02.local', received '%v'", nodes)
+	}
+
+	if nodes := GetUpstreamNodes(importConfig); len(nodes) != 0 {
+		t.Errorf("Expected no nodes, received '%v'", nodes)
+	}
+}
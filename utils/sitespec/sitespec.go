@@ -0,0 +1,322 @@
+// stored inputs of proxymanager sites
+// every site created by 'proxy new' has a spec saved under proxy.specDir so
+// its config can be rendered again, e.g. when the nodes of its cluster change.
+package sitespec
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/atomicfile"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+type Spec struct {
+	Hostname          string `yaml:"hostname"`
+	Cluster           string `yaml:"cluster,omitempty"`
+	IpAddress         string `yaml:"ipAddress,omitempty"`
+	Port              string `yaml:"port,omitempty"`
+	Uri               string `yaml:"uri,omitempty"`
+	Ssl               bool   `yaml:"ssl"`
+	SslBypassFirewall bool   `yaml:"sslBypassFirewall"`
+	ProxySsl          bool   `yaml:"proxySsl"`
+	ProxySslVerifyOff bool   `yaml:"proxySslVerifyOff"`
+	// named template from proxy.templates, "" for the default
+	Template string `yaml:"template,omitempty"`
+}
+
+// name of proxyConfig and k8sProxyConfig in 'template list'
+const DefaultTemplate = "default"
+
+func GetSpecDir() string {
+	return settings.LoadConfig().Proxy.SpecDir
+}
+
+func GetSpecPath(hostname string) string {
+	return GetSpecDir() + "/" + hostname + ".yml"
+}
+
+func GetConfigDir(cluster string) string {
+	if cluster != "" {
+		return settings.LoadConfig().Proxy.NginxDir + "/sites-available/k8s_" + cluster
+	}
+
+	return settings.LoadConfig().Proxy.NginxDir + "/sites-available"
+}
+
+func GetConfigPath(cluster string, hostname string) string {
+	return GetConfigDir(cluster) + "/" + hostname + ".conf"
+}
+
+func Exists(hostname string) bool {
+	_, err := os.Stat(GetSpecPath(hostname))
+	return err == nil
+}
+
+func Load(hostname string) (Spec, error) {
+	var spec Spec
+
+	data, err := os.ReadFile(GetSpecPath(hostname))
+	if err != nil {
+		return spec, err
+	}
+
+	err = yaml.Unmarshal(data, &spec)
+	return spec, err
+}
+
+func Marshal(spec Spec) ([]byte, error) {
+	return yaml.Marshal(spec)
+}
+
+func Save(spec Spec) error {
+	data, err := Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(GetSpecDir(), 0755)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(GetSpecPath(spec.Hostname), data, 0644)
+}
+
+// stage writing the spec as part of tx
+func Stage(tx *transaction.Transaction, spec Spec) error {
+	data, err := Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	tx.Mkdir(GetSpecDir(), 0755)
+	tx.WriteFile(GetSpecPath(spec.Hostname), data, 0644)
+	return nil
+}
+
+func Delete(hostname string) error {
+	err := os.Remove(GetSpecPath(hostname))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// k8s sites use the md5 of their hostname as upstream name
+func GetUpstreamName(hostname string) string {
+	hash := md5.Sum([]byte(hostname))
+	return hex.EncodeToString(hash[:])
+}
+
+// config of template name for normal or k8s sites, "" and "default" are
+// proxyConfig and k8sProxyConfig
+func GetTemplate(name string, k8s bool) (string, error) {
+	proxyConfig := settings.LoadConfig().Proxy
+	template := settings.Template{ProxyConfig: proxyConfig.ProxyConfig, K8sProxyConfig: proxyConfig.K8sProxyConfig}
+
+	if name == "" {
+		name = DefaultTemplate
+	}
+
+	if name != DefaultTemplate {
+		var found bool
+		template, found = proxyConfig.Templates[name]
+		if !found {
+			return "", errs.New(errs.ErrTemplateNotFound, "Template '%v' does not exist.", name)
+		}
+	}
+
+	config := template.ProxyConfig
+	if k8s {
+		config = template.K8sProxyConfig
+	}
+
+	if config == "" {
+		kind := "normal"
+		if k8s {
+			kind = "k8s"
+		}
+		return "", errs.New(errs.ErrInvalidArgument, "Template '%v' has no config for %v sites.", name, kind)
+	}
+
+	return config, nil
+}
+
+// names of the default and every named template, sorted after the default
+func GetTemplateNames() []string {
+	var names []string
+	for name := range settings.LoadConfig().Proxy.Templates {
+		if name != DefaultTemplate {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return append([]string{DefaultTemplate}, names...)
+}
+
+// render site config from spec, nodes are only used for k8s sites
+func Render(spec Spec, nodes []string) ([]string, error) {
+	ipAddress := spec.IpAddress
+
+	config, err := GetTemplate(spec.Template, spec.Cluster != "")
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Cluster != "" {
+		ipAddress = GetUpstreamName(spec.Hostname)
+
+		// sort nodes so renders are stable
+		sortedNodes := append([]string{}, nodes...)
+		sort.Strings(sortedNodes)
+
+		var upstreamNodes string
+		for _, str := range sortedNodes {
+			upstreamNodes = upstreamNodes + "\tserver " + str + ":" + spec.Port + ";\n"
+		}
+
+		config = strings.Replace(config, "%UPSTREAM_NAME%", ipAddress, 1)
+		config = strings.Replace(config, "%UPSTREAM_NODES%", upstreamNodes, 1)
+	}
+
+	// config params
+	address := ipAddress
+	if spec.Cluster == "" && spec.Port != "" {
+		address = address + ":" + spec.Port
+	}
+
+	backend := "http://" + address
+	if spec.ProxySsl {
+		backend = "https://" + address
+	}
+
+	if spec.Uri != "" {
+		backend = backend + spec.Uri
+	}
+
+	verifyBackendSsl := ""
+	if spec.ProxySsl && spec.ProxySslVerifyOff {
+		verifyBackendSsl = "proxy_ssl_verify off;"
+	}
+
+	// perpare config
+	config = strings.Replace(config, "%HOSTNAME%", spec.Hostname, 1)
+	config = strings.Replace(config, "%BACKEND%", backend, 1)
+	config = strings.Replace(config, "%BACKEND_ADDRESS%", address, 1)
+	config = strings.Replace(config, "%PROXY_SSL_VERIFY_OFF%", verifyBackendSsl, 1)
+
+	configLines := strings.Split(config, "\n")
+
+	// keep serving acme challenges and the issued certificate. certificates
+	// stored elsewhere are kept as the current config references them.
+	certPath, keyPath := ssl.GetCertPath(spec.Hostname), ssl.GetKeyPath(spec.Hostname)
+	_, certErr := os.Stat(certPath)
+	certInstalled := certErr == nil
+	if !certInstalled {
+		currentCert, currentKey := currentCertPaths(spec.Hostname)
+		if currentCert != "" && currentCert != certPath {
+			if currentKey == "" {
+				return nil, errs.New(errs.ErrConflict, "Site '%v' uses certificate '%v' without a key, its config can't be rendered again.", spec.Hostname, currentCert)
+			}
+			certPath, keyPath, certInstalled = currentCert, currentKey, true
+		}
+	}
+
+	if spec.Ssl || certInstalled {
+		var err error
+		configLines, err = ssl.AddAcmeConfig(configLines, spec.Hostname, spec.SslBypassFirewall)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if certInstalled {
+		var err error
+		configLines, err = ssl.AddSslConfigPaths(configLines, spec.Hostname, certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return configLines, nil
+}
+
+// certificate and key of the current config of hostname in any cluster,
+// "" if it has none
+func currentCertPaths(hostname string) (string, string) {
+	configPaths, _ := filepath.Glob(GetConfigDir("") + "/k8s_*/" + hostname + ".conf")
+	configPaths = append([]string{GetConfigPath("", hostname)}, configPaths...)
+
+	for _, configPath := range configPaths {
+		fileLines, err := ssl.ReadConfigLines(configPath)
+		if err != nil {
+			continue
+		}
+
+		if certPath := ssl.GetConfigCertPath(fileLines); certPath != "" {
+			return certPath, ssl.GetConfigKeyPath(fileLines)
+		}
+	}
+
+	return "", ""
+}
+
+// re-render every site in cluster that has a spec. returns the new lines of
+// each config that changed by path, nothing is written.
+func RenderCluster(cluster string, nodes []string) (map[string][]string, error) {
+	configs := make(map[string][]string)
+
+	entries, err := os.ReadDir(GetConfigDir(cluster))
+	if err != nil {
+		// cluster without config dir has no sites
+		if errors.Is(err, os.ErrNotExist) {
+			return configs, nil
+		}
+		return configs, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".conf") {
+			continue
+		}
+
+		hostname := strings.TrimSuffix(e.Name(), ".conf")
+		spec, specErr := Load(hostname)
+		if specErr != nil {
+			fmt.Printf("Site '%v' has no stored spec, upstreams not updated.\n", hostname)
+			continue
+		}
+
+		configLines, renderErr := Render(spec, nodes)
+		if renderErr != nil {
+			return configs, renderErr
+		}
+
+		configPath := GetConfigPath(cluster, hostname)
+		currentLines, readErr := ssl.ReadConfigLines(configPath)
+		if readErr != nil {
+			return configs, readErr
+		}
+
+		if strings.Join(currentLines, "\n") == strings.Join(configLines, "\n") {
+			continue
+		}
+
+		configs[configPath] = configLines
+	}
+
+	return configs, nil
+}
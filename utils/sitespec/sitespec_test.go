@@ -0,0 +1,224 @@
+package sitespec
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/ssl"
+	"nickneal.dev/go-proxymanager/utils/errs"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+func Getwd() string {
+	cwd, _ := os.Getwd()
+	return cwd
+}
+
+func BuildFilePath(filePath string) string {
+	if runtime.GOOS == "windows" {
+		filePath = strings.ReplaceAll(filePath, "/", "\\")
+		return Getwd() + "\\..\\..\\" + filePath
+	} else {
+		return Getwd() + "/../../" + filePath
+	}
+}
+
+// test config paths are relative to top level packages, write a copy
+// with paths that resolve from this package.
+func SetTestConfig(t *testing.T) {
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", BuildFilePath("test_configs/proxymanager.yml"))
+	config := settings.LoadConfig()
+	config.Proxy.NginxDir = BuildFilePath("test_configs/nginx")
+	config.Proxy.SpecDir = BuildFilePath("test_configs/specs")
+	config.Ssl.CertDir = BuildFilePath("test_configs/ssl")
+
+	configData, _ := yaml.Marshal(config)
+	configPath := t.TempDir() + "/proxymanager.yml"
+	os.WriteFile(configPath, configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", configPath)
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		Spec     Spec
+		Nodes    []string
+		Expected string
+	}{
+		{Spec{Hostname: "a.local", IpAddress: "10.0.0.1", Port: "8080", Uri: "/uri"},
+			nil,
+			"hostname=\"a.local\"\nbackend=\"http://10.0.0.1:8080/uri\"\nproxy_verify=\"\"\n"},
+		{Spec{Hostname: "a.local", IpAddress: "10.0.0.1", ProxySsl: true, ProxySslVerifyOff: true},
+			nil,
+			"hostname=\"a.local\"\nbackend=\"https://10.0.0.1\"\nproxy_verify=\"proxy_ssl_verify off;\"\n"},
+		{Spec{Hostname: "a.local", Cluster: "test1", Port: "8080"},
+			[]string{"node02.local", "node01.local"},
+			"upstreamn_name=\"" + GetUpstreamName("a.local") + "\"\nupstream_nodes={\n\tserver node01.local:8080;\n\tserver node02.local:8080;\n\n}\nhostname=\"a.local\"\nbackend=\"http://" + GetUpstreamName("a.local") + "\"\nproxy_verify=\"\""},
+		{Spec{Hostname: "a.local", IpAddress: "10.0.0.1", Port: "50051", Uri: "/uri", Template: "grpc"},
+			nil,
+			"hostname=\"a.local\"\ngrpc_pass=\"grpc://10.0.0.1:50051\"\n"},
+		{Spec{Hostname: "a.local", Cluster: "test1", Port: "8080", Template: "websocket"},
+			[]string{"node01.local"},
+			"upstreamn_name=\"" + GetUpstreamName("a.local") + "\"\nupstream_nodes={\n\tserver node01.local:8080;\n\n}\nhostname=\"a.local\"\nbackend=\"http://" + GetUpstreamName("a.local") + "\"\nupgrade=\"websocket\"\n"},
+	}
+
+	SetTestConfig(t)
+
+	for _, test := range tests {
+		output, err := Render(test.Spec, test.Nodes)
+		if err != nil {
+			t.Errorf("Site '%v': Error occured %v", test.Spec.Hostname, err)
+			continue
+		}
+
+		if strings.Join(output, "\n") != test.Expected {
+			t.Errorf("Site '%v': Expected '%v', received '%v'", test.Spec.Hostname, test.Expected, strings.Join(output, "\n"))
+		}
+	}
+
+	os.Clearenv()
+}
+
+func TestGetTemplate(t *testing.T) {
+	SetTestConfig(t)
+
+	tests := []struct {
+		Name  string
+		K8s   bool
+		Error error
+	}{
+		{"", false, nil},
+		{"default", true, nil},
+		{"grpc", false, nil},
+		{"grpc", true, errs.ErrInvalidArgument},
+		{"static", false, errs.ErrTemplateNotFound},
+	}
+
+	for _, test := range tests {
+		_, err := GetTemplate(test.Name, test.K8s)
+		if !errors.Is(err, test.Error) {
+			t.Errorf("Template '%v' k8s %v: Expected '%v', received '%v'", test.Name, test.K8s, test.Error, err)
+		}
+	}
+
+	if names := strings.Join(GetTemplateNames(), ","); names != "default,grpc,websocket" {
+		t.Errorf("Expected 'default,grpc,websocket', received '%v'", names)
+	}
+
+	os.Clearenv()
+}
+
+func TestSaveLoadDelete(t *testing.T) {
+	SetTestConfig(t)
+
+	spec := Spec{Hostname: "spec.local", Cluster: "test1", Port: "8080", Uri: "/uri", ProxySsl: true}
+	if err := Save(spec); err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	output, err := Load(spec.Hostname)
+	if err != nil || output != spec {
+		t.Errorf("Expected '%v', received '%v' (%v)", spec, output, err)
+	}
+
+	if err := Delete(spec.Hostname); err != nil || Exists(spec.Hostname) {
+		t.Errorf("Expected spec to be deleted (%v)", err)
+	}
+
+	// deleting a missing spec is not an error
+	if err := Delete(spec.Hostname); err != nil {
+		t.Errorf("Expected no error deleting missing spec, received %v", err)
+	}
+
+	os.RemoveAll(GetSpecDir())
+	os.Clearenv()
+}
+
+func TestRenderCluster(t *testing.T) {
+	SetTestConfig(t)
+
+	spec := Spec{Hostname: "regen.local", Cluster: "test2", Port: "8080"}
+	configPath := GetConfigPath(spec.Cluster, spec.Hostname)
+	staleLines := []string{"stale"}
+
+	Save(spec)
+	ssl.WriteConfigLines(configPath, staleLines)
+
+	nodes := []string{"app01.local", "app02.local"}
+	configs, err := RenderCluster(spec.Cluster, nodes)
+	if err != nil {
+		t.Errorf("Error occured %v", err)
+	}
+
+	// config is re-rendered for nodes
+	want, _ := Render(spec, nodes)
+	if strings.Join(configs[configPath], "\n") != strings.Join(want, "\n") || len(configs) != 1 {
+		t.Errorf("Expected '%v', received '%v'", want, configs)
+	}
+
+	// nothing is written
+	get, _ := ssl.ReadConfigLines(configPath)
+	if strings.Join(get, "\n") != strings.Join(staleLines, "\n") {
+		t.Errorf("Expected '%v' to be untouched, received '%v'", staleLines, get)
+	}
+
+	// unchanged configs are not returned
+	ssl.WriteConfigLines(configPath, want)
+	if again, _ := RenderCluster(spec.Cluster, nodes); len(again) != 0 {
+		t.Errorf("Expected no changes on second render, received '%v'", again)
+	}
+
+	// cleanup
+	os.Remove(configPath)
+	os.RemoveAll(GetSpecDir())
+	os.Clearenv()
+}
+
+// certificates outside of certDir stay in the config, wherever the site is
+func TestRenderCertElsewhere(t *testing.T) {
+	dir := t.TempDir()
+	SetTestConfig(t)
+	config := settings.LoadConfig()
+	config.Proxy.NginxDir = dir + "/nginx"
+	config.Proxy.ProxyConfig = "server {\n\tserver_name %HOSTNAME%;\n}"
+	config.Proxy.K8sProxyConfig = "server {\n\tserver_name %HOSTNAME%;\n}"
+	config.Ssl.CertDir = dir + "/ssl"
+
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(dir+"/proxymanager.yml", configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+	os.MkdirAll(GetConfigDir("test1"), 0755)
+
+	spec := Spec{Hostname: "a.local", IpAddress: "10.0.0.1"}
+	ssl.WriteConfigLines(GetConfigPath("test1", spec.Hostname), []string{"server {", "\tserver_name a.local;",
+		"\tssl_certificate /etc/certs/a.pem;", "\tssl_certificate_key /etc/certs/a.key;", "}"})
+
+	// migrated out of test1
+	configLines, err := Render(spec, nil)
+	if err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+	configStr := strings.Join(configLines, "\n")
+	if !strings.Contains(configStr, "ssl_certificate /etc/certs/a.pem;") || !strings.Contains(configStr, "ssl_certificate_key /etc/certs/a.key;") {
+		t.Errorf("Expected certificate to be kept, received '%v'", configStr)
+	}
+
+	// a certificate without a key isn't guessed
+	ssl.WriteConfigLines(GetConfigPath("test1", spec.Hostname), []string{"server {", "\tserver_name a.local;",
+		"\tssl_certificate /etc/certs/a.pem;", "}"})
+	if _, err := Render(spec, nil); !errors.Is(err, errs.ErrConflict) {
+		t.Errorf("Expected '%v', received '%v'", errs.ErrConflict, err)
+	}
+
+	// the managed path isn't kept once its certificate is gone
+	ssl.WriteConfigLines(GetConfigPath("test1", spec.Hostname), []string{"server {", "\tserver_name a.local;",
+		"\tssl_certificate " + ssl.GetCertPath(spec.Hostname) + ";", "\tssl_certificate_key " + ssl.GetKeyPath(spec.Hostname) + ";", "}"})
+	if configLines, _ := Render(spec, nil); strings.Contains(strings.Join(configLines, "\n"), "ssl_certificate") {
+		t.Errorf("Expected no certificate, received '%v'", configLines)
+	}
+
+	os.Clearenv()
+}
@@ -0,0 +1,375 @@
+// copies of the hosts file, nginx site dirs and site specs
+// a snapshot is taken before every lb/proxy change and kept in backup.dir,
+// named by its creation time. only the newest backup.keep snapshots stay.
+package snapshot
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/lock"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+const infoFile = "snapshot.yml"
+
+type Info struct {
+	Id      string    `yaml:"-"`
+	Created time.Time `yaml:"created"`
+	User    string    `yaml:"user"`
+	// command the snapshot was taken for
+	Command string `yaml:"command"`
+}
+
+// a copied path, Name is the path inside the snapshot
+type source struct {
+	Name string
+	Path string
+}
+
+func GetDir() string {
+	return settings.LoadConfig().Backup.Dir
+}
+
+func GetPath(id string) string {
+	return GetDir() + "/" + id
+}
+
+// paths kept in every snapshot
+func getSources() []source {
+	config := settings.LoadConfig()
+
+	return []source{
+		{"hosts", config.LoadBalancer.HostsFile},
+		{"sites-available", config.Proxy.NginxDir + "/sites-available"},
+		{"sites-enabled", config.Proxy.NginxDir + "/sites-enabled"},
+		{"specs", config.Proxy.SpecDir},
+	}
+}
+
+// copy a file, symlink or dir tree, links are copied as links
+func copyTree(sourcePath string, destinationPath string) error {
+	return filepath.WalkDir(sourcePath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(sourcePath, filePath)
+		target := filepath.Join(destinationPath, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, linkErr := os.Readlink(filePath)
+			if linkErr != nil {
+				return linkErr
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		default:
+			data, readErr := os.ReadFile(filePath)
+			if readErr != nil {
+				return readErr
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		}
+	})
+}
+
+// take a snapshot of the current state and rotate old ones, returns its id
+func Create(command string) (string, error) {
+	err := os.MkdirAll(GetDir(), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	// snapshots taken within the same second get a suffix above the
+	// latest one, even if older ones were rotated away
+	created := time.Now()
+	id := created.Format("20060102-150405")
+	ids, err := List()
+	if err != nil {
+		return "", err
+	}
+	count := -1
+	for _, existing := range ids {
+		existingTime, existingCount := splitId(existing)
+		if existingTime == id {
+			count = max(count, existingCount)
+		}
+	}
+	if count >= 0 {
+		id += "-" + strconv.Itoa(count+1)
+	}
+
+	// copy into a hidden dir first so partial snapshots are never listed
+	tempPath := GetDir() + "/." + id + ".tmp"
+	err = createIn(tempPath, Info{Created: created, User: lock.GetUser(), Command: command})
+	if err == nil {
+		err = os.Rename(tempPath, GetPath(id))
+	}
+	if err != nil {
+		os.RemoveAll(tempPath)
+		return "", err
+	}
+
+	return id, Rotate()
+}
+
+func createIn(dir string, info Info) error {
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range getSources() {
+		copyErr := copyTree(s.Path, filepath.Join(dir, s.Name))
+		// missing dirs, e.g. specs before the first site, are left out
+		if copyErr != nil && !errors.Is(copyErr, os.ErrNotExist) {
+			return copyErr
+		}
+	}
+
+	data, err := yaml.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, infoFile), data, 0600)
+}
+
+// snapshot ids, oldest first
+func List() ([]string, error) {
+	entries, err := os.ReadDir(GetDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Slice(ids, func(i int, j int) bool {
+		return idLess(ids[i], ids[j])
+	})
+
+	return ids, nil
+}
+
+// ids sort by time, then by the numeric suffix of snapshots taken within
+// the same second
+func idLess(a string, b string) bool {
+	aTime, aCount := splitId(a)
+	bTime, bCount := splitId(b)
+	if aTime != bTime {
+		return aTime < bTime
+	}
+
+	return aCount < bCount
+}
+
+func splitId(id string) (string, int) {
+	// "20060102-150405" has a single dash
+	parts := strings.SplitN(id, "-", 3)
+	if len(parts) < 3 {
+		return id, 0
+	}
+
+	count, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return id, 0
+	}
+
+	return parts[0] + "-" + parts[1], count
+}
+
+func Exists(id string) bool {
+	// ids are plain dir names
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return false
+	}
+
+	info, err := os.Stat(GetPath(id))
+	return err == nil && info.IsDir()
+}
+
+func Load(id string) (Info, error) {
+	info := Info{Id: id}
+
+	data, err := os.ReadFile(GetPath(id) + "/" + infoFile)
+	if err != nil {
+		return info, err
+	}
+
+	err = yaml.Unmarshal(data, &info)
+	return info, err
+}
+
+// remove the oldest snapshots beyond backup.keep, 0 keeps all
+func Rotate() error {
+	keep := settings.LoadConfig().Backup.Keep
+	if keep <= 0 {
+		return nil
+	}
+
+	ids, err := List()
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > keep {
+		err = os.RemoveAll(GetPath(ids[0]))
+		if err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+type entry struct {
+	isDir  bool
+	isLink bool
+	data   []byte
+	perm   fs.FileMode
+}
+
+// files, links and dirs under root by relative path
+func readTree(root string) (map[string]entry, error) {
+	entries := map[string]entry{}
+
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && filePath == root {
+				return nil
+			}
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, filePath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, linkErr := os.Readlink(filePath)
+			if linkErr != nil {
+				return linkErr
+			}
+			entries[rel] = entry{isLink: true, data: []byte(link)}
+		case info.IsDir():
+			entries[rel] = entry{isDir: true, perm: info.Mode().Perm()}
+		default:
+			data, readErr := os.ReadFile(filePath)
+			if readErr != nil {
+				return readErr
+			}
+			entries[rel] = entry{data: data, perm: info.Mode().Perm()}
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// stage changes that bring every snapshotted path back to the state of
+// snapshot id. unchanged paths are left alone.
+func Stage(tx *transaction.Transaction, id string) error {
+	if !Exists(id) {
+		return errors.New("snapshot(Stage): snapshot '" + id + "' does not exist")
+	}
+
+	for _, s := range getSources() {
+		wanted, err := readTree(filepath.Join(GetPath(id), s.Name))
+		if err != nil {
+			return err
+		}
+		current, err := readTree(s.Path)
+		if err != nil {
+			return err
+		}
+
+		// remove what the snapshot doesn't have, parents before children
+		var removed []string
+		for _, rel := range sortedKeys(current) {
+			if isUnder(rel, removed) {
+				continue
+			}
+			want, found := wanted[rel]
+			have := current[rel]
+			if !found || want.isDir != have.isDir || want.isLink != have.isLink {
+				tx.Remove(filepath.Join(s.Path, rel))
+				removed = append(removed, rel)
+			}
+		}
+
+		// create what is missing or different, parents before children
+		for _, rel := range sortedKeys(wanted) {
+			want := wanted[rel]
+			have, found := current[rel]
+			if found && isUnder(rel, removed) {
+				found = false
+			}
+			targetPath := filepath.Join(s.Path, rel)
+
+			switch {
+			case want.isDir:
+				if !found {
+					tx.Mkdir(targetPath, want.perm)
+				}
+			case want.isLink:
+				if !found || string(have.data) != string(want.data) {
+					tx.Symlink(string(want.data), targetPath)
+				}
+			default:
+				if !found || string(have.data) != string(want.data) {
+					tx.WriteFile(targetPath, want.data, want.perm)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(entries map[string]entry) []string {
+	var keys []string
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// rel is one of dirs or inside one of them
+func isUnder(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel == dir || dir == "." || strings.HasPrefix(rel, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
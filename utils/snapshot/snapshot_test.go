@@ -0,0 +1,168 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	"nickneal.dev/go-proxymanager/utils/settings"
+	"nickneal.dev/go-proxymanager/utils/transaction"
+)
+
+// config with every snapshotted path in a temp dir
+func SetTestConfig(t *testing.T, keep int) string {
+	dir := t.TempDir()
+	config := settings.DefaultConfig()
+	config.LoadBalancer.HostsFile = dir + "/hosts"
+	config.Proxy.NginxDir = dir + "/nginx"
+	config.Proxy.SpecDir = dir + "/specs"
+	config.Backup.Dir = dir + "/snapshots"
+	config.Backup.Keep = keep
+
+	configData, _ := yaml.Marshal(config)
+	os.WriteFile(dir+"/proxymanager.yml", configData, 0644)
+	os.Setenv("PROXYMANAGER_CONFIG_PATH", dir+"/proxymanager.yml")
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true")
+
+	_ = os.MkdirAll(dir+"/nginx/sites-available/k8s_test", 0755)
+	_ = os.MkdirAll(dir+"/nginx/sites-enabled", 0755)
+	_ = os.WriteFile(dir+"/hosts", []byte("10.0.0.1 node01.local"), 0644)
+	_ = os.WriteFile(dir+"/nginx/sites-available/a.local.conf", []byte("a\n"), 0644)
+	_ = os.WriteFile(dir+"/nginx/sites-available/k8s_test/b.local.conf", []byte("b\n"), 0644)
+	_ = os.Symlink(dir+"/nginx/sites-available/a.local.conf", dir+"/nginx/sites-enabled/a.local.conf")
+
+	return dir
+}
+
+// snapshotted paths as "path=content", symlinks as "path->target"
+func ReadState(t *testing.T, dir string) string {
+	var entries []string
+	for _, s := range getSources() {
+		tree, err := readTree(s.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for rel, e := range tree {
+			switch {
+			case e.isLink:
+				entries = append(entries, s.Name+"/"+rel+"->"+string(e.data))
+			case e.isDir:
+				entries = append(entries, s.Name+"/"+rel+"/")
+			default:
+				entries = append(entries, s.Name+"/"+rel+"="+string(e.data))
+			}
+		}
+	}
+	sort.Strings(entries)
+
+	return strings.Join(entries, "\n")
+}
+
+func TestCreateRestore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	dir := SetTestConfig(t, 0)
+	defer os.Clearenv()
+	original := ReadState(t, dir)
+
+	id, err := Create("lb test add node02.local 10.0.0.2")
+	if err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	info, err := Load(id)
+	if err != nil || info.Command != "lb test add node02.local 10.0.0.2" || info.Id != id {
+		t.Errorf("Expected info of '%v', received '%v' (%v)", id, info, err)
+	}
+
+	// change every kind of path
+	_ = os.WriteFile(dir+"/hosts", []byte("10.0.0.2 node02.local\n"), 0644)
+	_ = os.Remove(dir + "/nginx/sites-enabled/a.local.conf")
+	_ = os.Symlink(dir+"/nginx/sites-available/k8s_test/b.local.conf", dir+"/nginx/sites-enabled/b.local.conf")
+	_ = os.RemoveAll(dir + "/nginx/sites-available/k8s_test")
+	_ = os.MkdirAll(dir+"/nginx/sites-available/k8s_new", 0755)
+	_ = os.WriteFile(dir+"/nginx/sites-available/k8s_new/c.local.conf", []byte("c\n"), 0644)
+	_ = os.MkdirAll(dir+"/specs", 0755)
+	_ = os.WriteFile(dir+"/specs/c.local.yml", []byte("hostname: c.local\n"), 0644)
+
+	if ReadState(t, dir) == original {
+		t.Fatalf("Expected changed state")
+	}
+
+	tx := transaction.New()
+	if err := Stage(tx, id); err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+	if err := tx.Commit(transaction.NginxReload); err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	if output := ReadState(t, dir); output != original {
+		t.Errorf("Expected '%v', received '%v'", original, output)
+	}
+
+	// nothing to do once restored
+	tx = transaction.New()
+	if err := Stage(tx, id); err != nil || !tx.Empty() {
+		t.Errorf("Expected no changes, received %v (%v)", tx.Changes(), err)
+	}
+
+	if err := Stage(transaction.New(), "../"+id); err == nil {
+		t.Errorf("Expected error for path outside of backup.dir")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	SetTestConfig(t, 2)
+	defer os.Clearenv()
+
+	var created []string
+	for index := 0; index < 12; index++ {
+		id, err := Create("backup create")
+		if err != nil {
+			t.Fatalf("Error occured %v", err)
+		}
+		created = append(created, id)
+	}
+
+	// partial snapshots are hidden and cleaned up
+	entries, _ := os.ReadDir(GetDir())
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 snapshots, received %v", len(entries))
+	}
+
+	ids, _ := List()
+	if strings.Join(ids, ",") != strings.Join(created[10:], ",") {
+		t.Errorf("Expected '%v', received '%v'", created[10:], ids)
+	}
+
+	if !Exists(ids[0]) || Exists(created[0]) || Exists("") || Exists(filepath.Base(GetDir())) {
+		t.Errorf("Expected only kept snapshots to exist")
+	}
+}
+
+func TestIdLess(t *testing.T) {
+	tests := []struct {
+		A        string
+		B        string
+		Expected bool
+	}{
+		{"20240101-120000", "20240101-120000-1", true},
+		{"20240101-120000-2", "20240101-120000-10", true},
+		{"20240101-120000-10", "20240101-120001", true},
+		{"20240101-120001", "20240101-120000-10", false},
+	}
+
+	for _, test := range tests {
+		if output := idLess(test.A, test.B); output != test.Expected {
+			t.Errorf("'%v' < '%v': Expected %v, received %v", test.A, test.B, test.Expected, output)
+		}
+	}
+}
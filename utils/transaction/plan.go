@@ -0,0 +1,228 @@
+package transaction
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"nickneal.dev/go-proxymanager/utils/diff"
+)
+
+// set by --dry-run, commits print the planned changes instead of applying
+var dryRun bool
+
+func SetDryRun(enabled bool) {
+	dryRun = enabled
+}
+
+func DryRun() bool {
+	return dryRun
+}
+
+// state of a path in the plan, exists false for removed paths
+type planEntry struct {
+	exists bool
+	isLink bool
+	// file content or link target
+	data string
+}
+
+// changes simulated on top of the filesystem, nothing is written
+type plan struct {
+	entries map[string]planEntry
+	// created and removed dirs
+	dirs        []string
+	removedDirs []string
+	// paths in order of first change
+	touched []string
+}
+
+func readEntry(filePath string) (planEntry, error) {
+	info, err := os.Lstat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return planEntry{}, nil
+	}
+	if err != nil {
+		return planEntry{}, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, linkErr := os.Readlink(filePath)
+		return planEntry{exists: true, isLink: true, data: target}, linkErr
+	}
+
+	data, err := os.ReadFile(filePath)
+	return planEntry{exists: true, data: string(data)}, err
+}
+
+func (p *plan) get(filePath string) (planEntry, error) {
+	if entry, found := p.entries[filePath]; found {
+		return entry, nil
+	}
+
+	return readEntry(filePath)
+}
+
+func (p *plan) set(filePath string, entry planEntry) {
+	if _, found := p.entries[filePath]; !found {
+		p.touched = append(p.touched, filePath)
+	}
+	p.entries[filePath] = entry
+}
+
+// files and links at or under root, as they are in the plan
+func (p *plan) list(root string) ([]string, error) {
+	found := map[string]bool{}
+
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			found[filePath] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for filePath, entry := range p.entries {
+		if filePath == root || strings.HasPrefix(filePath, root+string(filepath.Separator)) {
+			found[filePath] = entry.exists
+		}
+	}
+
+	var paths []string
+	for filePath, exists := range found {
+		if exists {
+			paths = append(paths, filePath)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func (p *plan) apply(change Change) error {
+	filePath := filepath.Clean(change.Path)
+
+	switch change.Action {
+	case ActionWrite:
+		// writes go through symlinks like atomicfile does
+		entry, err := p.get(filePath)
+		if err != nil {
+			return err
+		}
+		if entry.isLink {
+			target := entry.data
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(filePath), target)
+			}
+			filePath = filepath.Clean(target)
+		}
+		p.set(filePath, planEntry{exists: true, data: string(change.Data)})
+	case ActionSymlink:
+		p.set(filePath, planEntry{exists: true, isLink: true, data: change.Target})
+	case ActionRemove:
+		if info, err := os.Lstat(filePath); err == nil && info.IsDir() {
+			p.removedDirs = append(p.removedDirs, filePath)
+		}
+
+		paths, err := p.list(filePath)
+		if err != nil {
+			return err
+		}
+		for _, removed := range paths {
+			p.set(removed, planEntry{})
+		}
+	case ActionMkdir:
+		// the same dir may be staged by several changes
+		if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) && !slices.Contains(p.dirs, filePath) {
+			p.dirs = append(p.dirs, filePath)
+		}
+	case ActionRename:
+		paths, err := p.list(filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Clean(change.Target)
+		for _, moved := range paths {
+			entry, _ := p.get(moved)
+			p.set(target+strings.TrimPrefix(moved, filePath), entry)
+			p.set(moved, planEntry{})
+		}
+	default:
+		return errors.New("transaction(plan): unknown action '" + string(change.Action) + "'")
+	}
+
+	return nil
+}
+
+// diff content, links show their target
+func (e planEntry) String() string {
+	if e.isLink {
+		return "-> " + e.data + "\n"
+	}
+
+	return e.data
+}
+
+// unified diff of every path the staged changes would touch, nothing is
+// written. new and removed dirs are listed first.
+func (t *Transaction) Diff() (string, error) {
+	p := &plan{entries: map[string]planEntry{}}
+	for _, change := range t.changes {
+		err := p.apply(change)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var builder strings.Builder
+	for _, dir := range p.dirs {
+		builder.WriteString("new directory " + dir + "\n")
+	}
+	for _, dir := range p.removedDirs {
+		builder.WriteString("removed directory " + dir + "\n")
+	}
+
+	for _, filePath := range p.touched {
+		before, err := readEntry(filePath)
+		if err != nil {
+			return "", err
+		}
+		after := p.entries[filePath]
+
+		fromName, toName := filePath, filePath
+		if !before.exists {
+			fromName = "/dev/null"
+		}
+		if !after.exists {
+			toName = "/dev/null"
+		}
+
+		// a file replaced by a link (or the other way around) shows both
+		if before.exists && after.exists && before.isLink != after.isLink {
+			builder.WriteString(diff.Unified(fromName, "/dev/null", before.String(), ""))
+			builder.WriteString(diff.Unified("/dev/null", toName, "", after.String()))
+			continue
+		}
+
+		fileDiff := diff.Unified(fromName, toName, before.String(), after.String())
+		// empty files have no lines to show
+		if fileDiff == "" && before.exists != after.exists {
+			fileDiff = "--- " + fromName + "\n+++ " + toName + "\n"
+		}
+		builder.WriteString(fileDiff)
+	}
+
+	return builder.String(), nil
+}
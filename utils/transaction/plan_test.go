@@ -0,0 +1,85 @@
+package transaction
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	dir := SetupTree(t)
+	original := ReadTree(t, dir)
+
+	tx := New()
+	StageAll(tx, dir)
+	// write through the enabled symlink after repointing it
+	tx.WriteLines(dir+"/sites-enabled/a.local.conf", []string{"c2"}, 0644)
+
+	output, err := tx.Diff()
+	if err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"new directory " + dir + "/backups/specs",
+		"--- " + dir + "/hosts",
+		"+++ " + dir + "/hosts",
+		"@@ -1 +1 @@",
+		"-10.0.0.1 node01.local",
+		"+10.0.0.2 node02.local",
+		"--- /dev/null",
+		"+++ " + dir + "/sites-available/c.local.conf",
+		"@@ -0,0 +1 @@",
+		"+c2",
+		"--- " + dir + "/sites-enabled/a.local.conf",
+		"+++ " + dir + "/sites-enabled/a.local.conf",
+		"@@ -1 +1 @@",
+		"--> " + dir + "/sites-available/a.local.conf",
+		"+-> " + dir + "/sites-available/c.local.conf",
+		"--- " + dir + "/sites-available/a.local.conf",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-a",
+		"--- /dev/null",
+		"+++ " + dir + "/backups/k8s_test/b.local.conf",
+		"@@ -0,0 +1 @@",
+		"+b",
+		"--- " + dir + "/sites-available/k8s_test/b.local.conf",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-b",
+		"",
+	}, "\n")
+	if output != expected {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+
+	// planning writes nothing
+	if tree := ReadTree(t, dir); tree != original {
+		t.Errorf("Expected '%v', received '%v'", original, tree)
+	}
+}
+
+func TestCommitDryRun(t *testing.T) {
+	SetDryRun(true)
+	defer SetDryRun(false)
+
+	dir := SetupTree(t)
+	original := ReadTree(t, dir)
+
+	tx := New()
+	StageAll(tx, dir)
+
+	if err := tx.Commit(NginxReload); !errors.Is(err, ErrDryRun) {
+		t.Errorf("Expected '%v', received '%v'", ErrDryRun, err)
+	}
+
+	if tree := ReadTree(t, dir); tree != original {
+		t.Errorf("Expected '%v', received '%v'", original, tree)
+	}
+}
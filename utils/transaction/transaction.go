@@ -0,0 +1,380 @@
+// staged file changes applied as one unit
+// commands stage writes, symlinks, removals, dirs and renames, then commit.
+// commit applies every change, validates/reloads nginx once and on any
+// failure puts every touched path back into its prior state.
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"nickneal.dev/go-proxymanager/utils/atomicfile"
+	"nickneal.dev/go-proxymanager/utils/nginx"
+	"nickneal.dev/go-proxymanager/utils/settings"
+)
+
+type Action string
+
+const (
+	ActionWrite   Action = "write"
+	ActionSymlink Action = "symlink"
+	ActionRemove  Action = "remove"
+	ActionMkdir   Action = "mkdir"
+	ActionRename  Action = "rename"
+)
+
+// what Commit does with nginx once changes are applied
+type NginxAction int
+
+const (
+	// changes nginx doesn't load, e.g. configs of disabled sites
+	NginxSkip NginxAction = iota
+	// validate the config with 'nginx -t'
+	NginxCheck
+	// validate and reload
+	NginxReload
+)
+
+type Change struct {
+	Action Action
+	Path   string
+	// content of ActionWrite
+	Data []byte
+	Perm fs.FileMode
+	// link target of ActionSymlink, destination of ActionRename
+	Target string
+}
+
+type Transaction struct {
+	// clusters and hosts the changes are about, recorded in the audit log
+	Clusters []string
+	Hosts    []string
+
+	changes []Change
+
+	// undo functions of applied changes, run in reverse on rollback
+	undos []func() error
+	// run once the transaction succeeded, e.g. to delete removed files
+	cleanups []func()
+	// counter for names of removed paths
+	removed int
+}
+
+// replaced in tests to simulate nginx failures
+var (
+	nginxCheck  = nginx.CheckNginxConfig
+	nginxReload = nginx.ReloadNginx
+)
+
+func New() *Transaction {
+	return &Transaction{}
+}
+
+func (t *Transaction) WriteFile(filePath string, data []byte, perm fs.FileMode) {
+	t.changes = append(t.changes, Change{Action: ActionWrite, Path: filePath, Data: data, Perm: perm})
+}
+
+// write lines, each terminated by a newline
+func (t *Transaction) WriteLines(filePath string, fileLines []string, perm fs.FileMode) {
+	var data []byte
+	for _, str := range fileLines {
+		data = append(data, []byte(str+"\n")...)
+	}
+
+	t.WriteFile(filePath, data, perm)
+}
+
+// create or repoint a symlink at linkPath
+func (t *Transaction) Symlink(target string, linkPath string) {
+	t.changes = append(t.changes, Change{Action: ActionSymlink, Path: linkPath, Target: target})
+}
+
+// remove a file, symlink or dir tree. missing paths are ignored.
+func (t *Transaction) Remove(filePath string) {
+	t.changes = append(t.changes, Change{Action: ActionRemove, Path: filePath})
+}
+
+// create dir and missing parents
+func (t *Transaction) Mkdir(dir string, perm fs.FileMode) {
+	t.changes = append(t.changes, Change{Action: ActionMkdir, Path: dir, Perm: perm})
+}
+
+// move a file or dir, newPath must not exist
+func (t *Transaction) Rename(oldPath string, newPath string) {
+	t.changes = append(t.changes, Change{Action: ActionRename, Path: oldPath, Target: newPath})
+}
+
+// record the clusters and hosts a change is about, "" is skipped
+func (t *Transaction) AddCluster(clusters ...string) {
+	for _, cluster := range clusters {
+		if cluster != "" && !slices.Contains(t.Clusters, cluster) {
+			t.Clusters = append(t.Clusters, cluster)
+		}
+	}
+}
+
+func (t *Transaction) AddHost(hosts ...string) {
+	for _, host := range hosts {
+		if host != "" && !slices.Contains(t.Hosts, host) {
+			t.Hosts = append(t.Hosts, host)
+		}
+	}
+}
+
+// staged changes in order
+func (t *Transaction) Changes() []Change {
+	return t.changes
+}
+
+func (t *Transaction) Empty() bool {
+	return len(t.changes) == 0
+}
+
+func (t *Transaction) apply(change Change) error {
+	switch change.Action {
+	case ActionWrite:
+		return t.applyWrite(change)
+	case ActionSymlink:
+		return t.applySymlink(change)
+	case ActionRemove:
+		return t.applyRemove(change)
+	case ActionMkdir:
+		return t.applyMkdir(change)
+	case ActionRename:
+		return t.applyRename(change)
+	}
+
+	return errors.New("transaction(apply): unknown action '" + string(change.Action) + "'")
+}
+
+func (t *Transaction) applyWrite(change Change) error {
+	data, err := os.ReadFile(change.Path)
+	switch {
+	case err == nil:
+		info, statErr := os.Stat(change.Path)
+		if statErr != nil {
+			return statErr
+		}
+		t.undos = append(t.undos, func() error {
+			return atomicfile.WriteFile(change.Path, data, info.Mode().Perm())
+		})
+	case errors.Is(err, os.ErrNotExist):
+		t.undos = append(t.undos, func() error {
+			return removeIfExists(change.Path)
+		})
+	default:
+		return err
+	}
+
+	return atomicfile.WriteFile(change.Path, change.Data, change.Perm)
+}
+
+func (t *Transaction) applySymlink(change Change) error {
+	info, err := os.Lstat(change.Path)
+	switch {
+	case err == nil:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return errors.New("transaction(applySymlink): '" + change.Path + "' exists and is not a symlink")
+		}
+		oldTarget, linkErr := os.Readlink(change.Path)
+		if linkErr != nil {
+			return linkErr
+		}
+		t.undos = append(t.undos, func() error {
+			return symlink(oldTarget, change.Path)
+		})
+	case errors.Is(err, os.ErrNotExist):
+		t.undos = append(t.undos, func() error {
+			return removeIfExists(change.Path)
+		})
+	default:
+		return err
+	}
+
+	return symlink(change.Target, change.Path)
+}
+
+// removed paths are moved aside as hidden files and deleted on success
+func (t *Transaction) applyRemove(change Change) error {
+	_, err := os.Lstat(change.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t.removed++
+	removedPath := filepath.Join(filepath.Dir(change.Path), "."+filepath.Base(change.Path)+".removed-"+strconv.Itoa(os.Getpid())+"-"+strconv.Itoa(t.removed))
+	err = os.Rename(change.Path, removedPath)
+	if err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return os.Rename(removedPath, change.Path)
+	})
+	t.cleanups = append(t.cleanups, func() {
+		_ = os.RemoveAll(removedPath)
+	})
+
+	return nil
+}
+
+func (t *Transaction) applyMkdir(change Change) error {
+	// find dirs that will be created, outermost first
+	var created []string
+	for dir := filepath.Clean(change.Path); ; dir = filepath.Dir(dir) {
+		_, err := os.Stat(dir)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		created = append([]string{dir}, created...)
+
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	t.undos = append(t.undos, func() error {
+		for index := len(created) - 1; index >= 0; index-- {
+			err := removeIfExists(created[index])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return os.MkdirAll(change.Path, change.Perm)
+}
+
+func (t *Transaction) applyRename(change Change) error {
+	_, err := os.Lstat(change.Target)
+	if err == nil {
+		return errors.New("transaction(applyRename): '" + change.Target + "' already exists")
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = os.Rename(change.Path, change.Target)
+	if err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return os.Rename(change.Target, change.Path)
+	})
+
+	return nil
+}
+
+// apply staged changes in order, on failure everything applied so far is
+// rolled back
+func (t *Transaction) Apply() error {
+	for _, change := range t.changes {
+		err := t.apply(change)
+		if err != nil {
+			return t.fail(err)
+		}
+	}
+
+	return nil
+}
+
+// put every touched path back, undoing the latest change first
+func (t *Transaction) Rollback() error {
+	var errs []error
+	for index := len(t.undos) - 1; index >= 0; index-- {
+		err := t.undos[index]()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.undos = nil
+	t.cleanups = nil
+
+	return errors.Join(errs...)
+}
+
+// files may be left half changed
+var ErrRollbackFailed = errors.New("transaction: rollback failed")
+
+func (t *Transaction) fail(err error) error {
+	rollbackErr := t.Rollback()
+	if rollbackErr != nil {
+		return errors.Join(err, fmt.Errorf("%w: %v", ErrRollbackFailed, rollbackErr))
+	}
+
+	return err
+}
+
+var ErrDryRun = errors.New("transaction: dry run, nothing was changed")
+
+// apply changes, then check or reload nginx once. on any failure all
+// changes are rolled back. nginx is skipped in dev mode.
+func (t *Transaction) Commit(action NginxAction) error {
+	if dryRun {
+		return ErrDryRun
+	}
+
+	err := t.Apply()
+	if err != nil {
+		return err
+	}
+
+	if !settings.CheckDevMode() {
+		switch action {
+		case NginxCheck:
+			err = nginxCheck()
+		case NginxReload:
+			err = nginxReload()
+		}
+		if err != nil {
+			return t.fail(err)
+		}
+	}
+
+	for _, cleanup := range t.cleanups {
+		cleanup()
+	}
+	t.undos = nil
+	t.cleanups = nil
+
+	return nil
+}
+
+func symlink(target string, linkPath string) error {
+	tempPath := filepath.Join(filepath.Dir(linkPath), "."+filepath.Base(linkPath)+".tmp")
+
+	_ = os.Remove(tempPath)
+	err := os.Symlink(target, tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tempPath, linkPath)
+	if err != nil {
+		os.Remove(tempPath)
+	}
+
+	return err
+}
+
+func removeIfExists(filePath string) error {
+	err := os.Remove(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,199 @@
+package transaction
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// snapshot of dir as "path=content", symlinks as "path->target"
+func ReadTree(t *testing.T, dir string) string {
+	var entries []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, _ := os.Readlink(path)
+			entries = append(entries, rel+"->"+target)
+		case info.IsDir():
+			entries = append(entries, rel+"/")
+		default:
+			data, _ := os.ReadFile(path)
+			entries = append(entries, rel+"="+string(data))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
+
+// dir with an available and an enabled site
+func SetupTree(t *testing.T) string {
+	dir := t.TempDir()
+	_ = os.MkdirAll(dir+"/sites-available/k8s_test", 0755)
+	_ = os.MkdirAll(dir+"/sites-enabled", 0755)
+	_ = os.WriteFile(dir+"/hosts", []byte("10.0.0.1 node01.local\n"), 0644)
+	_ = os.WriteFile(dir+"/sites-available/a.local.conf", []byte("a\n"), 0644)
+	_ = os.WriteFile(dir+"/sites-available/k8s_test/b.local.conf", []byte("b\n"), 0644)
+	_ = os.Symlink(dir+"/sites-available/a.local.conf", dir+"/sites-enabled/a.local.conf")
+
+	return dir
+}
+
+// stage one change of every action
+func StageAll(tx *Transaction, dir string) {
+	tx.WriteLines(dir+"/hosts", []string{"10.0.0.2 node02.local"}, 0644)
+	tx.WriteFile(dir+"/sites-available/c.local.conf", []byte("c\n"), 0644)
+	tx.Symlink(dir+"/sites-available/c.local.conf", dir+"/sites-enabled/a.local.conf")
+	tx.Remove(dir + "/sites-available/a.local.conf")
+	tx.Mkdir(dir+"/backups/specs", 0755)
+	tx.Rename(dir+"/sites-available/k8s_test", dir+"/backups/k8s_test")
+}
+
+func TestCommit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true")
+	defer os.Clearenv()
+
+	dir := SetupTree(t)
+	original := ReadTree(t, dir)
+
+	tx := New()
+	StageAll(tx, dir)
+
+	if len(tx.Changes()) != 6 || tx.Empty() {
+		t.Errorf("Expected 6 staged changes, received %v", len(tx.Changes()))
+	}
+
+	// nothing is touched before commit
+	if output := ReadTree(t, dir); output != original {
+		t.Errorf("Expected '%v', received '%v'", original, output)
+	}
+
+	if err := tx.Commit(NginxReload); err != nil {
+		t.Fatalf("Error occured %v", err)
+	}
+
+	expected := strings.Join([]string{
+		"./",
+		"backups/",
+		"backups/k8s_test/",
+		"backups/k8s_test/b.local.conf=b\n",
+		"backups/specs/",
+		"hosts=10.0.0.2 node02.local\n",
+		"sites-available/",
+		"sites-available/c.local.conf=c\n",
+		"sites-enabled/",
+		"sites-enabled/a.local.conf->" + dir + "/sites-available/c.local.conf",
+	}, "\n")
+	if output := ReadTree(t, dir); output != expected {
+		t.Errorf("Expected '%v', received '%v'", expected, output)
+	}
+}
+
+func TestCommitRollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	failure := errors.New("nginx: [emerg] unknown directive")
+	originalCheck, originalReload := nginxCheck, nginxReload
+	defer func() {
+		nginxCheck, nginxReload = originalCheck, originalReload
+		os.Clearenv()
+	}()
+
+	var calls []string
+	nginxCheck = func() error { calls = append(calls, "check"); return failure }
+	nginxReload = func() error { calls = append(calls, "reload"); return failure }
+
+	tests := []struct {
+		Name   string
+		Dev    bool
+		Action NginxAction
+		Stage  func(tx *Transaction, dir string)
+		Calls  string
+		Failed bool
+	}{
+		{"reload", false, NginxReload, StageAll, "reload", true},
+		{"check", false, NginxCheck, StageAll, "check", true},
+		{"skip", false, NginxSkip, StageAll, "", false},
+		{"dev mode", true, NginxReload, StageAll, "", false},
+		// later change fails, earlier ones are undone
+		{"rename onto existing", true, NginxSkip, func(tx *Transaction, dir string) {
+			StageAll(tx, dir)
+			tx.Rename(dir+"/hosts", dir+"/backups/k8s_test")
+		}, "", true},
+		{"symlink over file", true, NginxSkip, func(tx *Transaction, dir string) {
+			StageAll(tx, dir)
+			tx.Symlink(dir+"/hosts", dir+"/sites-available/c.local.conf")
+		}, "", true},
+		{"write to missing dir", true, NginxSkip, func(tx *Transaction, dir string) {
+			StageAll(tx, dir)
+			tx.WriteFile(dir+"/missing/d.local.conf", []byte("d\n"), 0644)
+		}, "", true},
+	}
+
+	for _, test := range tests {
+		os.Clearenv()
+		if test.Dev {
+			os.Setenv("PROXYMANAGER_DEV_MODE", "true")
+		}
+		calls = nil
+
+		dir := SetupTree(t)
+		original := ReadTree(t, dir)
+
+		tx := New()
+		test.Stage(tx, dir)
+		err := tx.Commit(test.Action)
+
+		if strings.Join(calls, ",") != test.Calls {
+			t.Errorf("Test '%v': Expected nginx calls '%v', received '%v'", test.Name, test.Calls, strings.Join(calls, ","))
+		}
+
+		if !test.Failed {
+			if err != nil {
+				t.Errorf("Test '%v': Expected no error, received %v", test.Name, err)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("Test '%v': Expected error", test.Name)
+		}
+
+		// every touched path is back, no temp or removed files are left
+		if output := ReadTree(t, dir); output != original {
+			t.Errorf("Test '%v': Expected '%v', received '%v'", test.Name, original, output)
+		}
+	}
+}
+
+func TestRemoveMissing(t *testing.T) {
+	os.Setenv("PROXYMANAGER_DEV_MODE", "true")
+	defer os.Clearenv()
+
+	dir := t.TempDir()
+	tx := New()
+	tx.Remove(dir + "/missing.conf")
+
+	if err := tx.Commit(NginxSkip); err != nil {
+		t.Errorf("Expected no error removing missing path, received %v", err)
+	}
+}